@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jeff/claude-config-merge/internal/config"
+)
+
+// Outcomes of the all subcommand, as recorded in the report.
+const (
+	outcomeApplied  = "applied"  // every step succeeded
+	outcomeReverted = "reverted" // a step failed and no change was left behind
+	outcomePartial  = "partial"  // a step failed and the rollback did too
+)
+
+// runAll runs the settings, agents, and skills steps as one transaction. All
+// three are first staged as a dry run, so problems such as an unreadable
+// master file stop the command before anything is written. If a step then
+// fails while applying, every file already changed is restored from
+// opts.session, which must be set. The command ends fully applied or fully
+// reverted, and says which.
+func runAll(cfg *config.Config, claudeDir string, opts runOptions, w io.Writer) error {
+	staged := opts
+	staged.dryRun = true
+	staged.interactive = false
+	staged.session = nil
+	staged.report = nil
+	if err := runSteps("all", cfg, claudeDir, staged, io.Discard); err != nil {
+		fmt.Fprintf(w, "All: not applied, nothing changed: %v\n", err)
+		opts.report.setOutcome(outcomeReverted)
+		return err
+	}
+
+	err := runSteps("all", cfg, claudeDir, opts, w)
+	if err == nil {
+		fmt.Fprintf(w, "\nAll: every step applied.\n")
+		opts.report.setOutcome(outcomeApplied)
+		return nil
+	}
+
+	n := len(opts.session.Entries())
+	if n == 0 {
+		fmt.Fprintf(w, "\nAll: failed before anything was written; nothing changed.\n")
+		opts.report.setOutcome(outcomeReverted)
+		return err
+	}
+	if rbErr := opts.session.Rollback(); rbErr != nil {
+		fmt.Fprintf(w, "\nAll: failed, and rolling back failed too: %v\n", rbErr)
+		fmt.Fprintf(w, "Files may be partly applied; their prior state is in %s\n", opts.session.Dir())
+		opts.report.setOutcome(outcomePartial)
+		return errors.Join(err, fmt.Errorf("rolling back: %w", rbErr))
+	}
+	fmt.Fprintf(w, "\nAll: failed, rolled back %d file(s); nothing changed.\n", n)
+	opts.report.setOutcome(outcomeReverted)
+	return fmt.Errorf("%w (all changes rolled back)", err)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/jeff/claude-config-merge/internal/backup"
+)
+
+func TestDispatch_AllRollsBackWhenAStepFails(t *testing.T) {
+	cfg, homeDir := setupForcedRun(t)
+	claudeDir := filepath.Join(homeDir, ".claude")
+	// A named pipe where master's skill goes passes the staged run, but
+	// cannot be backed up, so the skills step fails after settings and agents
+	// were written.
+	blockSkill(t, claudeDir)
+
+	var buf bytes.Buffer
+	err := dispatch("all", []string{"-f"}, cfg, homeDir, &buf)
+	if err == nil {
+		t.Fatalf("all -f: want error, got nil; output:\n%s", buf.String())
+	}
+	out := buf.String()
+	if !strings.Contains(out, "Agents") || !strings.Contains(out, "rolled back") {
+		t.Errorf("want the applied steps and a rollback note, got:\n%s", out)
+	}
+	if strings.Contains(out, "Undo with") {
+		t.Errorf("no undo hint expected after a rollback, got:\n%s", out)
+	}
+
+	if got := readJSON(t, filepath.Join(claudeDir, "settings.json"))["model"]; got != "sonnet" {
+		t.Errorf("model = %v; want sonnet after rollback", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(claudeDir, "agents", "a.md")); string(got) != "my agent" {
+		t.Errorf("agents/a.md = %q; want my agent after rollback", got)
+	}
+	for _, name := range []string{".claude-config-merge-base-settings.json", ".claude-config-merge-manifest.json"} {
+		if _, err := os.Stat(filepath.Join(claudeDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should not exist after rollback (stat err = %v)", name, err)
+		}
+	}
+	sessions, err := backup.ListSessions(claudeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("sessions = %v; want none after a full rollback", sessions)
+	}
+}
+
+func TestDispatch_AllRollsBackSnapshotOfUnchangedSettings(t *testing.T) {
+	cfg, homeDir := setupForcedRun(t)
+	claudeDir := filepath.Join(homeDir, ".claude")
+	// settings.json is up to date, so the run only records its snapshot
+	// before the skills step fails as above.
+	writeJSON(t, filepath.Join(claudeDir, "settings.json"), map[string]any{"model": "opus"})
+	blockSkill(t, claudeDir)
+
+	if err := dispatch("all", []string{"-f"}, cfg, homeDir, &bytes.Buffer{}); err == nil {
+		t.Fatal("all -f: want error, got nil")
+	}
+	if _, err := os.Stat(filepath.Join(claudeDir, ".claude-config-merge-base-settings.json")); !os.IsNotExist(err) {
+		t.Errorf("snapshot should not exist after rollback (stat err = %v)", err)
+	}
+}
+
+// blockSkill puts a named pipe where master's skill goes in claudeDir, which
+// the skills step cannot back up.
+func blockSkill(t *testing.T, claudeDir string) {
+	t.Helper()
+	skill := filepath.Join(claudeDir, "skills", "new")
+	if err := os.MkdirAll(skill, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(skill, "SKILL.md"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDispatch_AllChecksEveryStepBeforeWriting(t *testing.T) {
+	cfg, homeDir := setupForcedRun(t)
+	claudeDir := filepath.Join(homeDir, ".claude")
+	// A file where the skills directory belongs fails the skills step, which
+	// runs after settings.
+	if err := os.WriteFile(filepath.Join(claudeDir, "skills"), []byte("not a dir"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := dispatch("all", []string{"-f"}, cfg, homeDir, &buf); err == nil {
+		t.Fatalf("all -f: want error, got nil; output:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "nothing changed") {
+		t.Errorf("want a nothing-changed note, got:\n%s", buf.String())
+	}
+	if got := readJSON(t, filepath.Join(claudeDir, "settings.json"))["model"]; got != "sonnet" {
+		t.Errorf("model = %v; want sonnet, settings must not be written", got)
+	}
+	if _, err := os.Stat(filepath.Join(claudeDir, backup.DirName)); !os.IsNotExist(err) {
+		t.Errorf("a failed check should not create backups (stat err = %v)", err)
+	}
+}
+
+func TestDispatch_AllSaysWhenApplied(t *testing.T) {
+	cfg, homeDir := setupForcedRun(t)
+
+	var buf bytes.Buffer
+	if err := dispatch("all", []string{"-f"}, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("all -f: %v", err)
+	}
+	if !strings.Contains(buf.String(), "every step applied") {
+		t.Errorf("want an applied note, got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jeff/claude-config-merge/internal/backup"
+	"github.com/jeff/claude-config-merge/internal/textdiff"
+)
+
+// listBackups returns every backup in claudeDir, newest first: the sessions
+// under its backup directory and the single settings.json.*.bak files
+// written by earlier versions.
+func listBackups(claudeDir string) ([]backup.Backup, error) {
+	sessions, err := backup.ListSessions(claudeDir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := backup.List(filepath.Join(claudeDir, "settings.json"))
+	if err != nil {
+		return nil, err
+	}
+	all := append(sessions, files...)
+	backup.SortNewestFirst(all)
+	return all, nil
+}
+
+// pruneBackups deletes the backups in claudeDir that r does not keep,
+// reporting them to w and rep. A failure leaves extra backups behind and is
+// only reported, since the command it follows has already succeeded.
+func pruneBackups(claudeDir string, r backup.Retention, rep *report, w io.Writer) {
+	if r.IsZero() {
+		return
+	}
+	backups, err := listBackups(claudeDir)
+	if err == nil {
+		var deleted []backup.Backup
+		deleted, err = backup.Prune(backups, r, time.Now())
+		for _, b := range deleted {
+			rep.addDeletedBackup(b.Path)
+		}
+		if len(deleted) > 0 {
+			fmt.Fprintf(w, "Deleted %d old backup(s) (keeping %s)\n", len(deleted), r)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(w, "Warning: pruning old backups: %v\n", err)
+	}
+}
+
+// runBackupsList prints the backups in claudeDir, newest first, with their
+// age, size, and what they hold, and records them in rep.
+func runBackupsList(claudeDir string, now time.Time, rep *report, w io.Writer) error {
+	backups, err := listBackups(claudeDir)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		fmt.Fprintf(w, "No backups found in %s\n", claudeDir)
+		return nil
+	}
+
+	fmt.Fprintf(w, "Backups in %s (newest first):\n", claudeDir)
+	for _, b := range backups {
+		desc, err := describeBackup(claudeDir, b)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "  %-20s  %-9s  %9s  %s\n", b.ID, formatAge(now.Sub(b.Time)), formatSize(b.Size), desc)
+		rep.addAvailableBackup(b)
+	}
+	fmt.Fprintf(w, "\nRestore one with: claude-config-merge restore <timestamp|latest>\n")
+	return nil
+}
+
+// describeBackup summarizes b. A single-file backup is compared line by line
+// with settings.json; a session lists the files it holds.
+func describeBackup(claudeDir string, b backup.Backup) (string, error) {
+	if b.Entries == nil {
+		current, err := readOptional(filepath.Join(claudeDir, "settings.json"))
+		if err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(b.Path)
+		if err != nil {
+			return "", fmt.Errorf("reading backup %s: %w", b.Path, err)
+		}
+		return "settings.json, " + describeChange(current, string(data)), nil
+	}
+
+	names := backupFiles(b)
+	const shown = 3
+	desc := strings.Join(names[:min(len(names), shown)], ", ")
+	if len(names) > shown {
+		desc += fmt.Sprintf(" and %d more", len(names)-shown)
+	}
+	return fmt.Sprintf("%d file(s): %s", len(names), desc), nil
+}
+
+// backupFiles returns the slash-separated paths, relative to the .claude
+// directory, of the files b holds, leaving out the tool's own state files.
+func backupFiles(b backup.Backup) []string {
+	if b.Entries == nil {
+		return []string{"settings.json"}
+	}
+	names := []string{}
+	for _, e := range b.Entries {
+		if !isToolState(e.Path) {
+			names = append(names, e.Path)
+		}
+	}
+	return names
+}
+
+// isToolState reports whether the slash-separated path names one of the
+// tool's own state files, such as the settings snapshot or the manifest.
+func isToolState(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".claude-config-merge-")
+}
+
+// runRestore restores the backup in claudeDir selected by id (a timestamp, a
+// unique timestamp prefix, or "latest"), after showing the diff of every file
+// it changes. A session is rolled back as a whole: its saved files are put
+// back and files its run created are removed. The current state is backed up
+// first, so the restore can itself be undone. With dryRun only the diff is
+// shown. The files restored, or that would be, are recorded in rep.
+func runRestore(claudeDir, id string, dryRun bool, rep *report, w io.Writer) error {
+	backups, err := listBackups(claudeDir)
+	if err != nil {
+		return err
+	}
+	b, err := backup.Select(backups, id)
+	if err != nil {
+		return fmt.Errorf("restore: %w in %s", err, claudeDir)
+	}
+
+	diff, changed, err := restoreDiff(claudeDir, b, rep)
+	if err != nil {
+		return err
+	}
+
+	if changed == 0 {
+		fmt.Fprintf(w, "Backup %s matches the current files; nothing to restore.\n", b.ID)
+		return nil
+	}
+
+	fmt.Fprintf(w, "Restoring backup %s (%s):\n\n", b.ID, formatAge(time.Since(b.Time)))
+	text := diff
+	if useColor(w) {
+		text = colorizeDiff(text)
+	}
+	fmt.Fprint(w, text)
+	fmt.Fprintf(w, "\n")
+
+	if dryRun {
+		fmt.Fprintf(w, "Dry run: would restore %d file(s) (no files changed).\n", changed)
+		return nil
+	}
+
+	undo := backup.NewSession(claudeDir, time.Now())
+	if b.Entries == nil {
+		err = backup.Restore(filepath.Join(claudeDir, "settings.json"), b, undo)
+	} else {
+		err = backup.Rollback(claudeDir, b, undo)
+	}
+	if len(undo.Entries()) > 0 {
+		fmt.Fprintf(w, "Backup created: %s\n", undo.Dir())
+		rep.addBackup(undo.Dir())
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Restored %d file(s) from %s\n", changed, b.Path)
+	return nil
+}
+
+// restoreDiff returns the diff of the files in claudeDir that restoring b
+// changes, and how many there are. Files its run created are listed for
+// removal, links by their target, and the tool's own state files by name
+// only. Each file is recorded in rep.
+func restoreDiff(claudeDir string, b backup.Backup, rep *report) (string, int, error) {
+	entries := b.Entries
+	if entries == nil {
+		entries = []backup.Entry{{Path: "settings.json", Existed: true}}
+	}
+	changed := 0
+	var diff strings.Builder
+	for _, e := range entries {
+		path := filepath.Join(claudeDir, filepath.FromSlash(e.Path))
+		_, statErr := os.Lstat(path)
+		if !e.Existed {
+			if statErr == nil {
+				fmt.Fprintf(&diff, "remove %s (created by that run)\n", path)
+				rep.addRestored(path)
+				changed++
+			}
+			continue
+		}
+		if e.Link != "" {
+			if target, err := os.Readlink(path); err != nil || target != e.Link {
+				fmt.Fprintf(&diff, "restore link %s -> %s\n", path, e.Link)
+				rep.addRestored(path)
+				changed++
+			}
+			continue
+		}
+
+		current, err := readOptional(path)
+		if err != nil {
+			return "", 0, err
+		}
+		saved := b.Path
+		if b.Entries != nil {
+			saved = filepath.Join(b.Path, filepath.FromSlash(e.Path))
+		}
+		data, err := os.ReadFile(saved)
+		if err != nil {
+			return "", 0, fmt.Errorf("reading backup %s: %w", saved, err)
+		}
+		if statErr == nil && current == string(data) {
+			continue
+		}
+		changed++
+		rep.addRestored(path)
+		if isToolState(e.Path) {
+			fmt.Fprintf(&diff, "restore %s\n", path)
+			continue
+		}
+		diff.WriteString(textdiff.Unified(path, saved, current, string(data), diffContext))
+	}
+	return diff.String(), changed, nil
+}
+
+// parseRestoreArgs parses the flags and optional backup id of the restore
+// subcommand. The id defaults to "latest".
+func parseRestoreArgs(args []string) (id string, dryRun bool, err error) {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.BoolVar(&dryRun, "n", false, "show the diff without restoring")
+	fs.BoolVar(&dryRun, "dry-run", false, "show the diff without restoring")
+	if err := fs.Parse(args); err != nil {
+		return "", false, fmt.Errorf("restore: %w", err)
+	}
+	switch fs.NArg() {
+	case 0:
+		return "latest", dryRun, nil
+	case 1:
+		return fs.Arg(0), dryRun, nil
+	default:
+		return "", false, fmt.Errorf("restore: expected at most one backup timestamp, got %q", strings.Join(fs.Args(), " "))
+	}
+}
+
+// readOptional returns the contents of path, or "" if it does not exist.
+func readOptional(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	return string(data), nil
+}
+
+// describeChange summarizes how replacing current with restored would change
+// the file, as added and removed line counts.
+func describeChange(current, restored string) string {
+	if current == restored {
+		return "same as current"
+	}
+	added, removed := textdiff.Stat(current, restored)
+	return fmt.Sprintf("+%d -%d lines vs current", added, removed)
+}
+
+// formatAge renders d as a short, rounded age such as "5m ago".
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d/time.Minute))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%dd ago", int(d/(24*time.Hour)))
+	}
+}
+
+// formatSize renders a byte count as B, KB, or MB.
+func formatSize(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeff/claude-config-merge/internal/backup"
+	"github.com/jeff/claude-config-merge/internal/config"
+)
+
+// writeBackups creates claudeDir/settings.json with current and one
+// settings.json.<id>.bak file per entry of backups, and returns claudeDir.
+func writeBackups(t *testing.T, current string, backups map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	local := filepath.Join(dir, "settings.json")
+	if err := os.WriteFile(local, []byte(current), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for id, content := range backups {
+		if err := os.WriteFile(local+"."+id+".bak", []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRunBackupsList(t *testing.T) {
+	dir := writeBackups(t, "{\n  \"a\": 2\n}\n", map[string]string{
+		"20240101T120000.000": "{\n  \"a\": 1\n}\n",
+		"20240102T120000.000": "{\n  \"a\": 2\n}\n",
+	})
+	session := backup.NewSession(dir, time.Date(2024, 1, 2, 14, 0, 0, 0, time.Local))
+	if _, err := session.Save(filepath.Join(dir, "settings.json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.Save(filepath.Join(dir, "agents", "new.md")); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 2, 15, 0, 0, 0, time.Local)
+
+	var buf bytes.Buffer
+	if err := runBackupsList(dir, now, nil, &buf); err != nil {
+		t.Fatalf("runBackupsList: %v", err)
+	}
+	out := buf.String()
+
+	newest := strings.Index(out, "20240102T140000.000")
+	newer := strings.Index(out, "20240102T120000.000")
+	older := strings.Index(out, "20240101T120000.000")
+	if newest < 0 || newer < newest || older < newer {
+		t.Errorf("want all backups listed newest first, got:\n%s", out)
+	}
+	for _, want := range []string{
+		"1h ago", "3h ago", "27h ago",
+		"2 file(s): settings.json, agents/new.md",
+		"same as current", "+1 -1 lines vs current",
+		"restore <timestamp|latest>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunBackupsList_None(t *testing.T) {
+	dir := writeBackups(t, "{}\n", nil)
+
+	var buf bytes.Buffer
+	if err := runBackupsList(dir, time.Now(), nil, &buf); err != nil {
+		t.Fatalf("runBackupsList: %v", err)
+	}
+	if !strings.Contains(buf.String(), "No backups") {
+		t.Errorf("want 'No backups', got:\n%s", buf.String())
+	}
+}
+
+func TestRunRestore_LegacyFile(t *testing.T) {
+	dir := writeBackups(t, "{\"model\": \"bad\"}\n", map[string]string{
+		"20240101T120000.000": "{\"model\": \"older\"}\n",
+		"20240102T120000.000": "{\"model\": \"good\"}\n",
+	})
+	local := filepath.Join(dir, "settings.json")
+
+	var buf bytes.Buffer
+	rep := newReport("restore", false)
+	if err := runRestore(dir, "latest", false, rep, &buf); err != nil {
+		t.Fatalf("runRestore: %v", err)
+	}
+
+	got, err := os.ReadFile(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "{\"model\": \"good\"}\n" {
+		t.Errorf("settings.json = %q, want the latest backup", got)
+	}
+	out := buf.String()
+	for _, want := range []string{`-{"model": "bad"}`, `+{"model": "good"}`, "Backup created:", "Restored 1 file(s)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if len(rep.Backups) != 1 {
+		t.Fatalf("report backups = %v, want one", rep.Backups)
+	}
+	saved, err := os.ReadFile(filepath.Join(rep.Backups[0], "settings.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(saved) != "{\"model\": \"bad\"}\n" {
+		t.Errorf("backup of current file = %q, want the pre-restore content", saved)
+	}
+}
+
+func TestRunRestore_DryRun(t *testing.T) {
+	dir := writeBackups(t, "{\"model\": \"bad\"}\n", map[string]string{
+		"20240101T120000.000": "{\"model\": \"good\"}\n",
+	})
+	local := filepath.Join(dir, "settings.json")
+
+	var buf bytes.Buffer
+	if err := runRestore(dir, "20240101", true, nil, &buf); err != nil {
+		t.Fatalf("runRestore: %v", err)
+	}
+
+	got, err := os.ReadFile(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "{\"model\": \"bad\"}\n" {
+		t.Errorf("dry run changed settings.json to %q", got)
+	}
+	if !strings.Contains(buf.String(), "Dry run") || !strings.Contains(buf.String(), `+{"model": "good"}`) {
+		t.Errorf("want diff and dry-run note, got:\n%s", buf.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, backup.DirName)); !os.IsNotExist(err) {
+		t.Errorf("dry run created a backup session (stat err = %v)", err)
+	}
+}
+
+func TestRunRestore_UnknownID(t *testing.T) {
+	dir := writeBackups(t, "{}\n", map[string]string{"20240101T120000.000": "{}\n"})
+
+	if err := runRestore(dir, "2023", false, nil, &bytes.Buffer{}); err == nil {
+		t.Error("want error for a timestamp matching no backup")
+	}
+}
+
+func TestParseRestoreArgs(t *testing.T) {
+	id, dryRun, err := parseRestoreArgs(nil)
+	if err != nil || id != "latest" || dryRun {
+		t.Errorf("parseRestoreArgs(nil) = %q, %v, %v; want latest, false, nil", id, dryRun, err)
+	}
+	id, dryRun, err = parseRestoreArgs([]string{"-n", "20240101"})
+	if err != nil || id != "20240101" || !dryRun {
+		t.Errorf("parseRestoreArgs(-n 20240101) = %q, %v, %v", id, dryRun, err)
+	}
+	if _, _, err := parseRestoreArgs([]string{"a", "b"}); err == nil {
+		t.Error("want error for two timestamps")
+	}
+}
+
+// setupForcedRun prepares a home directory whose settings conflict with
+// master and which holds a locally written agent that master also ships.
+func setupForcedRun(t *testing.T) (cfg *config.Config, homeDir string) {
+	t.Helper()
+	cfg, configDir, homeDir := makeConfig(t)
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.json"), map[string]any{"model": "opus"})
+	writeJSON(t, filepath.Join(homeDir, ".claude", "settings.json"), map[string]any{"model": "sonnet"})
+	for _, dir := range []string{
+		filepath.Join(configDir, ".claude", "agents"),
+		filepath.Join(configDir, ".claude", "skills", "new"),
+		filepath.Join(homeDir, ".claude", "agents"),
+	} {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, content := range map[string]string{
+		filepath.Join(configDir, ".claude", "agents", "a.md"):            "master agent",
+		filepath.Join(configDir, ".claude", "skills", "new", "SKILL.md"): "skill",
+		filepath.Join(homeDir, ".claude", "agents", "a.md"):              "my agent",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cfg, homeDir
+}
+
+func TestDispatch_AllBacksUpEveryFileAndRestoresAsOneUnit(t *testing.T) {
+	cfg, homeDir := setupForcedRun(t)
+	claudeDir := filepath.Join(homeDir, ".claude")
+
+	var buf bytes.Buffer
+	if err := dispatch("all", []string{"-f"}, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("all -f: %v", err)
+	}
+	if got := readJSON(t, filepath.Join(claudeDir, "settings.json"))["model"]; got != "opus" {
+		t.Fatalf("model = %v; want opus after -f", got)
+	}
+	if !strings.Contains(buf.String(), "Undo with: claude-config-merge restore") {
+		t.Errorf("expected undo hint, got:\n%s", buf.String())
+	}
+
+	sessions, err := backup.ListSessions(claudeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("sessions = %v; want one for the run", sessions)
+	}
+	if got, _ := os.ReadFile(filepath.Join(sessions[0].Path, "agents", "a.md")); string(got) != "my agent" {
+		t.Errorf("backup of agents/a.md = %q; want the overwritten local version", got)
+	}
+
+	buf.Reset()
+	if err := dispatch("restore", []string{sessions[0].ID}, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if got := readJSON(t, filepath.Join(claudeDir, "settings.json"))["model"]; got != "sonnet" {
+		t.Errorf("model = %v; want sonnet after restore", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(claudeDir, "agents", "a.md")); string(got) != "my agent" {
+		t.Errorf("agents/a.md = %q; want my agent after restore", got)
+	}
+	if _, err := os.Stat(filepath.Join(claudeDir, "skills", "new")); !os.IsNotExist(err) {
+		t.Errorf("skill created by the run should be removed (stat err = %v)", err)
+	}
+}
+
+func TestDispatch_ForcedSyncOverSymlinkRestoresLink(t *testing.T) {
+	cfg, homeDir := setupForcedRun(t)
+	link := filepath.Join(homeDir, ".claude", "agents", "a.md")
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("elsewhere.md", link); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := dispatch("agents", []string{"-f"}, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("agents -f: %v\n%s", err, buf.String())
+	}
+	if got, _ := os.ReadFile(link); string(got) != "master agent" {
+		t.Fatalf("agents/a.md = %q; want master agent after -f", got)
+	}
+
+	buf.Reset()
+	if err := dispatch("restore", nil, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if !strings.Contains(buf.String(), "restore link "+link+" -> elsewhere.md") {
+		t.Errorf("expected the link in the restore diff, got:\n%s", buf.String())
+	}
+	if got, err := os.Readlink(link); err != nil || got != "elsewhere.md" {
+		t.Errorf("agents/a.md links to %q, %v; want elsewhere.md after restore", got, err)
+	}
+}
+
+func TestDispatch_PrunesOldBackupsAfterRun(t *testing.T) {
+	cfg, homeDir := setupForcedRun(t)
+	claudeDir := filepath.Join(homeDir, ".claude")
+	cfg.Retention = backup.Retention{Keep: 1}
+	ids := []string{"20240101T120000.000", "20240102T120000.000"}
+	for _, id := range ids {
+		if err := os.WriteFile(filepath.Join(claudeDir, "settings.json."+id+".bak"), []byte("{}"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := dispatch("settings", []string{"-f"}, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("settings -f: %v", err)
+	}
+
+	backups, err := listBackups(claudeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Entries == nil {
+		t.Errorf("backups after run = %v; want only the new session", backups)
+	}
+	if !strings.Contains(buf.String(), "Deleted 2 old backup(s) (keeping newest 1)") {
+		t.Errorf("output missing pruning note:\n%s", buf.String())
+	}
+}
+
+func TestDispatch_SettingsUpToDateSavesNoBackup(t *testing.T) {
+	cfg, configDir, homeDir := makeConfig(t)
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.json"), map[string]any{"model": "opus", "n": 1})
+	claudeDir := filepath.Join(homeDir, ".claude")
+	writeJSON(t, filepath.Join(claudeDir, "settings.json"), map[string]any{"model": "opus", "n": 1})
+
+	for i := range 2 {
+		if err := dispatch("settings", nil, cfg, homeDir, &bytes.Buffer{}); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+
+	// Only the first run records the snapshot, and saves a session for it.
+	sessions, err := backup.ListSessions(claudeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Errorf("sessions = %v; want one, for the first snapshot", sessions)
+	}
+}
@@ -2,49 +2,107 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
+	"time"
+
+	"github.com/jeff/claude-config-merge/internal/backup"
 )
 
-// runCleanupBak finds and deletes settings.json.*.bak files in claudeDir.
-func runCleanupBak(claudeDir string, w io.Writer) error {
-	entries, err := os.ReadDir(claudeDir)
+// cleanupOptions controls which backups cleanup-bak deletes.
+type cleanupOptions struct {
+	retention backup.Retention // backups to keep; zero deletes all
+	dryRun    bool             // list what would be deleted; delete nothing
+	now       time.Time        // reference time for retention.MaxAge
+}
+
+// runCleanupBak finds the backups in claudeDir, sessions and
+// settings.json.*.bak files, and deletes the ones opts.retention does not
+// keep, or only lists them with opts.dryRun. Deleted backups are recorded in
+// rep.
+func runCleanupBak(claudeDir string, opts cleanupOptions, rep *report, w io.Writer) error {
+	backups, err := listBackups(claudeDir)
 	if err != nil {
-		return fmt.Errorf("reading directory %s: %w", claudeDir, err)
+		return err
 	}
 
-	var bakFiles []string
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		name := entry.Name()
-		if strings.HasPrefix(name, "settings.json.") && strings.HasSuffix(name, ".bak") {
-			bakFiles = append(bakFiles, name)
+	if len(backups) == 0 {
+		fmt.Fprintf(w, "No backup files found in %s\n", claudeDir)
+		return nil
+	}
+
+	expired := backups
+	if !opts.retention.IsZero() {
+		expired = opts.retention.Expired(backups, opts.now)
+		fmt.Fprintf(w, "Keeping %d of %d backup file(s) (%s)\n", len(backups)-len(expired), len(backups), opts.retention)
+		if len(expired) == 0 {
+			fmt.Fprintf(w, "Nothing to delete in %s\n", claudeDir)
+			return nil
 		}
 	}
 
-	if len(bakFiles) == 0 {
-		fmt.Fprintf(w, "No backup files found in %s\n", claudeDir)
+	if opts.dryRun {
+		for _, b := range expired {
+			fmt.Fprintf(w, "  Would delete: %s\n", backupName(claudeDir, b))
+		}
+		fmt.Fprintf(w, "\nDry run: would delete %d of %d backup file(s) from %s (no files changed).\n", len(expired), len(backups), claudeDir)
 		return nil
 	}
 
 	var errs []error
 	deleted := 0
-	for _, name := range bakFiles {
-		path := filepath.Join(claudeDir, name)
-		if err := os.Remove(path); err != nil {
+	for _, b := range expired {
+		name := backupName(claudeDir, b)
+		if err := os.RemoveAll(b.Path); err != nil {
 			fmt.Fprintf(w, "  Error deleting %s: %v\n", name, err)
 			errs = append(errs, err)
 			continue
 		}
 		fmt.Fprintf(w, "  Deleted: %s\n", name)
+		rep.addDeletedBackup(b.Path)
 		deleted++
 	}
 
-	fmt.Fprintf(w, "\nDeleted %d of %d backup file(s) from %s\n", deleted, len(bakFiles), claudeDir)
+	fmt.Fprintf(w, "\nDeleted %d of %d backup file(s) from %s\n", deleted, len(expired), claudeDir)
 	return errors.Join(errs...)
 }
+
+// backupName returns the path of b relative to claudeDir, for display.
+func backupName(claudeDir string, b backup.Backup) string {
+	if rel, err := filepath.Rel(claudeDir, b.Path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return b.Path
+}
+
+// parseCleanupArgs parses the flags of the cleanup-bak subcommand. -keep and
+// -max-age override the matching limit of retention, the configured policy.
+func parseCleanupArgs(args []string, retention backup.Retention) (cleanupOptions, error) {
+	opts := cleanupOptions{retention: retention}
+	var maxAge string
+	fs := flag.NewFlagSet("cleanup-bak", flag.ContinueOnError)
+	fs.IntVar(&opts.retention.Keep, "keep", retention.Keep, "keep the `N` newest backups")
+	fs.StringVar(&maxAge, "max-age", "", "keep backups younger than `AGE` (e.g. 30d, 2w, 12h)")
+	fs.BoolVar(&opts.dryRun, "n", false, "list the backups that would be deleted")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "list the backups that would be deleted")
+	if err := fs.Parse(args); err != nil {
+		return cleanupOptions{}, fmt.Errorf("cleanup-bak: %w", err)
+	}
+	if fs.NArg() > 0 {
+		return cleanupOptions{}, fmt.Errorf("cleanup-bak: unexpected arguments %q", fs.Args())
+	}
+	if opts.retention.Keep < 0 {
+		return cleanupOptions{}, fmt.Errorf("cleanup-bak: -keep must not be negative")
+	}
+	if maxAge != "" {
+		age, err := backup.ParseAge(maxAge)
+		if err != nil {
+			return cleanupOptions{}, fmt.Errorf("cleanup-bak: -max-age: %w", err)
+		}
+		opts.retention.MaxAge = age
+	}
+	return opts, nil
+}
@@ -7,6 +7,9 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/jeff/claude-config-merge/internal/backup"
 )
 
 func TestRunCleanupBak_DeletesBackups(t *testing.T) {
@@ -28,7 +31,7 @@ func TestRunCleanupBak_DeletesBackups(t *testing.T) {
 	}
 
 	var buf bytes.Buffer
-	if err := runCleanupBak(dir, &buf); err != nil {
+	if err := runCleanupBak(dir, cleanupOptions{}, nil, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -54,7 +57,7 @@ func TestRunCleanupBak_NoBackupsFound(t *testing.T) {
 	dir := t.TempDir()
 
 	var buf bytes.Buffer
-	if err := runCleanupBak(dir, &buf); err != nil {
+	if err := runCleanupBak(dir, cleanupOptions{}, nil, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -66,7 +69,7 @@ func TestRunCleanupBak_NoBackupsFound(t *testing.T) {
 
 func TestRunCleanupBak_MissingDir(t *testing.T) {
 	var buf bytes.Buffer
-	err := runCleanupBak("/nonexistent/path/that/does/not/exist", &buf)
+	err := runCleanupBak("/nonexistent/path/that/does/not/exist", cleanupOptions{}, nil, &buf)
 	if err == nil {
 		t.Fatal("expected error for non-existent directory, got nil")
 	}
@@ -96,7 +99,7 @@ func TestRunCleanupBak_PartialFailure(t *testing.T) {
 	t.Cleanup(func() { _ = os.Chmod(dir, 0o755) }) //nolint:gosec // restore directory permissions after test
 
 	var buf bytes.Buffer
-	err := runCleanupBak(dir, &buf)
+	err := runCleanupBak(dir, cleanupOptions{}, nil, &buf)
 
 	output := buf.String()
 	if err == nil {
@@ -126,7 +129,7 @@ func TestRunCleanupBak_NonBakFilesUntouched(t *testing.T) {
 	}
 
 	var buf bytes.Buffer
-	if err := runCleanupBak(dir, &buf); err != nil {
+	if err := runCleanupBak(dir, cleanupOptions{}, nil, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -142,3 +145,92 @@ func TestRunCleanupBak_NonBakFilesUntouched(t *testing.T) {
 		}
 	}
 }
+
+// writeBakFiles creates a settings.json backup in dir for each ID.
+func writeBakFiles(t *testing.T, dir string, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if err := os.WriteFile(filepath.Join(dir, "settings.json."+id+".bak"), []byte("backup"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRunCleanupBak_Retention(t *testing.T) {
+	dir := t.TempDir()
+	writeBakFiles(t, dir, "20240101T120000.000", "20240201T120000.000", "20240301T120000.000", "20240310T120000.000")
+
+	opts := cleanupOptions{
+		retention: backup.Retention{Keep: 1, MaxAge: 30 * 24 * time.Hour},
+		now:       time.Date(2024, 3, 15, 12, 0, 0, 0, time.Local),
+	}
+	var buf bytes.Buffer
+	rep := newReport("cleanup-bak", false)
+	if err := runCleanupBak(dir, opts, rep, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := buf.String()
+	if !strings.Contains(output, "Keeping 2 of 4 backup file(s) (newest 1 or younger than 30d)") {
+		t.Errorf("expected retention summary, got:\n%s", output)
+	}
+	for id, kept := range map[string]bool{
+		"20240101T120000.000": false,
+		"20240201T120000.000": false,
+		"20240301T120000.000": true,
+		"20240310T120000.000": true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, "settings.json."+id+".bak"))
+		if (err == nil) != kept {
+			t.Errorf("backup %s kept = %v; want %v", id, err == nil, kept)
+		}
+	}
+	if len(rep.DeletedBackups) != 2 {
+		t.Errorf("report deletedBackups = %v; want 2 entries", rep.DeletedBackups)
+	}
+}
+
+func TestRunCleanupBak_DryRunListsWithoutDeleting(t *testing.T) {
+	dir := t.TempDir()
+	writeBakFiles(t, dir, "20240101T120000.000", "20240201T120000.000")
+
+	var buf bytes.Buffer
+	opts := cleanupOptions{retention: backup.Retention{Keep: 1}, dryRun: true}
+	if err := runCleanupBak(dir, opts, nil, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := buf.String()
+	if !strings.Contains(output, "Would delete: settings.json.20240101T120000.000.bak") ||
+		strings.Contains(output, "20240201T120000.000") {
+		t.Errorf("expected only the older backup listed, got:\n%s", output)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.bak"))
+	if len(matches) != 2 {
+		t.Errorf("dry run deleted files; remaining: %v", matches)
+	}
+}
+
+func TestParseCleanupArgs(t *testing.T) {
+	configured := backup.Retention{Keep: 10, MaxAge: time.Hour}
+
+	opts, err := parseCleanupArgs(nil, configured)
+	if err != nil || opts.retention != configured || opts.dryRun {
+		t.Errorf("parseCleanupArgs(nil) = %+v, %v; want configured retention", opts, err)
+	}
+
+	opts, err = parseCleanupArgs([]string{"-keep", "3", "-max-age", "2w", "-n"}, configured)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := backup.Retention{Keep: 3, MaxAge: 14 * 24 * time.Hour}
+	if opts.retention != want || !opts.dryRun {
+		t.Errorf("parseCleanupArgs = %+v; want retention %+v and dry run", opts, want)
+	}
+
+	for _, args := range [][]string{{"-keep", "-1"}, {"-max-age", "soon"}, {"extra"}} {
+		if _, err := parseCleanupArgs(args, backup.Retention{}); err == nil {
+			t.Errorf("parseCleanupArgs(%q): want error", args)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jeff/claude-config-merge/internal/textdiff"
+)
+
+// ANSI escape sequences used to colorize diffs.
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiCyan  = "\x1b[36m"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// runDiff previews the merge of the master settings layers in masters into
+// localPath without writing anything. It prints a key-level view of every changed key followed by a
+// unified diff between the current local file and the would-be merged file.
+func runDiff(masters []source, localPath string, opts runOptions, w io.Writer) error {
+	plan, err := planSettings(masters, localPath, opts)
+	if err != nil {
+		return err
+	}
+	opts.report.addSettings(plan, &plan.result)
+
+	if !plan.result.Changed() {
+		fmt.Fprintf(w, "Settings: no changes to %s\n", localPath)
+		return nil
+	}
+
+	out, err := plan.render()
+	if err != nil {
+		return err
+	}
+
+	printKeyChanges(plan, w)
+
+	diff := textdiff.Unified(localPath, localPath+" (merged)", string(plan.localRaw), string(out), diffContext)
+	if opts.color {
+		diff = colorizeDiff(diff)
+	}
+	fmt.Fprint(w, diff)
+	return nil
+}
+
+// printKeyChanges writes one block per key the merge would change, showing
+// the local value before and after, in the style of the conflict report.
+func printKeyChanges(plan *settingsPlan, w io.Writer) {
+	res := &plan.result
+	var keys []string
+	reason := make(map[string]string)
+	add := func(label string, ks []string) {
+		for _, k := range ks {
+			if _, seen := reason[k]; !seen {
+				keys = append(keys, k)
+			}
+			reason[k] = label
+		}
+	}
+	add("added", res.Added)
+	add("updated", res.Updated)
+	add("forced", res.Forced)
+	add("removed", res.Removed)
+	for _, a := range res.ArrayAdditions {
+		add("array elements added", []string{a.Key})
+	}
+	add("duplicates removed", res.Deduplicated)
+
+	fmt.Fprintf(w, "\nKey changes:\n")
+	for _, k := range keys {
+		fmt.Fprintf(w, "\n%s\n", reportSeparator)
+		if from := plan.origins.of(k); from != "" {
+			reason[k] += ", from " + from
+		}
+		fmt.Fprintf(w, "  %s (%s)\n", k, reason[k])
+		fmt.Fprintf(w, "    before: %s\n", formatLookup(plan.local, k))
+		fmt.Fprintf(w, "    after:  %s\n", formatLookup(res.Merged, k))
+	}
+	fmt.Fprintf(w, "\n%s\n\n", reportSeparator)
+}
+
+// formatLookup formats the value at the dotted key in m, or "(absent)".
+func formatLookup(m map[string]any, key string) string {
+	v, ok := lookupKey(m, key)
+	if !ok {
+		return "(absent)"
+	}
+	return formatValue(v)
+}
+
+// lookupKey returns the value at the dotted key path in m. A key that itself
+// contains dots is matched before descending into nested objects.
+func lookupKey(m map[string]any, key string) (any, bool) {
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+	for i := strings.IndexByte(key, '.'); i >= 0; {
+		if sub, ok := m[key[:i]].(map[string]any); ok {
+			if v, ok := lookupKey(sub, key[i+1:]); ok {
+				return v, true
+			}
+		}
+		next := strings.IndexByte(key[i+1:], '.')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return nil, false
+}
+
+// colorizeDiff wraps the lines of a unified diff in ANSI colors.
+func colorizeDiff(diff string) string {
+	lines := strings.SplitAfter(diff, "\n")
+	var sb strings.Builder
+	for _, line := range lines {
+		body := strings.TrimSuffix(line, "\n")
+		nl := line[len(body):]
+		switch {
+		case strings.HasPrefix(body, "+++"), strings.HasPrefix(body, "---"):
+			sb.WriteString(ansiBold + body + ansiReset + nl)
+		case strings.HasPrefix(body, "@@"):
+			sb.WriteString(ansiCyan + body + ansiReset + nl)
+		case strings.HasPrefix(body, "+"):
+			sb.WriteString(ansiGreen + body + ansiReset + nl)
+		case strings.HasPrefix(body, "-"):
+			sb.WriteString(ansiRed + body + ansiReset + nl)
+		default:
+			sb.WriteString(line)
+		}
+	}
+	return sb.String()
+}
+
+// useColor reports whether output to w should be colorized: w must be a
+// terminal and the NO_COLOR environment variable must be unset.
+func useColor(w io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunDiff_ShowsUnifiedDiffAndKeyView(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+
+	if err := os.WriteFile(masterPath, []byte(`{"model": "opus", "theme": "dark"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	local := "{\n  \"theme\": \"light\"\n}\n"
+	if err := os.WriteFile(localPath, []byte(local), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := runDiff(single(masterPath), localPath, runOptions{force: true}, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := buf.String()
+	for _, want := range []string{
+		"--- " + localPath,
+		"+++ " + localPath + " (merged)",
+		`-  "theme": "light"`,
+		`+  "theme": "dark",`,
+		`+  "model": "opus"`,
+		reportSeparator,
+		"model (added)",
+		"before: (absent)",
+		"theme (forced)",
+		`after:  "dark"`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, ansiReset) {
+		t.Errorf("expected no color codes when color is off, got:\n%s", output)
+	}
+
+	got, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != local {
+		t.Errorf("local file changed by diff:\n%s", got)
+	}
+}
+
+func TestRunDiff_NestedKeyShowsLocalValueBefore(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+	writeJSON(t, masterPath, map[string]any{"env": map[string]any{"A": "master", "B": "new"}})
+	writeJSON(t, localPath, map[string]any{"env": map[string]any{"A": "local"}})
+
+	var buf bytes.Buffer
+	if err := runDiff(single(masterPath), localPath, runOptions{force: true}, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := buf.String()
+	for _, want := range []string{
+		"env.A (forced)\n    before: \"local\"\n    after:  \"master\"",
+		"env.B (added)\n    before: (absent)\n    after:  \"new\"",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, output)
+		}
+	}
+}
+
+func TestRunDiff_NoChanges(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+	writeJSON(t, masterPath, map[string]any{"k": "v"})
+	writeJSON(t, localPath, map[string]any{"k": "v"})
+
+	var buf bytes.Buffer
+	if err := runDiff(single(masterPath), localPath, runOptions{}, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "no changes") {
+		t.Errorf("expected 'no changes' in output, got:\n%s", buf.String())
+	}
+}
+
+func TestColorizeDiff(t *testing.T) {
+	got := colorizeDiff("--- a\n+++ b\n@@ -1 +1 @@\n-x\n+y\n z\n")
+	for _, want := range []string{ansiRed + "-x" + ansiReset, ansiGreen + "+y" + ansiReset, ansiCyan + "@@ -1 +1 @@", " z\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("colorizeDiff missing %q in %q", want, got)
+		}
+	}
+}
+
+func TestLookupKey(t *testing.T) {
+	m := map[string]any{
+		"a":   map[string]any{"b": map[string]any{"c": 1}},
+		"x.y": "dotted",
+	}
+	if v, ok := lookupKey(m, "a.b.c"); !ok || v != 1 {
+		t.Errorf("lookupKey(a.b.c) = %v, %v; want 1, true", v, ok)
+	}
+	if v, ok := lookupKey(m, "x.y"); !ok || v != "dotted" {
+		t.Errorf("lookupKey(x.y) = %v, %v; want dotted, true", v, ok)
+	}
+	if _, ok := lookupKey(m, "a.missing"); ok {
+		t.Error("lookupKey(a.missing) found a value; want none")
+	}
+}
+
+func TestDispatch_Diff(t *testing.T) {
+	cfg, configDir, homeDir := makeConfig(t)
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.json"), map[string]any{"k": "v"})
+	writeJSON(t, filepath.Join(homeDir, ".claude", "settings.json"), map[string]any{})
+
+	var buf bytes.Buffer
+	if err := dispatch("diff", []string{"-no-color"}, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "k (added)") {
+		t.Errorf("expected key view in output, got:\n%s", buf.String())
+	}
+}
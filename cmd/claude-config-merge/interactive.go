@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/jeff/claude-config-merge/internal/jsonc"
+	"github.com/jeff/claude-config-merge/internal/merge"
+)
+
+// errResolutionAborted is returned when input ends before every conflict has
+// been resolved.
+var errResolutionAborted = errors.New("interactive conflict resolution aborted")
+
+// resolveConflicts asks the user how to settle each conflict, reading answers
+// from in and writing prompts to w. It returns the chosen value per key.
+// editValue is called for the "edit" choice; it is a parameter so tests can
+// avoid launching a real editor.
+func resolveConflicts(conflicts []merge.Conflict, in io.Reader, w io.Writer,
+	editValue func(merge.Conflict) (any, error)) (map[string]any, error) {
+	resolutions := make(map[string]any, len(conflicts))
+	scanner := bufio.NewScanner(in)
+
+	// all is set once the user picks a choice for every remaining conflict.
+	all := ""
+	for i, c := range conflicts {
+		choice := all
+		for choice == "" {
+			fmt.Fprintf(w, "\n%s\n", reportSeparator)
+			fmt.Fprintf(w, "  Conflict %d of %d: %s\n", i+1, len(conflicts), c.Key)
+			fmt.Fprintf(w, "    master: %s\n", formatValue(c.MasterValue))
+			fmt.Fprintf(w, "    local:  %s\n", formatValue(c.LocalValue))
+			fmt.Fprintf(w, "  [m] master  [l] local  [e] edit  [M] master for all remaining  [L] local for all remaining\n")
+			fmt.Fprintf(w, "  choice: ")
+			if !scanner.Scan() {
+				fmt.Fprintf(w, "\n")
+				if err := scanner.Err(); err != nil {
+					return nil, fmt.Errorf("reading choice: %w", err)
+				}
+				return nil, errResolutionAborted
+			}
+
+			switch answer := strings.TrimSpace(scanner.Text()); answer {
+			case "m", "l", "e":
+				choice = answer
+			case "M", "L":
+				all = strings.ToLower(answer)
+				choice = all
+			default:
+				fmt.Fprintf(w, "  unrecognized choice %q\n", answer)
+			}
+
+			if choice == "e" {
+				v, err := editValue(c)
+				if err != nil {
+					fmt.Fprintf(w, "  edit failed: %v\n", err)
+					choice = ""
+					continue
+				}
+				resolutions[c.Key] = v
+			}
+		}
+
+		switch choice {
+		case "m":
+			resolutions[c.Key] = c.MasterValue
+		case "l":
+			resolutions[c.Key] = c.LocalValue
+		}
+	}
+	fmt.Fprintf(w, "\n%s\n\n", reportSeparator)
+	return resolutions, nil
+}
+
+// editInEditor opens $VISUAL or $EDITOR (default vi) on a temporary file that
+// shows both values of c as comments and starts out holding the local value.
+// A variable holding only whitespace counts as unset. The saved file is
+// parsed as JSONC and its value returned.
+func editInEditor(c merge.Conflict) (any, error) {
+	editor := strings.TrimSpace(os.Getenv("VISUAL"))
+	if editor == "" {
+		editor = strings.TrimSpace(os.Getenv("EDITOR"))
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Resolve the conflict for %s.\n", c.Key)
+	fmt.Fprintf(&buf, "// Leave the value to use below; lines starting with // are ignored.\n//\n")
+	for _, side := range []struct {
+		label string
+		value any
+	}{{"master", c.MasterValue}, {"local", c.LocalValue}} {
+		fmt.Fprintf(&buf, "// %s:\n", side.label)
+		for _, line := range strings.Split(formatValue(side.value), "\n") {
+			fmt.Fprintf(&buf, "//   %s\n", strings.TrimPrefix(line, "    "))
+		}
+	}
+	fmt.Fprintf(&buf, "%s\n", strings.ReplaceAll(formatValue(c.LocalValue), "\n    ", "\n"))
+
+	tmp, err := os.CreateTemp("", "claude-config-merge-*.jsonc")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer func() { _ = os.Remove(tmpName) }()
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		return nil, fmt.Errorf("writing temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("closing temp file: %w", err)
+	}
+
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], tmpName)...) //nolint:gosec // the editor is chosen by the user
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running %s: %w", editor, err)
+	}
+
+	data, err := os.ReadFile(tmpName)
+	if err != nil {
+		return nil, fmt.Errorf("reading edited value: %w", err)
+	}
+	var v any
+	if err := jsonc.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("parsing edited value: %w", err)
+	}
+	return v, nil
+}
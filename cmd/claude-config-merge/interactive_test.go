@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jeff/claude-config-merge/internal/merge"
+)
+
+func noEdit(merge.Conflict) (any, error) {
+	return nil, errors.New("editor not expected")
+}
+
+func TestResolveConflicts_PicksPerConflict(t *testing.T) {
+	conflicts := []merge.Conflict{
+		{Key: "a", MasterValue: "ma", LocalValue: "la"},
+		{Key: "b", MasterValue: "mb", LocalValue: "lb"},
+	}
+	var buf bytes.Buffer
+	got, err := resolveConflicts(conflicts, strings.NewReader("m\nl\n"), &buf, noEdit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]any{"a": "ma", "b": "lb"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolutions = %v; want %v", got, want)
+	}
+	if !strings.Contains(buf.String(), "Conflict 2 of 2: b") {
+		t.Errorf("expected prompt for second conflict, got:\n%s", buf.String())
+	}
+}
+
+func TestResolveConflicts_ApplyToAllRemaining(t *testing.T) {
+	conflicts := []merge.Conflict{
+		{Key: "a", MasterValue: "ma", LocalValue: "la"},
+		{Key: "b", MasterValue: "mb", LocalValue: "lb"},
+		{Key: "c", MasterValue: "mc", LocalValue: "lc"},
+	}
+	var buf bytes.Buffer
+	got, err := resolveConflicts(conflicts, strings.NewReader("l\nM\n"), &buf, noEdit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]any{"a": "la", "b": "mb", "c": "mc"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolutions = %v; want %v", got, want)
+	}
+	if strings.Contains(buf.String(), "Conflict 3 of 3") {
+		t.Errorf("expected no prompt after choosing for all remaining, got:\n%s", buf.String())
+	}
+}
+
+func TestResolveConflicts_RepromptsOnUnknownChoice(t *testing.T) {
+	conflicts := []merge.Conflict{{Key: "a", MasterValue: "ma", LocalValue: "la"}}
+	var buf bytes.Buffer
+	got, err := resolveConflicts(conflicts, strings.NewReader("x\nm\n"), &buf, noEdit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["a"] != "ma" {
+		t.Errorf("a = %v; want ma", got["a"])
+	}
+	if !strings.Contains(buf.String(), `unrecognized choice "x"`) {
+		t.Errorf("expected unrecognized choice message, got:\n%s", buf.String())
+	}
+}
+
+func TestResolveConflicts_EditUsesEditedValue(t *testing.T) {
+	conflicts := []merge.Conflict{{Key: "a", MasterValue: "ma", LocalValue: "la"}}
+	edit := func(c merge.Conflict) (any, error) { return "edited " + c.Key, nil }
+	got, err := resolveConflicts(conflicts, strings.NewReader("e\n"), &bytes.Buffer{}, edit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["a"] != "edited a" {
+		t.Errorf("a = %v; want edited a", got["a"])
+	}
+}
+
+func TestResolveConflicts_EndOfInputAborts(t *testing.T) {
+	conflicts := []merge.Conflict{{Key: "a", MasterValue: "ma", LocalValue: "la"}}
+	_, err := resolveConflicts(conflicts, strings.NewReader(""), &bytes.Buffer{}, noEdit)
+	if !errors.Is(err, errResolutionAborted) {
+		t.Errorf("err = %v; want errResolutionAborted", err)
+	}
+}
+
+func TestEditInEditor_ParsesSavedFile(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "editor.sh")
+	// The fake editor keeps the comment header and replaces the value.
+	body := "#!/bin/sh\ngrep '^//' \"$1\" > \"$1.tmp\"\necho '{\"picked\": [1, 2,],}' >> \"$1.tmp\"\nmv \"$1.tmp\" \"$1\"\n"
+	if err := os.WriteFile(script, []byte(body), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	// A blank $VISUAL falls through to $EDITOR.
+	t.Setenv("VISUAL", " \t")
+	t.Setenv("EDITOR", script)
+
+	got, err := editInEditor(merge.Conflict{Key: "a", MasterValue: "ma", LocalValue: "la"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	obj, ok := got.(map[string]any)
+	if !ok {
+		t.Fatalf("edited value = %#v; want object", got)
+	}
+	if arr, ok := obj["picked"].([]any); !ok || len(arr) != 2 {
+		t.Errorf("picked = %#v; want two-element array", obj["picked"])
+	}
+}
+
+func TestRun_InteractiveWritesOnlyChosenValues(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+
+	writeJSON(t, masterPath, map[string]any{"a": "ma", "b": "mb"})
+	writeJSON(t, localPath, map[string]any{"a": "la", "b": "lb"})
+
+	var buf bytes.Buffer
+	opts := runOptions{interactive: true, in: strings.NewReader("m\nl\n")}
+	if err := run(single(masterPath), localPath, opts, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result := readJSON(t, localPath)
+	if result["a"] != "ma" {
+		t.Errorf("a = %v; want ma", result["a"])
+	}
+	if result["b"] != "lb" {
+		t.Errorf("b = %v; want lb", result["b"])
+	}
+	if !strings.Contains(buf.String(), "Resolved interactively:\n  a\n") {
+		t.Errorf("expected resolved section, got:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "Conflicts (local value kept)") {
+		t.Errorf("expected no remaining conflicts, got:\n%s", buf.String())
+	}
+}
+
+func TestDispatchJSON_RejectsInteractive(t *testing.T) {
+	cfg, _, home := makeConfig(t)
+	var buf bytes.Buffer
+	err := dispatchJSON("settings", []string{"-i"}, cfg, home, &buf)
+	if err == nil || !strings.Contains(err.Error(), "-output json") {
+		t.Errorf("err = %v; want -output json error", err)
+	}
+}
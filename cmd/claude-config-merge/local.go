@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jeff/claude-config-merge/internal/config"
+	"github.com/jeff/claude-config-merge/internal/merge"
+)
+
+// Names of the shared and the machine-local settings files in a .claude
+// directory. Claude applies settings.local.json over settings.json.
+const (
+	sharedSettingsName = "settings.json"
+	localSettingsName  = "settings.local.json"
+)
+
+// runSettings merges the master settings.json into claudeDir's and then, if
+// a master layer has one, settings.local.json into claudeDir's under the
+// policy cfg.LocalPolicy returns, creating it if needed. Finally it reports
+// the keys both files in claudeDir set, where settings.local.json hides the
+// value in settings.json.
+func runSettings(cfg *config.Config, claudeDir string, opts runOptions, w io.Writer) error {
+	sharedPath := filepath.Join(claudeDir, sharedSettingsName)
+	shared, err := mergeSettings(layerPaths(cfg.Sources(), opts.project, sharedSettingsName), sharedPath, opts, w)
+	if err != nil {
+		return err
+	}
+
+	localPath := filepath.Join(claudeDir, localSettingsName)
+	var local map[string]any
+	if masters := existing(layerPaths(cfg.Sources(), opts.project, localSettingsName)); len(masters) > 0 {
+		fmt.Fprintf(w, "\nLocal settings (%s):\n", localSettingsName)
+		localOpts := opts
+		localOpts.arrays, localOpts.rules = cfg.LocalPolicy()
+		localOpts.createLocal = true
+		if local, err = mergeSettings(masters, localPath, localOpts, w); err != nil {
+			return err
+		}
+	} else {
+		_, local, err = loadDocument(localPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load local settings (%s): %w", localPath, err)
+		}
+	}
+
+	printShadowed(merge.Shadowed(shared, local), opts.report, w)
+	return nil
+}
+
+// runDiffs previews the merge of settings.json and, if a master layer has
+// one, of settings.local.json under its own policy, as runSettings would do
+// them.
+func runDiffs(cfg *config.Config, claudeDir string, opts runOptions, w io.Writer) error {
+	if err := runDiff(layerPaths(cfg.Sources(), opts.project, sharedSettingsName), filepath.Join(claudeDir, sharedSettingsName), opts, w); err != nil {
+		return err
+	}
+	masters := existing(layerPaths(cfg.Sources(), opts.project, localSettingsName))
+	if len(masters) == 0 {
+		return nil
+	}
+	fmt.Fprintf(w, "\nLocal settings (%s):\n", localSettingsName)
+	opts.arrays, opts.rules = cfg.LocalPolicy()
+	opts.createLocal = true
+	return runDiff(masters, filepath.Join(claudeDir, localSettingsName), opts, w)
+}
+
+// existing returns the sources whose file exists.
+func existing(srcs []source) []source {
+	var out []source
+	for _, s := range srcs {
+		if _, err := os.Stat(s.path); err == nil {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// printShadowed reports the keys set in both settings files, and records
+// them in rep.
+func printShadowed(shadows []merge.Shadow, rep *report, w io.Writer) {
+	if len(shadows) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\nShadowed: %d key(s) set in both %s and %s; %s wins:\n",
+		len(shadows), sharedSettingsName, localSettingsName, localSettingsName)
+	for _, s := range shadows {
+		rep.addShadowed(s)
+		fmt.Fprintf(w, "\n%s\n", reportSeparator)
+		if s.Same() {
+			fmt.Fprintf(w, "  %s  (same value)\n", s.Key)
+		} else {
+			fmt.Fprintf(w, "  %s\n", s.Key)
+		}
+		fmt.Fprintf(w, "    %-20s %s\n", sharedSettingsName+":", formatValue(s.LowerValue))
+		fmt.Fprintf(w, "    %-20s %s\n", localSettingsName+":", formatValue(s.UpperValue))
+	}
+	fmt.Fprintf(w, "\n%s\n", reportSeparator)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeff/claude-config-merge/internal/config"
+	"github.com/jeff/claude-config-merge/internal/merge"
+)
+
+func TestDispatch_SettingsMergesLocalFileUnderItsOwnPolicy(t *testing.T) {
+	cfg, configDir, homeDir := makeConfig(t)
+	cfg.Rules = map[string]merge.Policy{"model": merge.PolicyMasterWins}
+	cfg.LocalSettings = config.SettingsPolicy{Rules: map[string]merge.Policy{"model": merge.PolicyLocalWins}}
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.json"), map[string]any{"model": "opus"})
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.local.json"), map[string]any{
+		"model": "haiku",
+		"env":   map[string]any{"DEBUG": "1"},
+	})
+	claudeDir := filepath.Join(homeDir, ".claude")
+	writeJSON(t, filepath.Join(claudeDir, "settings.json"), map[string]any{"model": "sonnet"})
+	writeJSON(t, filepath.Join(claudeDir, "settings.local.json"), map[string]any{"model": "mine"})
+
+	var buf bytes.Buffer
+	if err := dispatch("settings", nil, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readJSON(t, filepath.Join(claudeDir, "settings.json"))["model"]; got != "opus" {
+		t.Errorf("settings.json model = %v; want opus from the master-wins rule", got)
+	}
+	local := readJSON(t, filepath.Join(claudeDir, "settings.local.json"))
+	if local["model"] != "mine" {
+		t.Errorf("settings.local.json model = %v; want mine kept by the local-wins rule", local["model"])
+	}
+	if env, _ := local["env"].(map[string]any); env["DEBUG"] != "1" {
+		t.Errorf("settings.local.json env = %v; want DEBUG added", local["env"])
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		"Local settings (settings.local.json):",
+		"Shadowed: 1 key(s) set in both settings.json and settings.local.json; settings.local.json wins:",
+		`settings.json:       "opus"`,
+		`settings.local.json: "mine"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestDispatch_SettingsCreatesLocalFile(t *testing.T) {
+	cfg, configDir, homeDir := makeConfig(t)
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.json"), map[string]any{"model": "opus"})
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.local.json"), map[string]any{"env": map[string]any{"DEBUG": "1"}})
+	claudeDir := filepath.Join(homeDir, ".claude")
+	writeJSON(t, filepath.Join(claudeDir, "settings.json"), map[string]any{})
+
+	var buf bytes.Buffer
+	if err := dispatch("all", nil, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := readJSON(t, filepath.Join(claudeDir, "settings.local.json"))["env"]; !ok {
+		t.Error("settings.local.json should be created from master")
+	}
+	if strings.Contains(buf.String(), "Shadowed:") {
+		t.Errorf("no key is set in both files, got:\n%s", buf.String())
+	}
+
+	// The created file is part of the run, so restoring removes it.
+	if err := dispatch("restore", nil, cfg, homeDir, &bytes.Buffer{}); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(claudeDir, "settings.local.json")); !os.IsNotExist(err) {
+		t.Errorf("restore should remove the created settings.local.json (stat err = %v)", err)
+	}
+}
+
+func TestDispatch_SettingsReportsShadowingWithoutMasterLocalFile(t *testing.T) {
+	cfg, configDir, homeDir := makeConfig(t)
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.json"), map[string]any{"model": "opus"})
+	claudeDir := filepath.Join(homeDir, ".claude")
+	writeJSON(t, filepath.Join(claudeDir, "settings.json"), map[string]any{})
+	localPath := filepath.Join(claudeDir, "settings.local.json")
+	if err := os.WriteFile(localPath, []byte("{\"model\": \"opus\"}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := dispatchJSON("settings", []string{"-n"}, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var rep report
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	// The dry run's planned settings.json already counts.
+	if len(rep.Shadowed) != 1 || rep.Shadowed[0].Key != "model" || rep.Shadowed[0].SettingsLocal != "opus" {
+		t.Errorf("shadowed = %+v; want model", rep.Shadowed)
+	}
+	if len(rep.Settings) != 1 {
+		t.Errorf("settings entries = %d; want only settings.json merged", len(rep.Settings))
+	}
+	if b, _ := os.ReadFile(localPath); string(b) != "{\"model\": \"opus\"}\n" {
+		t.Errorf("settings.local.json changed to %q", b)
+	}
+}
+
+func TestDispatch_DiffPreviewsLocalFile(t *testing.T) {
+	cfg, configDir, homeDir := makeConfig(t)
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.json"), map[string]any{"model": "opus"})
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.local.json"), map[string]any{"theme": "dark"})
+	writeJSON(t, filepath.Join(homeDir, ".claude", "settings.json"), map[string]any{"model": "opus"})
+
+	var buf bytes.Buffer
+	if err := dispatch("diff", nil, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "Local settings (settings.local.json):") || !strings.Contains(out, `+  "theme": "dark"`) {
+		t.Errorf("want a diff of settings.local.json, got:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(homeDir, ".claude", "settings.local.json")); !os.IsNotExist(err) {
+		t.Errorf("diff created settings.local.json (stat err = %v)", err)
+	}
+}
@@ -2,19 +2,27 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/jeff/claude-config-merge/internal/backup"
 	"github.com/jeff/claude-config-merge/internal/config"
+	"github.com/jeff/claude-config-merge/internal/dirsync"
 )
 
 func main() {
 	// Global flags — must be parsed before the subcommand name.
 	configPath := flag.String("config", config.DefaultPath(), "path to claude-config-merge config file")
+	output := flag.String("output", "text", "output format: text or json")
+	profile := flag.String("profile", "", "config profile to use (default: defaultProfile from the config file)")
+	target := flag.String("target", "", "apply to the .claude directory of project `DIR` instead of ~/.claude")
 	flag.Usage = func() { printUsage(os.Stderr) }
 	flag.Parse()
 
@@ -35,13 +43,29 @@ func main() {
 		return
 	}
 
+	if *output != "text" && *output != "json" {
+		log.Fatalf("unknown -output format %q (want text or json)", *output)
+	}
+	jsonOutput := *output == "json"
+
 	if *configPath == "" {
-		log.Fatal("could not determine home directory; use -config to specify a config file path")
+		fail(subcommand, jsonOutput, errors.New("could not determine home directory; use -config to specify a config file path"), "")
 	}
 
-	cfg, home := loadConfig(*configPath)
+	cfg, home := loadConfig(subcommand, *configPath, *profile, jsonOutput)
+	if *target != "" {
+		dir, err := projectDir(*target)
+		if err != nil {
+			fail(subcommand, jsonOutput, fmt.Errorf("-target: %w", err), "")
+		}
+		cfg.Target = dir
+	}
 
-	if err := dispatch(subcommand, args, cfg, home, os.Stdout); err != nil {
+	dispatchFn := dispatch
+	if jsonOutput {
+		dispatchFn = dispatchJSON
+	}
+	if err := dispatchFn(subcommand, args, cfg, home, os.Stdout); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
@@ -52,10 +76,16 @@ func printUsage(w io.Writer) {
 	fmt.Fprintf(w, `claude-config-merge — sync Claude configuration from a master config directory
 
 USAGE
-  claude-config-merge [-config FILE] <command> [-f]
+  claude-config-merge [-config FILE] [-profile NAME] [-target DIR] [-output text|json] <command> [-f] [-i] [-prune] [-n] [-v]
 
 GLOBAL FLAGS
   -config FILE   Path to config file (default: ~/.claude-config-merge.json)
+  -output FORMAT Output format: text (default) or json. In json mode a single
+                 JSON document describing the run is written to stdout.
+  -profile NAME  Use the named profile from the config file instead of
+                 defaultProfile
+  -target DIR    Apply to DIR/.claude, the .claude directory of a project,
+                 instead of ~/.claude
   -h             Show this help
 
 CONFIG FILE
@@ -64,132 +94,540 @@ CONFIG FILE
       "configDir": "/path/to/your/claude/configs"
     }
 
+  The config file and both settings files may contain // and /* */ comments
+  and trailing commas.
+
   configDir mirrors the structure of ~. Expected layout inside configDir:
     <configDir>/.claude/settings.json   master settings (merged into ~/.claude/settings.json)
+    <configDir>/.claude/settings.local.json
+                                        optional machine-local settings (merged
+                                        into ~/.claude/settings.local.json)
     <configDir>/.claude/agents/         agent files (synced to ~/.claude/agents/)
     <configDir>/.claude/skills/         skill files  (synced to ~/.claude/skills/)
 
+  Instead of configDir, "layers" lists several config directories, each laid
+  out like configDir, from lowest to highest precedence:
+    "layers": [
+      {"name": "company", "dir": "/path/to/company-configs"},
+      {"name": "team", "dir": "/path/to/team-configs"},
+      {"name": "personal", "dir": "/path/to/my-configs"}
+    ]
+  Master settings are combined layer by layer (later layers win, arrays with
+  a strategy are merged with it) and then merged into local; agents and
+  skills are overlaid so a file comes from the highest layer that has it.
+  Added, updated, and forced keys and copied files name their layer. A
+  layer may leave out any of settings.json, agents/, or skills/; name
+  defaults to the directory's base name.
+
+  With -target, master files come from <configDir>/project/.claude, laid
+  out like <configDir>/.claude, in each layer that has it; layers without a
+  project template use their .claude. A missing DIR/.claude/settings.json
+  is created from master, and backups go to DIR/.claude/.backups. The
+  tool's own files there are added to DIR/.claude/.gitignore.
+
+  Optional "arrayStrategies" maps dotted settings keys to union, append, or
+  replace so arrays are merged element-wise instead of conflicting:
+    "arrayStrategies": {"permissions.allow": "union"}
+
+  Optional "rules" maps dotted keys or glob patterns (* matches one key
+  segment) to master-wins, local-wins, ignore, or an array strategy. Rules
+  override -f for the keys they match:
+    "rules": {"model": "master-wins", "theme": "ignore", "env.*": "local-wins"}
+  Shared rules may also be kept in <configDir>/.claude-config-merge-rules.json
+  (in each layer; higher layers win); rules in the config file take
+  precedence.
+
+  Optional "localSettings" holds "arrayStrategies" and "rules" for merging
+  settings.local.json only, added to the shared ones and winning for the
+  same key:
+    "localSettings": {"rules": {"model": "local-wins"}}
+
+  Agents and skills: a .claudesyncignore file (gitignore syntax) at the root
+  of configDir/.claude/agents or configDir/.claude/skills leaves matching
+  files out of the sync. Optional "syncExclude" and "syncInclude" lists of
+  the same patterns apply to both; with syncInclude only matching files sync:
+    "syncExclude": ["README.md", ".DS_Store"], "syncInclude": ["*.md", "*.sh"]
+
+  Optional "profiles" holds named alternatives, e.g. one per client, each
+  with its own "configDir" or "layers" and any of "arrayStrategies",
+  "rules", "syncInclude", "syncExclude", "backupRetention", and "repos". A
+  profile's directories, sync patterns, retention, and repos replace the
+  top-level ones; its strategies and rules are added to them. Select one
+  with -profile or "defaultProfile"; every profile is validated on each run:
+    "defaultProfile": "work",
+    "profiles": {
+      "work": {"configDir": "/path/to/work-configs"},
+      "client-x": {"layers": [...], "rules": {"model": "master-wins"}}
+    }
+
+  Optional "backupRetention" prunes old backups after every successful
+  settings, agents, skills, or all run. A backup is kept if it is among the
+  newest "keep" or younger than "maxAge" (units s, m, h, d, w); without it
+  all are kept:
+    "backupRetention": {"keep": 10, "maxAge": "30d"}
+
+  Optional "repos" tells the repos command where to find project
+  repositories: "roots" to search, "maxDepth" levels below each (default 3),
+  and "include" / "exclude" globs. A glob with a / matches the path below
+  the root, any other the directory name:
+    "repos": {"roots": ["/path/to/src"], "exclude": ["node_modules", "archive/*"]}
+
 COMMANDS
   settings    Merge master settings.json into ~/.claude/settings.json.
               New keys from master are added; existing local keys are kept.
-              Use -f to let master values overwrite conflicting local keys.
+              Keys changed only in master since the last sync are updated;
+              keys changed only locally are kept.
+              Use -f to let master values overwrite conflicting local keys,
+              or -i to choose a value for each conflict.
+              If master has settings.local.json, it is then merged the same
+              way into ~/.claude/settings.local.json (created if missing)
+              under the localSettings policy. Keys set in both local files,
+              where settings.local.json hides settings.json, are listed
+              under "Shadowed".
+
+  diff        Preview the settings merge without writing anything: lists
+              every key that would change (before/after) and shows a unified
+              diff of ~/.claude/settings.json, and of settings.local.json if
+              master has one. Accepts -f and -prune to preview those modes,
+              and -no-color. Colors are used only on a terminal and when
+              NO_COLOR is unset.
 
   agents      Copy agent files from configDir/.claude/agents to ~/.claude/agents.
-              Existing files are skipped unless -f is given.
+              Files identical to the source are left alone. Files this tool
+              copied and nobody edited since are updated when the source
+              changes. Other existing files are skipped (with a warning if
+              edited locally) unless -f is given. With -prune,
+              agents this tool copied that are gone from configDir are removed.
 
   skills      Copy skill files from configDir/.claude/skills to ~/.claude/skills.
-              Existing files are skipped unless -f is given.
-
-  all         Run settings, agents, and skills in sequence.
-              Accepts -f (applies to all three operations).
-
-  cleanup-bak Delete settings.json.*.bak backup files from ~/.claude/.
+              Same rules as agents, applied to each file inside a skill
+              directory, so new files in an existing skill arrive without -f.
+              Accepts -f and -prune.
+
+  all         Run settings, agents, and skills in sequence, as one unit:
+              all three are checked first, and if a step fails while
+              applying, every file already changed is rolled back. The run
+              ends fully applied or fully reverted and says which.
+              Accepts -f (applies to all three operations), -i, and -prune.
+
+              Every file settings, agents, skills, or all changes or removes
+              is first copied to ~/.claude/.backups/<timestamp>/ at the same
+              relative path, one directory per run.
+
+  repos [ROOT...]
+              Run all, as with -target, in every repository under the roots
+              (default: repos.roots): every directory holding .claude/ or
+              .git, down to -depth levels, without searching inside
+              repositories or hidden directories. A failure in one
+              repository is reported and the rest still run. Prints a table
+              of added keys and files, conflicts kept local, and errors per
+              repository. Accepts -f, -prune, -n, -v (also print each
+              repository's report), -depth N, and -include / -exclude GLOB
+              (repeatable, added to the configured globs). Flags go before
+              the roots.
+
+  backups [list]
+              List the backups in ~/.claude/.backups/ (and older
+              settings.json.*.bak files), newest first, with their age, size,
+              and the files they hold.
+
+  restore [TIMESTAMP|latest]
+              Show the diff and roll back the chosen backup (default latest;
+              a unique timestamp prefix is enough) as one unit: every file
+              the run changed is put back and files it created are removed.
+              The current files are backed up first, so a restore can itself
+              be undone. Accepts -n.
+
+  cleanup-bak Delete the backups in ~/.claude/. With a
+              backupRetention in the config, or -keep N / -max-age AGE
+              (which override it), only backups outside the policy are
+              deleted. Accepts -n to list what would be deleted.
 
   help        Show this help.
 
 FLAGS (per command)
   -f          Force overwrite. For settings: master values win on conflict.
               For agents/skills/all: overwrite existing destination files.
+  -i          For settings/all: prompt for each conflict. Choose [m] master,
+              [l] local, [e] edit both values in $VISUAL/$EDITOR (default vi),
+              or [M]/[L] to use master/local for all remaining conflicts.
+              Cannot be combined with -output json.
+  -n, --dry-run
+              Print what would change without writing any files, backups,
+              or directories.
+  -v          For agents/skills/all: also list files left out by
+              .claudesyncignore, syncExclude, or syncInclude.
+  -keep N, -max-age AGE
+              For cleanup-bak: keep the N newest backups and/or those
+              younger than AGE (e.g. 30d, 2w, 12h); delete the rest.
+  -prune      For settings/all: remove keys master has dropped, if this tool
+              added them and they were not edited locally.
+              For agents/skills/all: remove files this tool copied whose source
+              is gone and that were not edited since. Copied files are
+              recorded with their SHA-256 in
+              ~/.claude/.claude-config-merge-manifest.json; files you created
+              are never removed.
 
 EXAMPLES
   claude-config-merge settings
   claude-config-merge settings -f
+  claude-config-merge settings -prune
+  claude-config-merge settings -i
+  claude-config-merge diff -f
   claude-config-merge agents
   claude-config-merge skills -f
   claude-config-merge all
   claude-config-merge all -f
+  claude-config-merge all -f -n
+  claude-config-merge repos -n ~/src
+  claude-config-merge repos -exclude archive -depth 2 ~/src ~/work
+  claude-config-merge backups
+  claude-config-merge restore -n latest
+  claude-config-merge restore 20240115T103000
   claude-config-merge cleanup-bak
+  claude-config-merge cleanup-bak -keep 5 -n
   claude-config-merge -config ~/my-config.json all
+  claude-config-merge -output json all -n
 `)
 }
 
 // dispatch executes the named subcommand using the provided config and home
 // directory, writing output to w.
 func dispatch(subcommand string, args []string, cfg *config.Config, home string, w io.Writer) error {
+	return dispatchWith(subcommand, args, cfg, home, w, nil)
+}
+
+// dispatchJSON executes the named subcommand like dispatch, but instead of the
+// human-readable report it writes a single JSON document describing the run
+// to w. The document is written even when the subcommand fails; the error is
+// listed in it and also returned.
+func dispatchJSON(subcommand string, args []string, cfg *config.Config, home string, w io.Writer) error {
+	rep := newReport(subcommand, false)
+	err := dispatchWith(subcommand, args, cfg, home, io.Discard, rep)
+	if err != nil {
+		rep.Errors = append(rep.Errors, err.Error())
+	}
+	if werr := rep.write(w); werr != nil {
+		return errors.Join(err, werr)
+	}
+	return err
+}
+
+// dispatchWith executes the named subcommand, writing human-readable output to
+// w and recording results in rep when it is non-nil. The subcommand applies
+// to home/.claude, or to the .claude directory of the project cfg.Target.
+func dispatchWith(subcommand string, args []string, cfg *config.Config, home string, w io.Writer, rep *report) error {
+	claudeDir := filepath.Join(home, ".claude")
+	if cfg.Target != "" {
+		claudeDir = filepath.Join(cfg.Target, ".claude")
+	}
+	if rep != nil {
+		rep.Profile = cfg.Profile
+		rep.Target = cfg.Target
+	}
 	switch subcommand {
-	case "settings":
-		force, err := parseForceFlagSet("settings", args)
-		if err != nil {
-			return err
-		}
-		masterPath := filepath.Join(cfg.ConfigDir, ".claude", "settings.json")
-		localPath := filepath.Join(home, ".claude", "settings.json")
-		return run(masterPath, localPath, force, w)
+	case "settings", "agents", "skills", "all", "diff":
+		return dispatchCommand(subcommand, args, cfg, claudeDir, w, rep)
 
-	case "agents":
-		force, err := parseForceFlagSet("agents", args)
+	case "repos":
+		return dispatchRepos(args, cfg, w, rep)
+
+	case "cleanup-bak":
+		opts, err := parseCleanupArgs(args, cfg.Retention)
 		if err != nil {
 			return err
 		}
-		srcDir := filepath.Join(cfg.ConfigDir, ".claude", "agents")
-		dstDir := filepath.Join(home, ".claude", "agents")
-		return runSync(srcDir, dstDir, force, "Agents", w)
+		opts.now = time.Now()
+		if rep != nil {
+			rep.DryRun = opts.dryRun
+		}
+		return runCleanupBak(claudeDir, opts, rep, w)
 
-	case "skills":
-		force, err := parseForceFlagSet("skills", args)
-		if err != nil {
-			return err
+	case "backups":
+		if len(args) > 1 || (len(args) == 1 && args[0] != "list") {
+			return fmt.Errorf("backups: unknown arguments %q (want: backups [list])", args)
 		}
-		srcDir := filepath.Join(cfg.ConfigDir, ".claude", "skills")
-		dstDir := filepath.Join(home, ".claude", "skills")
-		return runSync(srcDir, dstDir, force, "Skills", w)
+		return runBackupsList(claudeDir, time.Now(), rep, w)
 
-	case "all":
-		force, err := parseForceFlagSet("all", args)
+	case "restore":
+		id, dryRun, err := parseRestoreArgs(args)
 		if err != nil {
 			return err
 		}
+		if rep != nil {
+			rep.DryRun = dryRun
+		}
+		return runRestore(claudeDir, id, dryRun, rep, w)
 
-		masterPath := filepath.Join(cfg.ConfigDir, ".claude", "settings.json")
-		localPath := filepath.Join(home, ".claude", "settings.json")
-		if err := run(masterPath, localPath, force, w); err != nil {
-			return err
+	default:
+		fmt.Fprintf(w, "usage: claude-config-merge [settings|diff|agents|skills|all|repos|backups|restore|cleanup-bak] [-f]\n")
+		return fmt.Errorf("unknown subcommand %q", subcommand)
+	}
+}
+
+// dispatchCommand parses the flags of the settings, agents, skills, all, or
+// diff subcommand and runs it against claudeDir.
+func dispatchCommand(subcommand string, args []string, cfg *config.Config, claudeDir string, w io.Writer, rep *report) error {
+	flags, err := parseCommandFlags(subcommand, args)
+	if err != nil {
+		return err
+	}
+	if flags.interactive && rep != nil {
+		return fmt.Errorf("%s: -i cannot be combined with -output json", subcommand)
+	}
+	opts := flags.options(cfg)
+	opts.project = cfg.Target != ""
+	opts.report = rep
+	opts.color = !flags.noColor && useColor(w)
+	if rep != nil {
+		rep.DryRun = opts.dryRun || subcommand == "diff"
+	}
+	return runCommand(subcommand, cfg, claudeDir, opts, w)
+}
+
+// dispatchRepos parses the flags and roots of the repos subcommand and runs
+// it.
+func dispatchRepos(args []string, cfg *config.Config, w io.Writer, rep *report) error {
+	if cfg.Target != "" {
+		return fmt.Errorf("repos: -target cannot be combined with repos, which finds its own targets")
+	}
+	opts, err := parseReposArgs(args, cfg.Repos)
+	if err != nil {
+		return err
+	}
+	if rep != nil {
+		rep.DryRun = opts.flags.dryRun
+	}
+	return runRepos(cfg, opts, rep, w)
+}
+
+// runCommand runs the settings, diff, agents, skills, or all subcommand
+// against claudeDir. Unless opts.dryRun is set, the prior state of every file
+// the command changes is saved in one backup session under claudeDir/.backups,
+// and old backups are pruned by cfg.Retention once the command succeeds. all
+// is applied as a transaction; see runAll.
+func runCommand(subcommand string, cfg *config.Config, claudeDir string, opts runOptions, w io.Writer) error {
+	opts.manifest = filepath.Join(claudeDir, dirsync.ManifestName)
+	printRunHeader(cfg.Profile, claudeDir, opts.project, w)
+	if !opts.dryRun && subcommand != "diff" {
+		opts.session = backup.NewSession(claudeDir, time.Now())
+	}
+
+	var err error
+	if subcommand == "all" && opts.session != nil {
+		err = runAll(cfg, claudeDir, opts, w)
+	} else {
+		err = runSteps(subcommand, cfg, claudeDir, opts, w)
+	}
+
+	if err == nil && opts.project && opts.session != nil && dirExists(claudeDir) {
+		err = ensureGitignore(claudeDir, w)
+	}
+
+	if opts.session != nil && len(opts.session.Entries()) > 0 {
+		fmt.Fprintf(w, "\nBackups of this run saved in %s\n", opts.session.Dir())
+		fmt.Fprintf(w, "Undo with: claude-config-merge restore %s\n", filepath.Base(opts.session.Dir()))
+		if opts.report != nil {
+			opts.report.BackupDir = opts.session.Dir()
 		}
+	}
+	if err != nil || opts.session == nil {
+		return err
+	}
+	pruneBackups(claudeDir, cfg.Retention, opts.report, w)
+	return nil
+}
 
-		agentsSrc := filepath.Join(cfg.ConfigDir, ".claude", "agents")
-		agentsDst := filepath.Join(home, ".claude", "agents")
-		if err := runSync(agentsSrc, agentsDst, force, "Agents", w); err != nil {
-			return err
+// printRunHeader names the profile in use, if any, and for a project run the
+// .claude directory it applies to.
+func printRunHeader(profile, claudeDir string, project bool, w io.Writer) {
+	if profile != "" {
+		fmt.Fprintf(w, "Profile: %s\n", profile)
+	}
+	if project {
+		fmt.Fprintf(w, "Target: %s\n", claudeDir)
+	}
+	if profile != "" || project {
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+// stateIgnores are the .gitignore patterns, relative to a project's .claude
+// directory, that cover the backups, snapshots, and manifest the tool keeps
+// there.
+var stateIgnores = []string{backup.DirName + "/", ".claude-config-merge-*"}
+
+// ensureGitignore adds the patterns of stateIgnores that claudeDir/.gitignore
+// lacks, creating the file if needed, so the tool's own files in a project
+// are not committed. The file is not backed up: like the backups it covers,
+// it is kept when a run is restored.
+func ensureGitignore(claudeDir string, w io.Writer) error {
+	path := filepath.Join(claudeDir, ".gitignore")
+	current, err := readOptional(path)
+	if err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for _, line := range strings.Split(current, "\n") {
+		have[strings.TrimSpace(line)] = true
+	}
+	var missing []string
+	for _, pattern := range stateIgnores {
+		if !have[pattern] {
+			missing = append(missing, pattern)
 		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString(current)
+	if current != "" && !strings.HasSuffix(current, "\n") {
+		b.WriteString("\n")
+	}
+	b.WriteString("# claude-config-merge state\n")
+	for _, pattern := range missing {
+		b.WriteString(pattern + "\n")
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil { //nolint:gosec // .gitignore is meant to be shared
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	fmt.Fprintf(w, "Updated %s to ignore the tool's own files\n", path)
+	return nil
+}
 
-		skillsSrc := filepath.Join(cfg.ConfigDir, ".claude", "skills")
-		skillsDst := filepath.Join(home, ".claude", "skills")
-		return runSync(skillsSrc, skillsDst, force, "Skills", w)
+// runSteps runs the steps of subcommand against claudeDir. With
+// opts.project the master files come from each layer's project template.
+func runSteps(subcommand string, cfg *config.Config, claudeDir string, opts runOptions, w io.Writer) error {
+	// A project without settings gets them from the template.
+	opts.createLocal = opts.project
+	layers := cfg.Sources()
+	agentsSrc := layerPaths(layers, opts.project, "agents")
+	agentsDst := filepath.Join(claudeDir, "agents")
+	skillsSrc := layerPaths(layers, opts.project, "skills")
+	skillsDst := filepath.Join(claudeDir, "skills")
 
-	case "cleanup-bak":
-		claudeDir := filepath.Join(home, ".claude")
-		return runCleanupBak(claudeDir, w)
+	switch subcommand {
+	case "settings":
+		return runSettings(cfg, claudeDir, opts, w)
 
-	default:
-		fmt.Fprintf(w, "usage: claude-config-merge [settings|agents|skills|all|cleanup-bak] [-f]\n")
-		return fmt.Errorf("unknown subcommand %q", subcommand)
+	case "diff":
+		return runDiffs(cfg, claudeDir, opts, w)
+
+	case "agents":
+		return runSync(agentsSrc, agentsDst, opts, "Agents", w)
+
+	case "skills":
+		return runSync(skillsSrc, skillsDst, opts, "Skills", w)
+
+	default: // all
+		if err := runSettings(cfg, claudeDir, opts, w); err != nil {
+			return err
+		}
+		if err := runSync(agentsSrc, agentsDst, opts, "Agents", w); err != nil {
+			return err
+		}
+		return runSync(skillsSrc, skillsDst, opts, "Skills", w)
 	}
 }
 
-// loadConfig loads the tool config and resolves the home directory, exiting on
-// any error.
-func loadConfig(configPath string) (cfg *config.Config, home string) {
+// projectDir returns the absolute path of dir, which must be an existing
+// directory.
+func projectDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", dir, err)
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", fmt.Errorf("project directory: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("project directory %s is not a directory", abs)
+	}
+	return abs, nil
+}
+
+// loadConfig loads the tool config with the named profile, or the default
+// one if profile is "", and resolves the home directory for subcommand,
+// exiting through fail on any error.
+func loadConfig(subcommand, configPath, profile string, jsonOutput bool) (cfg *config.Config, home string) {
 	var err error
-	cfg, err = config.Load(configPath)
+	cfg, err = config.LoadProfile(configPath, profile)
 	if err != nil {
-		log.Fatalf("error: %v\n\nCreate %s with contents:\n  {\"configDir\": \"/path/to/your/claude/configs\"}\n", err, configPath)
+		fail(subcommand, jsonOutput, err,
+			fmt.Sprintf("\n\nCreate %s with contents:\n  {\"configDir\": \"/path/to/your/claude/configs\"}\n", configPath))
 	}
 
 	home, err = os.UserHomeDir()
 	if err != nil {
-		log.Fatalf("failed to determine home directory: %v", err)
+		fail(subcommand, jsonOutput, fmt.Errorf("failed to determine home directory: %w", err), "")
 	}
 
 	return cfg, home
 }
 
-// parseForceFlagSet parses a -f flag from args for the named subcommand and
-// returns its value and any parse error.
-func parseForceFlagSet(name string, args []string) (bool, error) {
+// fail reports err, which stopped subcommand before it started, and exits.
+// With jsonOutput the report document, listing err, is first written to
+// stdout, so a JSON caller always gets one; hint is added to the message in
+// text mode only.
+func fail(subcommand string, jsonOutput bool, err error, hint string) {
+	if jsonOutput {
+		rep := newReport(subcommand, false)
+		rep.Errors = append(rep.Errors, err.Error())
+		if werr := rep.write(os.Stdout); werr != nil {
+			err = errors.Join(err, werr)
+		}
+		hint = ""
+	}
+	log.Fatalf("error: %v%s", err, hint)
+}
+
+// commandFlags holds the flags accepted by the sync subcommands.
+type commandFlags struct {
+	force       bool
+	prune       bool
+	dryRun      bool
+	interactive bool
+	verbose     bool
+	noColor     bool
+}
+
+// options returns the run options driven by these flags and cfg.
+func (f commandFlags) options(cfg *config.Config) runOptions {
+	return runOptions{force: f.force, prune: f.prune, dryRun: f.dryRun, interactive: f.interactive, verbose: f.verbose,
+		arrays: cfg.ArrayStrategies, rules: cfg.Rules, include: cfg.SyncInclude, exclude: cfg.SyncExclude}
+}
+
+// parseCommandFlags parses the flags for the named subcommand from args and
+// returns their values and any parse error. -n and -dry-run are synonyms.
+// -i is only accepted by the subcommands that write settings, -v by those
+// that sync agents or skills, and -no-color only by diff, which never writes
+// and so takes no -n.
+func parseCommandFlags(name string, args []string) (commandFlags, error) {
+	var flags commandFlags
 	fs := flag.NewFlagSet(name, flag.ContinueOnError)
-	f := fs.Bool("f", false, "overwrite existing files")
+	fs.BoolVar(&flags.force, "f", false, "overwrite existing files")
+	if name == "diff" {
+		fs.BoolVar(&flags.noColor, "no-color", false, "do not colorize the diff")
+	} else {
+		fs.BoolVar(&flags.dryRun, "n", false, "show what would change without writing anything")
+		fs.BoolVar(&flags.dryRun, "dry-run", false, "show what would change without writing anything")
+	}
+	if name == "diff" {
+		fs.BoolVar(&flags.prune, "prune", false, "remove keys dropped from master that this tool added")
+	} else {
+		fs.BoolVar(&flags.prune, "prune", false, "remove keys and files dropped from master that this tool added")
+	}
+	if name == "settings" || name == "all" {
+		fs.BoolVar(&flags.interactive, "i", false, "choose master, local, or an edited value for each conflict")
+	}
+	if name == "agents" || name == "skills" || name == "all" {
+		fs.BoolVar(&flags.verbose, "v", false, "also list ignored files")
+	}
 	if err := fs.Parse(args); err != nil {
-		return false, fmt.Errorf("%s: %w", name, err)
+		return commandFlags{}, fmt.Errorf("%s: %w", name, err)
 	}
-	return *f, nil
+	return flags, nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"strings"
@@ -31,6 +32,11 @@ func makeConfig(t *testing.T) (cfg *config.Config, configDir, homeDir string) {
 	return cfg, configDir, homeDir
 }
 
+// single returns path as the only layer of a master config.
+func single(path string) []source {
+	return []source{{layer: "master", path: path}}
+}
+
 // ---- dispatch tests ----
 
 func TestDispatch_Settings(t *testing.T) {
@@ -156,6 +162,240 @@ func TestDispatch_AllWithForce(t *testing.T) {
 	}
 }
 
+func TestDispatch_AllDryRunWritesNothing(t *testing.T) {
+	cfg, configDir, homeDir := makeConfig(t)
+
+	masterPath := filepath.Join(configDir, ".claude", "settings.json")
+	localPath := filepath.Join(homeDir, ".claude", "settings.json")
+	writeJSON(t, masterPath, map[string]any{"newKey": "value"})
+	writeJSON(t, localPath, map[string]any{})
+
+	agentsSrc := filepath.Join(configDir, ".claude", "agents")
+	if err := os.MkdirAll(agentsSrc, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(agentsSrc, "a.md"), []byte("a"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := dispatch("all", []string{"-f", "-n"}, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(homeDir, ".claude"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("~/.claude entries = %v; want only settings.json", names)
+	}
+	if !strings.Contains(buf.String(), "a.md") {
+		t.Errorf("expected agent listed in dry-run output, got:\n%s", buf.String())
+	}
+}
+
+// makeLayers returns a config with company, team, and personal layers under
+// a fresh temp directory, each with a .claude directory, and a home
+// directory with an empty ~/.claude/settings.json.
+func makeLayers(t *testing.T) (cfg *config.Config, layerDirs []string, homeDir string) {
+	t.Helper()
+	base := t.TempDir()
+	cfg = &config.Config{}
+	for _, name := range []string{"company", "team", "personal"} {
+		dir := filepath.Join(base, name)
+		if err := os.MkdirAll(filepath.Join(dir, ".claude", "agents"), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		cfg.Layers = append(cfg.Layers, config.Layer{Name: name, Dir: dir})
+		layerDirs = append(layerDirs, dir)
+	}
+	homeDir = filepath.Join(base, "home")
+	if err := os.MkdirAll(filepath.Join(homeDir, ".claude"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	writeJSON(t, filepath.Join(homeDir, ".claude", "settings.json"), map[string]any{})
+	return cfg, layerDirs, homeDir
+}
+
+func TestDispatch_AllMergesLayers(t *testing.T) {
+	cfg, layers, homeDir := makeLayers(t)
+	company, team, personal := layers[0], layers[1], layers[2]
+	writeJSON(t, filepath.Join(company, ".claude", "settings.json"), map[string]any{
+		"model": "sonnet",
+		"env":   map[string]any{"A": "company", "B": "company"},
+	})
+	writeJSON(t, filepath.Join(team, ".claude", "settings.json"), map[string]any{
+		"env": map[string]any{"B": "team"},
+	})
+	// The personal layer has no settings.json, only an agent.
+	for path, content := range map[string]string{
+		filepath.Join(company, ".claude", "agents", "a.md"):  "company a",
+		filepath.Join(company, ".claude", "agents", "b.md"):  "company b",
+		filepath.Join(personal, ".claude", "agents", "b.md"): "personal b",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := dispatch("all", nil, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claudeDir := filepath.Join(homeDir, ".claude")
+	got := readJSON(t, filepath.Join(claudeDir, "settings.json"))
+	if env, _ := got["env"].(map[string]any); got["model"] != "sonnet" || env["A"] != "company" || env["B"] != "team" {
+		t.Errorf("settings = %v; want model and env.A from company, env.B from team", got)
+	}
+	if b, _ := os.ReadFile(filepath.Join(claudeDir, "agents", "b.md")); string(b) != "personal b" {
+		t.Errorf("agents/b.md = %q; want the personal version", b)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"model  (from company)",
+		"env  (from company, team)",
+		"a.md  (from company)",
+		"b.md  (from personal)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	writeJSON(t, filepath.Join(personal, ".claude", "settings.json"), map[string]any{"model": "opus"})
+	if err := dispatchJSON("settings", []string{"-f"}, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var rep report
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Settings) != 1 || rep.Settings[0].Origins["model"] != "personal" || len(rep.Settings[0].Layers) != 3 {
+		t.Errorf("settings report = %+v; want model from personal and three layers", rep.Settings)
+	}
+}
+
+func TestDispatch_ReportsActiveProfile(t *testing.T) {
+	cfg, configDir, homeDir := makeConfig(t)
+	cfg.Profile = "client-x"
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.json"), map[string]any{"k": "v"})
+	writeJSON(t, filepath.Join(homeDir, ".claude", "settings.json"), map[string]any{})
+
+	var buf bytes.Buffer
+	if err := dispatch("settings", []string{"-n"}, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "Profile: client-x\n") {
+		t.Errorf("want the profile named first, got:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := dispatchJSON("settings", []string{"-n"}, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var rep report
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Profile != "client-x" {
+		t.Errorf("report profile = %q; want client-x", rep.Profile)
+	}
+}
+
+func TestDispatch_TargetUsesProjectTemplate(t *testing.T) {
+	cfg, configDir, homeDir := makeConfig(t)
+	project := filepath.Join(t.TempDir(), "repo")
+	template := filepath.Join(configDir, "project", ".claude")
+	if err := os.MkdirAll(filepath.Join(template, "agents"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(project, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.json"), map[string]any{"model": "opus"})
+	writeJSON(t, filepath.Join(template, "settings.json"), map[string]any{"permissions": map[string]any{"allow": []any{"Bash(make)"}}})
+	if err := os.WriteFile(filepath.Join(template, "agents", "reviewer.md"), []byte("review"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Target = project
+
+	var buf bytes.Buffer
+	if err := dispatch("all", nil, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claudeDir := filepath.Join(project, ".claude")
+	got := readJSON(t, filepath.Join(claudeDir, "settings.json"))
+	if _, ok := got["permissions"]; !ok || got["model"] != nil {
+		t.Errorf("project settings = %v; want the template's, not the home master's", got)
+	}
+	if b, _ := os.ReadFile(filepath.Join(claudeDir, "agents", "reviewer.md")); string(b) != "review" {
+		t.Errorf("agents/reviewer.md = %q; want the template agent", b)
+	}
+	if _, err := os.Stat(filepath.Join(homeDir, ".claude", "settings.json")); !os.IsNotExist(err) {
+		t.Errorf("home settings should be untouched (stat err = %v)", err)
+	}
+	if !strings.Contains(buf.String(), "Target: "+claudeDir) {
+		t.Errorf("want the target named, got:\n%s", buf.String())
+	}
+
+	// The run can be undone in the project like any other.
+	buf.Reset()
+	if err := dispatch("restore", nil, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(claudeDir, "settings.json")); !os.IsNotExist(err) {
+		t.Errorf("restore should remove the settings the run created (stat err = %v)", err)
+	}
+}
+
+func TestDispatch_TargetFallsBackToHomeLayout(t *testing.T) {
+	cfg, configDir, _ := makeConfig(t)
+	project := t.TempDir()
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.json"), map[string]any{"model": "opus"})
+	cfg.Target = project
+
+	if err := dispatch("settings", nil, cfg, t.TempDir(), &bytes.Buffer{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := readJSON(t, filepath.Join(project, ".claude", "settings.json")); got["model"] != "opus" {
+		t.Errorf("project settings = %v; want the master settings without a template", got)
+	}
+}
+
+func TestDispatch_TargetIgnoresToolState(t *testing.T) {
+	cfg, configDir, homeDir := makeConfig(t)
+	project := t.TempDir()
+	writeJSON(t, filepath.Join(configDir, ".claude", "settings.json"), map[string]any{"model": "opus"})
+	claudeDir := filepath.Join(project, ".claude")
+	if err := os.MkdirAll(claudeDir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	gitignore := filepath.Join(claudeDir, ".gitignore")
+	if err := os.WriteFile(gitignore, []byte("scratch/\n.backups/"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Target = project
+
+	for i := range 2 {
+		if err := dispatch("settings", nil, cfg, homeDir, &bytes.Buffer{}); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+
+	want := "scratch/\n.backups/\n# claude-config-merge state\n.claude-config-merge-*\n"
+	if b, _ := os.ReadFile(gitignore); string(b) != want {
+		t.Errorf(".gitignore = %q; want %q", b, want)
+	}
+}
+
 func TestDispatch_UnknownSubcommand(t *testing.T) {
 	cfg, _, homeDir := makeConfig(t)
 
@@ -177,30 +417,30 @@ func TestDispatch_UnknownSubcommand(t *testing.T) {
 	}
 }
 
-// ---- parseForceFlagSet tests ----
+// ---- parseCommandFlags tests ----
 
-func TestParseForceFlagSet_DefaultFalse(t *testing.T) {
-	got, err := parseForceFlagSet("test", []string{})
+func TestParseCommandFlags_DefaultFalse(t *testing.T) {
+	got, err := parseCommandFlags("test", []string{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if got {
+	if got.force {
 		t.Error("expected false when -f not provided, got true")
 	}
 }
 
-func TestParseForceFlagSet_TrueWhenFlagSet(t *testing.T) {
-	got, err := parseForceFlagSet("test", []string{"-f"})
+func TestParseCommandFlags_TrueWhenFlagSet(t *testing.T) {
+	got, err := parseCommandFlags("test", []string{"-f"})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if !got {
+	if !got.force {
 		t.Error("expected true when -f is provided, got false")
 	}
 }
 
-func TestParseForceFlagSet_UnknownFlag(t *testing.T) {
-	_, err := parseForceFlagSet("test", []string{"-unknown"})
+func TestParseCommandFlags_UnknownFlag(t *testing.T) {
+	_, err := parseCommandFlags("test", []string{"-unknown"})
 	if err == nil {
 		t.Fatal("expected error for unknown flag, got nil")
 	}
@@ -209,6 +449,39 @@ func TestParseForceFlagSet_UnknownFlag(t *testing.T) {
 	}
 }
 
+func TestParseCommandFlags_Prune(t *testing.T) {
+	for _, name := range []string{"settings", "agents", "skills", "all", "diff"} {
+		got, err := parseCommandFlags(name, []string{"-prune"})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if !got.prune {
+			t.Errorf("%s: expected prune when -prune is provided, got false", name)
+		}
+	}
+}
+
+func TestParseCommandFlags_InteractiveOnlyForSettings(t *testing.T) {
+	if _, err := parseCommandFlags("settings", []string{"-i"}); err != nil {
+		t.Errorf("settings: unexpected error: %v", err)
+	}
+	if _, err := parseCommandFlags("agents", []string{"-i"}); err == nil {
+		t.Error("expected error for -i on agents, got nil")
+	}
+}
+
+func TestParseCommandFlags_DryRun(t *testing.T) {
+	for _, arg := range []string{"-n", "-dry-run", "--dry-run"} {
+		got, err := parseCommandFlags("skills", []string{arg})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", arg, err)
+		}
+		if !got.dryRun {
+			t.Errorf("%s: expected dryRun, got false", arg)
+		}
+	}
+}
+
 // ---- dirExists tests ----
 
 func TestDirExists_ExistingDir(t *testing.T) {
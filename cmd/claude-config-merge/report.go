@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jeff/claude-config-merge/internal/backup"
+	"github.com/jeff/claude-config-merge/internal/dirsync"
+	"github.com/jeff/claude-config-merge/internal/merge"
+)
+
+// reportSchemaVersion is bumped whenever a field of report is renamed or
+// removed. Adding fields does not change it.
+const reportSchemaVersion = 1
+
+// report is the machine-readable document emitted by -output json. Every
+// list is always present (possibly empty) so scripts need not check for null.
+type report struct {
+	SchemaVersion int              `json:"schemaVersion"`
+	Command       string           `json:"command"`
+	DryRun        bool             `json:"dryRun"`
+	Settings      []settingsReport `json:"settings"`
+	Sync          []syncReport     `json:"sync"`
+	Backups       []string         `json:"backups"`
+	// BackupDir is the backup session directory of the run, if it saved any.
+	BackupDir string `json:"backupDir,omitempty"`
+	// DeletedBackups lists backup files removed by the retention policy.
+	DeletedBackups []string `json:"deletedBackups"`
+	// Shadowed lists the keys set in both settings.json and
+	// settings.local.json.
+	Shadowed []shadowReport `json:"shadowed"`
+	// Profile is the config profile in use, if any.
+	Profile string `json:"profile,omitempty"`
+	// Target is the project directory the command applied to, if not ~.
+	Target string `json:"target,omitempty"`
+	// Repos lists the runs of the repos command, one per repository.
+	Repos []repoReport `json:"repos,omitempty"`
+	// Available lists the backups found by the backups command, newest
+	// first.
+	Available []backupReport `json:"available,omitempty"`
+	// Restored lists the files the restore command put back or removed, or
+	// would with -n.
+	Restored []string `json:"restored,omitempty"`
+	// Outcome is how the all command ended: applied, reverted, or partial.
+	Outcome string   `json:"outcome,omitempty"`
+	Errors  []string `json:"errors"`
+}
+
+// settingsReport describes one settings merge.
+type settingsReport struct {
+	MasterPath     string           `json:"masterPath"`
+	LocalPath      string           `json:"localPath"`
+	Added          []string         `json:"added"`
+	Updated        []string         `json:"updated"`
+	Forced         []string         `json:"forced"`
+	Removed        []string         `json:"removed"`
+	Resolved       []string         `json:"resolved"`
+	Conflicts      []conflictReport `json:"conflicts"`
+	KeptLocal      []string         `json:"keptLocal"`
+	Matching       []string         `json:"matching"`
+	LocalOnly      []string         `json:"localOnly"`
+	Ignored        []string         `json:"ignored"`
+	ArrayAdditions []arrayReport    `json:"arrayAdditions"`
+	Deduplicated   []string         `json:"deduplicated"`
+	Rules          []ruleReport     `json:"rules"`
+	Written        bool             `json:"written"`
+	Backup         string           `json:"backup,omitempty"`
+	// Layers lists the master files merged, lowest precedence first, when
+	// the config has more than one layer.
+	Layers []string `json:"layers,omitempty"`
+	// Origins maps each added, updated, or forced key to the layer its
+	// value came from, when the config has more than one layer.
+	Origins map[string]string `json:"origins,omitempty"`
+}
+
+// conflictReport is a key whose master and local values differ.
+type conflictReport struct {
+	Key    string `json:"key"`
+	Master any    `json:"master"`
+	Local  any    `json:"local"`
+}
+
+// shadowReport is a key set in both settings files, with both values.
+type shadowReport struct {
+	Key           string `json:"key"`
+	Settings      any    `json:"settings"`
+	SettingsLocal any    `json:"settingsLocal"`
+}
+
+// arrayReport lists the elements an array strategy added to one array.
+type arrayReport struct {
+	Key    string `json:"key"`
+	Values []any  `json:"values"`
+}
+
+// ruleReport is a key whose outcome a merge rule decided.
+type ruleReport struct {
+	Key     string `json:"key"`
+	Pattern string `json:"pattern"`
+	Policy  string `json:"policy"`
+}
+
+// backupReport describes one backup listed by the backups command.
+type backupReport struct {
+	ID   string    `json:"id"`
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+	// Files lists the files the backup holds, relative to the .claude
+	// directory.
+	Files []string `json:"files"`
+}
+
+// syncReport describes one agents or skills directory sync.
+type syncReport struct {
+	Label          string   `json:"label"`
+	Source         string   `json:"source"`
+	Destination    string   `json:"destination"`
+	SourceMissing  bool     `json:"sourceMissing"`
+	SymlinkSkipped bool     `json:"symlinkSkipped"`
+	Copied         []string `json:"copied"`
+	Updated        []string `json:"updated"`
+	Unchanged      []string `json:"unchanged"`
+	Skipped        []string `json:"skipped"`
+	Modified       []string `json:"modified"`
+	Forced         []string `json:"forced"`
+	Removed        []string `json:"removed"`
+	Ignored        []string `json:"ignored"`
+	// Layers lists the source directories overlaid, lowest precedence
+	// first, when the config has more than one layer.
+	Layers []string `json:"layers,omitempty"`
+	// Origins maps each copied, updated, or forced file to the layer it came
+	// from, when the config has more than one layer.
+	Origins map[string]string `json:"origins,omitempty"`
+}
+
+// newReport returns an empty report for command.
+func newReport(command string, dryRun bool) *report {
+	return &report{
+		SchemaVersion:  reportSchemaVersion,
+		Command:        command,
+		DryRun:         dryRun,
+		Settings:       []settingsReport{},
+		Sync:           []syncReport{},
+		Backups:        []string{},
+		DeletedBackups: []string{},
+		Shadowed:       []shadowReport{},
+		Errors:         []string{},
+	}
+}
+
+// addSettings records the result res of the settings merge planned by plan
+// and returns the entry so the caller can fill in what was written. On a nil
+// report it returns a throwaway entry, so callers need not check whether JSON
+// output is enabled.
+func (r *report) addSettings(plan *settingsPlan, res *merge.Result) *settingsReport {
+	entry := settingsReport{
+		MasterPath:     plan.masterPath,
+		LocalPath:      plan.localPath,
+		Added:          orEmpty(res.Added),
+		Updated:        orEmpty(res.Updated),
+		Forced:         orEmpty(res.Forced),
+		Removed:        orEmpty(res.Removed),
+		Resolved:       orEmpty(res.Resolved),
+		Conflicts:      make([]conflictReport, 0, len(res.Conflicts)),
+		KeptLocal:      orEmpty(res.KeptLocal),
+		Matching:       orEmpty(res.Matching),
+		LocalOnly:      orEmpty(res.LocalOnly),
+		Ignored:        orEmpty(res.Ignored),
+		ArrayAdditions: make([]arrayReport, 0, len(res.ArrayAdditions)),
+		Deduplicated:   orEmpty(res.Deduplicated),
+		Rules:          make([]ruleReport, 0, len(res.Decisions)),
+	}
+	for _, c := range res.Conflicts {
+		entry.Conflicts = append(entry.Conflicts, conflictReport{Key: c.Key, Master: c.MasterValue, Local: c.LocalValue})
+	}
+	for _, a := range res.ArrayAdditions {
+		entry.ArrayAdditions = append(entry.ArrayAdditions, arrayReport{Key: a.Key, Values: a.Values})
+	}
+	for _, d := range res.Decisions {
+		entry.Rules = append(entry.Rules, ruleReport{Key: d.Key, Pattern: d.Pattern, Policy: string(d.Policy)})
+	}
+	if plan.origins != nil {
+		entry.Layers = plan.masterPaths
+		entry.Origins = map[string]string{}
+		for _, keys := range [][]string{res.Added, res.Updated, res.Forced} {
+			for _, k := range keys {
+				entry.Origins[k] = plan.origins.of(k)
+			}
+		}
+	}
+
+	if r == nil {
+		return &entry
+	}
+	r.Settings = append(r.Settings, entry)
+	return &r.Settings[len(r.Settings)-1]
+}
+
+// addSync records a directory sync and returns the entry so the caller can
+// fill in the outcome. Like addSettings it is safe to call on a nil report.
+func (r *report) addSync(label, src, dst string) *syncReport {
+	entry := syncReport{
+		Label:       label,
+		Source:      src,
+		Destination: dst,
+		Copied:      []string{},
+		Updated:     []string{},
+		Unchanged:   []string{},
+		Skipped:     []string{},
+		Modified:    []string{},
+		Forced:      []string{},
+		Removed:     []string{},
+		Ignored:     []string{},
+	}
+	if r == nil {
+		return &entry
+	}
+	r.Sync = append(r.Sync, entry)
+	return &r.Sync[len(r.Sync)-1]
+}
+
+// setResult copies the lists of res into the entry.
+func (s *syncReport) setResult(res *dirsync.Result) {
+	s.Copied = orEmpty(res.Copied)
+	s.Updated = orEmpty(res.Updated)
+	s.Unchanged = orEmpty(res.Unchanged)
+	s.Skipped = orEmpty(res.Skipped)
+	s.Modified = orEmpty(res.Modified)
+	s.Forced = orEmpty(res.Forced)
+	s.Removed = orEmpty(res.Removed)
+	s.Ignored = orEmpty(res.Ignored)
+}
+
+// addBackup records a backup file created during the run.
+func (r *report) addBackup(path string) {
+	if r != nil {
+		r.Backups = append(r.Backups, path)
+	}
+}
+
+// addAvailableBackup records a backup listed by the backups command.
+func (r *report) addAvailableBackup(b backup.Backup) {
+	if r != nil {
+		r.Available = append(r.Available, backupReport{ID: b.ID, Path: b.Path, Time: b.Time, Size: b.Size, Files: backupFiles(b)})
+	}
+}
+
+// addRestored records a file restored or removed by the restore command.
+func (r *report) addRestored(path string) {
+	if r != nil {
+		r.Restored = append(r.Restored, path)
+	}
+}
+
+// setOutcome records how the all command ended.
+func (r *report) setOutcome(outcome string) {
+	if r != nil {
+		r.Outcome = outcome
+	}
+}
+
+// addShadowed records a key set in both settings files.
+func (r *report) addShadowed(s merge.Shadow) {
+	if r != nil {
+		r.Shadowed = append(r.Shadowed, shadowReport{Key: s.Key, Settings: s.LowerValue, SettingsLocal: s.UpperValue})
+	}
+}
+
+// addDeletedBackup records a backup file deleted during the run.
+func (r *report) addDeletedBackup(path string) {
+	if r != nil {
+		r.DeletedBackups = append(r.DeletedBackups, path)
+	}
+}
+
+// write encodes the report as indented JSON to w.
+func (r *report) write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(r); err != nil {
+		return fmt.Errorf("writing JSON report: %w", err)
+	}
+	return nil
+}
+
+// orEmpty returns s, or an empty non-nil slice if s is nil, so it encodes as
+// [] rather than null.
+func orEmpty(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
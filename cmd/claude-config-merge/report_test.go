@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDispatchJSON_All(t *testing.T) {
+	cfg, configDir, homeDir := makeConfig(t)
+
+	masterPath := filepath.Join(configDir, ".claude", "settings.json")
+	localPath := filepath.Join(homeDir, ".claude", "settings.json")
+	writeJSON(t, masterPath, map[string]any{"newKey": "value", "shared": "master"})
+	writeJSON(t, localPath, map[string]any{"shared": "local", "mine": true})
+
+	agentsSrc := filepath.Join(configDir, ".claude", "agents")
+	if err := os.MkdirAll(agentsSrc, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(agentsSrc, "a.md"), []byte("a"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := dispatchJSON("all", nil, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var rep report
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil {
+		t.Fatalf("output is not a single JSON document: %v\n%s", err, buf.String())
+	}
+
+	if rep.SchemaVersion != reportSchemaVersion || rep.Command != "all" {
+		t.Errorf("schemaVersion/command = %d/%q", rep.SchemaVersion, rep.Command)
+	}
+	checkAllSettingsReport(t, rep)
+
+	if len(rep.Sync) != 2 {
+		t.Fatalf("sync = %d entries; want 2", len(rep.Sync))
+	}
+	if rep.Sync[0].Label != "Agents" || len(rep.Sync[0].Copied) != 1 {
+		t.Errorf("sync[0] = %+v; want Agents with one copied file", rep.Sync[0])
+	}
+	if rep.Sync[1].Label != "Skills" || !rep.Sync[1].SourceMissing {
+		t.Errorf("sync[1] = %+v; want Skills with sourceMissing", rep.Sync[1])
+	}
+
+	// Lists are always present, never null.
+	if strings.Contains(buf.String(), "null") {
+		t.Errorf("expected no null values in report, got:\n%s", buf.String())
+	}
+}
+
+// checkAllSettingsReport checks the settings entry and backups that
+// TestDispatchJSON_All's run recorded in rep.
+func checkAllSettingsReport(t *testing.T, rep report) {
+	t.Helper()
+	if len(rep.Settings) != 1 {
+		t.Fatalf("settings = %d entries; want 1", len(rep.Settings))
+	}
+	s := rep.Settings[0]
+	if len(s.Added) != 1 || s.Added[0] != "newKey" {
+		t.Errorf("settings.added = %v; want [newKey]", s.Added)
+	}
+	if len(s.Conflicts) != 1 || s.Conflicts[0].Master != "master" || s.Conflicts[0].Local != "local" {
+		t.Errorf("settings.conflicts = %+v; want shared master/local", s.Conflicts)
+	}
+	if !s.Written || s.Backup == "" {
+		t.Errorf("settings.written/backup = %v/%q; want written with a backup", s.Written, s.Backup)
+	}
+	if len(rep.Backups) != 1 || rep.Backups[0] != s.Backup {
+		t.Errorf("backups = %v; want [%s]", rep.Backups, s.Backup)
+	}
+}
+
+func TestDispatchJSON_ReportsErrors(t *testing.T) {
+	cfg, _, homeDir := makeConfig(t)
+
+	var buf bytes.Buffer
+	err := dispatchJSON("settings", []string{"-n"}, cfg, homeDir, &buf)
+	if err == nil {
+		t.Fatal("expected error for missing settings files, got nil")
+	}
+
+	var rep report
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if len(rep.Errors) != 1 {
+		t.Errorf("errors = %v; want one error", rep.Errors)
+	}
+	if !rep.DryRun {
+		t.Error("dryRun = false; want true")
+	}
+}
+
+func TestDispatchJSON_BackupsAndRestore(t *testing.T) {
+	cfg, homeDir := setupForcedRun(t)
+	claudeDir := filepath.Join(homeDir, ".claude")
+	if err := dispatch("all", []string{"-f"}, cfg, homeDir, &bytes.Buffer{}); err != nil {
+		t.Fatalf("all -f: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := dispatchJSON("backups", nil, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("backups: %v", err)
+	}
+	var rep report
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if len(rep.Available) != 1 {
+		t.Fatalf("available = %+v; want the session of the run", rep.Available)
+	}
+	listed := rep.Available[0]
+	if listed.ID == "" || listed.Time.IsZero() || listed.Size == 0 ||
+		strings.Join(listed.Files, ",") != "settings.json,agents/a.md,skills/new/SKILL.md" {
+		t.Errorf("available[0] = %+v; want id, time, size, and the files of the run", listed)
+	}
+
+	buf.Reset()
+	if err := dispatchJSON("restore", []string{"-n"}, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("restore -n: %v", err)
+	}
+	rep = report{}
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	for _, name := range []string{"settings.json", "agents/a.md", "skills/new/SKILL.md"} {
+		if !slices.Contains(rep.Restored, filepath.Join(claudeDir, filepath.FromSlash(name))) {
+			t.Errorf("restored = %v; want %s", rep.Restored, name)
+		}
+	}
+	if !rep.DryRun {
+		t.Error("dryRun = false; want true")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/jeff/claude-config-merge/internal/config"
+	"github.com/jeff/claude-config-merge/internal/repos"
+)
+
+// reposOptions holds the flags and workspace roots of the repos subcommand.
+type reposOptions struct {
+	flags commandFlags
+	roots []string
+	find  repos.Options
+}
+
+// repoReport summarizes the all run in one repository.
+type repoReport struct {
+	Path      string `json:"path"`
+	Added     int    `json:"added"`
+	Conflicts int    `json:"conflicts"`
+	Errors    int    `json:"errors"`
+	// Status is applied, reverted, or partial as for all, "dry run", or
+	// "failed" for a run that stopped before its steps were checked.
+	Status string `json:"status"`
+	// BackupDir is the backup session of the run in the repository, if it
+	// saved any.
+	BackupDir string `json:"backupDir,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// runRepos finds the repositories under opts.roots and runs all against the
+// .claude directory of each, as -target does for one. A failure in one
+// repository is reported and the rest still run. A summary table of what
+// each run added, the conflicts it kept local, and its errors is printed to
+// w; with -v each run's full report comes first. The runs are recorded in
+// rep.
+func runRepos(cfg *config.Config, opts reposOptions, rep *report, w io.Writer) error {
+	found, err := repos.Find(opts.roots, opts.find)
+	if err != nil {
+		return fmt.Errorf("repos: %w", err)
+	}
+	if len(found) == 0 {
+		fmt.Fprintf(w, "No repositories found under %s\n", strings.Join(opts.roots, ", "))
+		return nil
+	}
+
+	run := opts.flags.options(cfg)
+	run.project = true
+	results := make([]repoReport, 0, len(found))
+	failed := 0
+	for _, dir := range found {
+		res := runRepo(cfg, dir, run, w)
+		if res.Errors > 0 {
+			failed++
+		}
+		results = append(results, res)
+	}
+	if rep != nil {
+		rep.Repos = results
+	}
+
+	printRepoTable(results, w)
+	if failed > 0 {
+		fmt.Fprintf(w, "\nErrors:\n")
+		for _, r := range results {
+			if r.Error != "" {
+				fmt.Fprintf(w, "  %s: %s\n", r.Path, r.Error)
+			}
+		}
+	}
+
+	switch {
+	case failed > 0:
+		fmt.Fprintf(w, "\nRepos: %d of %d repositories failed.\n", failed, len(found))
+		return fmt.Errorf("repos: %d of %d repositories failed", failed, len(found))
+	case run.dryRun:
+		fmt.Fprintf(w, "\nDry run: checked %d repositories (no files changed).\n", len(found))
+	default:
+		fmt.Fprintf(w, "\nRepos: applied to %d repositories. Undo one with: claude-config-merge -target DIR restore\n", len(found))
+	}
+	return nil
+}
+
+// runRepo runs all against the .claude directory of the repository dir and
+// summarizes the run. With opts.verbose the run's report is written to w.
+func runRepo(cfg *config.Config, dir string, opts runOptions, w io.Writer) repoReport {
+	rep := newReport("all", opts.dryRun)
+	opts.report = rep
+	var out bytes.Buffer
+	err := runCommand("all", cfg, filepath.Join(dir, ".claude"), opts, &out)
+	if opts.verbose {
+		fmt.Fprintf(w, "== %s ==\n%s\n", dir, out.String())
+	}
+
+	res := repoReport{Path: dir, Status: rep.Outcome, BackupDir: rep.BackupDir}
+	for _, s := range rep.Settings {
+		res.Added += len(s.Added)
+		res.Conflicts += len(s.Conflicts)
+	}
+	for _, s := range rep.Sync {
+		res.Added += len(s.Copied)
+		res.Conflicts += len(s.Skipped) + len(s.Modified)
+	}
+	switch {
+	case err != nil:
+		res.Errors = 1
+		res.Error = err.Error()
+		if res.Status == "" {
+			res.Status = "failed"
+		}
+	case opts.dryRun:
+		res.Status = "dry run"
+	}
+	return res
+}
+
+// printRepoTable writes one row per repository run to w.
+func printRepoTable(results []repoReport, w io.Writer) {
+	width := len("REPOSITORY")
+	for _, r := range results {
+		width = max(width, len(r.Path))
+	}
+	fmt.Fprintf(w, "%-*s  %5s  %9s  %6s  %s\n", width, "REPOSITORY", "ADDED", "CONFLICTS", "ERRORS", "STATUS")
+	for _, r := range results {
+		fmt.Fprintf(w, "%-*s  %5d  %9d  %6d  %s\n", width, r.Path, r.Added, r.Conflicts, r.Errors, r.Status)
+	}
+}
+
+// globList is a repeatable flag collecting globs.
+type globList []string
+
+func (g *globList) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globList) Set(pattern string) error {
+	if err := repos.ValidatePattern(pattern); err != nil {
+		return err
+	}
+	*g = append(*g, pattern)
+	return nil
+}
+
+// parseReposArgs parses the flags and workspace roots of the repos
+// subcommand. Roots given as arguments replace those of cfg; -include and
+// -exclude add to its globs and -depth overrides its depth.
+func parseReposArgs(args []string, cfg config.ReposConfig) (reposOptions, error) {
+	var opts reposOptions
+	include := globList(append([]string(nil), cfg.Include...))
+	exclude := globList(append([]string(nil), cfg.Exclude...))
+	fs := flag.NewFlagSet("repos", flag.ContinueOnError)
+	fs.BoolVar(&opts.flags.force, "f", false, "overwrite existing files")
+	fs.BoolVar(&opts.flags.dryRun, "n", false, "show what would change without writing anything")
+	fs.BoolVar(&opts.flags.dryRun, "dry-run", false, "show what would change without writing anything")
+	fs.BoolVar(&opts.flags.prune, "prune", false, "remove keys and files dropped from master that this tool added")
+	fs.BoolVar(&opts.flags.verbose, "v", false, "also print the report of each repository")
+	fs.IntVar(&opts.find.MaxDepth, "depth", cfg.MaxDepth, "search `N` levels below each root")
+	fs.Var(&include, "include", "only use repositories matching `GLOB` (repeatable)")
+	fs.Var(&exclude, "exclude", "do not search directories matching `GLOB` (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return reposOptions{}, fmt.Errorf("repos: %w", err)
+	}
+	if opts.find.MaxDepth < 0 {
+		return reposOptions{}, fmt.Errorf("repos: -depth must not be negative")
+	}
+	opts.find.Include = include
+	opts.find.Exclude = exclude
+
+	roots := fs.Args()
+	if len(roots) == 0 {
+		roots = cfg.Roots
+	}
+	if len(roots) == 0 {
+		return reposOptions{}, fmt.Errorf("repos: no workspace roots: pass them as arguments or set repos.roots in the config")
+	}
+	for _, root := range roots {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return reposOptions{}, fmt.Errorf("repos: resolving %s: %w", root, err)
+		}
+		opts.roots = append(opts.roots, abs)
+	}
+	return opts, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jeff/claude-config-merge/internal/config"
+)
+
+// makeWorkspace creates a project template in configDir and a workspace
+// holding three repositories: api (a git checkout), web (with settings that
+// conflict with the template), and broken (with unparseable settings). It
+// returns the workspace root.
+func makeWorkspace(t *testing.T, configDir string) string {
+	t.Helper()
+	template := filepath.Join(configDir, "project", ".claude")
+	workspace := t.TempDir()
+	for _, dir := range []string{
+		filepath.Join(template, "agents"),
+		filepath.Join(workspace, "api", ".git"),
+		filepath.Join(workspace, "web", ".claude"),
+		filepath.Join(workspace, "broken", ".claude"),
+		filepath.Join(workspace, "notes"),
+	} {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeJSON(t, filepath.Join(template, "settings.json"), map[string]any{"model": "opus"})
+	writeJSON(t, filepath.Join(workspace, "web", ".claude", "settings.json"), map[string]any{"model": "sonnet"})
+	for path, content := range map[string]string{
+		filepath.Join(template, "agents", "reviewer.md"):               "review",
+		filepath.Join(workspace, "broken", ".claude", "settings.json"): "{not json",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return workspace
+}
+
+func TestDispatch_ReposAppliesToEveryRepository(t *testing.T) {
+	cfg, configDir, homeDir := makeConfig(t)
+	workspace := makeWorkspace(t, configDir)
+
+	var buf bytes.Buffer
+	err := dispatch("repos", []string{workspace}, cfg, homeDir, &buf)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 repositories failed") {
+		t.Fatalf("err = %v; want one of three repositories failed", err)
+	}
+
+	// The failure in broken did not stop the others.
+	api := filepath.Join(workspace, "api", ".claude")
+	if got := readJSON(t, filepath.Join(api, "settings.json")); got["model"] != "opus" {
+		t.Errorf("api settings = %v; want the template's", got)
+	}
+	if b, _ := os.ReadFile(filepath.Join(api, "agents", "reviewer.md")); string(b) != "review" {
+		t.Errorf("api agents/reviewer.md = %q; want the template agent", b)
+	}
+	if got := readJSON(t, filepath.Join(workspace, "web", ".claude", "settings.json")); got["model"] != "sonnet" {
+		t.Errorf("web model = %v; want the local value kept without -f", got["model"])
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "notes", ".claude")); !os.IsNotExist(err) {
+		t.Errorf("notes is not a repository and should be left alone (stat err = %v)", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		"REPOSITORY", "ADDED", "CONFLICTS", "ERRORS",
+		"Errors:\n  " + filepath.Join(workspace, "broken") + ": ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	rows := map[string][]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if f := strings.Fields(line); len(f) >= 5 && strings.HasPrefix(f[0], workspace) {
+			rows[filepath.Base(f[0])] = f[1:]
+		}
+	}
+	for name, want := range map[string]string{
+		"api":    "2 0 0 applied",
+		"web":    "1 1 0 applied",
+		"broken": "0 0 1 reverted",
+	} {
+		if got := strings.Join(rows[name], " "); got != want {
+			t.Errorf("row for %s = %q; want %q", name, got, want)
+		}
+	}
+}
+
+func TestDispatchJSON_ReposDryRun(t *testing.T) {
+	cfg, configDir, homeDir := makeConfig(t)
+	workspace := makeWorkspace(t, configDir)
+	cfg.Repos = config.ReposConfig{Roots: []string{workspace}, Exclude: []string{"broken"}}
+
+	var buf bytes.Buffer
+	if err := dispatchJSON("repos", []string{"-n"}, cfg, homeDir, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var rep report
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if !rep.DryRun || len(rep.Repos) != 2 {
+		t.Fatalf("report = %+v; want a dry run over api and web", rep)
+	}
+	for _, r := range rep.Repos {
+		if r.Status != "dry run" || r.Added == 0 {
+			t.Errorf("repo %+v; want a dry run that would add files", r)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "api", ".claude")); !os.IsNotExist(err) {
+		t.Errorf("dry run created api/.claude (stat err = %v)", err)
+	}
+}
+
+func TestDispatch_ReposRejectsTarget(t *testing.T) {
+	cfg, _, homeDir := makeConfig(t)
+	cfg.Target = t.TempDir()
+
+	if err := dispatch("repos", []string{t.TempDir()}, cfg, homeDir, &bytes.Buffer{}); err == nil {
+		t.Error("want error for repos with -target")
+	}
+}
+
+func TestParseReposArgs(t *testing.T) {
+	cfg := config.ReposConfig{Roots: []string{"/src"}, Exclude: []string{"node_modules"}, MaxDepth: 2}
+
+	opts, err := parseReposArgs([]string{"-exclude", "archive", "-include", "svc-*"}, cfg)
+	if err != nil {
+		t.Fatalf("parseReposArgs: %v", err)
+	}
+	if len(opts.roots) != 1 || opts.roots[0] != filepath.Clean("/src") || opts.find.MaxDepth != 2 {
+		t.Errorf("roots = %v, depth = %d; want the configured ones", opts.roots, opts.find.MaxDepth)
+	}
+	if strings.Join(opts.find.Exclude, ",") != "node_modules,archive" || strings.Join(opts.find.Include, ",") != "svc-*" {
+		t.Errorf("exclude = %v, include = %v; want flags added to the config", opts.find.Exclude, opts.find.Include)
+	}
+
+	opts, err = parseReposArgs([]string{"-depth", "1", "-n", "/work", "/oss"}, cfg)
+	if err != nil {
+		t.Fatalf("parseReposArgs: %v", err)
+	}
+	if len(opts.roots) != 2 || opts.find.MaxDepth != 1 || !opts.flags.dryRun {
+		t.Errorf("opts = %+v; want two roots, depth 1, dry run", opts)
+	}
+
+	if _, err := parseReposArgs(nil, config.ReposConfig{}); err == nil {
+		t.Error("want error without workspace roots")
+	}
+	if _, err := parseReposArgs([]string{"-include", "[a"}, cfg); err == nil {
+		t.Error("want error for a malformed glob")
+	}
+}
@@ -2,137 +2,481 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/jeff/claude-config-merge/internal/backup"
+	"github.com/jeff/claude-config-merge/internal/config"
+	"github.com/jeff/claude-config-merge/internal/jsonc"
 	"github.com/jeff/claude-config-merge/internal/merge"
+	"github.com/jeff/claude-config-merge/internal/snapshot"
 )
 
-// run performs the merge of masterPath into localPath, writing output to w.
-// When force is true, conflicting keys use the master value instead of keeping local.
-// Returns an error if any step fails.
-func run(masterPath, localPath string, force bool, w io.Writer) error {
-	masterData, err := loadJSON(masterPath)
+// reportSeparator divides the per-key blocks of the conflict and forced
+// sections of the merge report.
+const reportSeparator = "  ------------------------------------------------------------"
+
+// runOptions holds the per-command settings shared by run and dispatch.
+type runOptions struct {
+	force       bool                           // master wins on conflict
+	prune       bool                           // remove keys and files master dropped that the tool introduced
+	dryRun      bool                           // report only; write nothing
+	interactive bool                           // prompt for each settings conflict
+	color       bool                           // colorize diff output
+	arrays      map[string]merge.ArrayStrategy // array merge strategy per dotted key path
+	rules       map[string]merge.Policy        // merge policy per dotted key path or pattern
+	resolutions map[string]any                 // value chosen per conflicting key
+	in          io.Reader                      // answers for -i prompts; nil means os.Stdin
+	manifest    string                         // path of the agents/skills ownership manifest
+	include     []string                       // agents/skills patterns to sync; empty syncs all
+	exclude     []string                       // agents/skills patterns to leave out
+	verbose     bool                           // list ignored agents/skills files
+	session     *backup.Session                // backups of files this run modifies; nil starts one beside settings
+	project     bool                           // target a project's .claude: use project templates
+	createLocal bool                           // treat a missing local settings file as empty and create it
+	report      *report                        // structured results for -output json, or nil
+}
+
+// source is a file or directory in one layer of the master config and the
+// name of that layer.
+type source struct {
+	layer string
+	path  string
+}
+
+// projectTemplate is the directory in a master config directory that holds
+// the files for project .claude directories, laid out like the master's own
+// .claude directory.
+const projectTemplate = "project"
+
+// layerPaths returns the path name in the .claude directory of each of
+// layers, as sources in the same order. With project, a layer's project
+// template is used instead if it has one.
+func layerPaths(layers []config.Layer, project bool, name string) []source {
+	srcs := make([]source, 0, len(layers))
+	for _, l := range layers {
+		dir := filepath.Join(l.Dir, ".claude")
+		if tmpl := filepath.Join(l.Dir, projectTemplate, ".claude"); project && dirExists(tmpl) {
+			dir = tmpl
+		}
+		srcs = append(srcs, source{layer: l.Name, path: filepath.Join(dir, name)})
+	}
+	return srcs
+}
+
+// paths returns the path of each source.
+func paths(srcs []source) []string {
+	out := make([]string, 0, len(srcs))
+	for _, s := range srcs {
+		out = append(out, s.path)
+	}
+	return out
+}
+
+// layerOrigins records which layer of the master settings set each key.
+// A nil *layerOrigins, used when there is a single layer, knows no keys.
+type layerOrigins struct {
+	names []string       // layer names, lowest precedence first
+	keys  map[string]int // dotted key to index into names
+}
+
+// of returns the names of the layers that set key or keys nested below it,
+// lowest precedence first and separated by commas, or "" if none did.
+func (o *layerOrigins) of(key string) string {
+	if o == nil {
+		return ""
+	}
+	if i, ok := o.keys[key]; ok {
+		return o.names[i]
+	}
+	var idx []int
+	for k, i := range o.keys {
+		if strings.HasPrefix(k, key+".") && !slices.Contains(idx, i) {
+			idx = append(idx, i)
+		}
+	}
+	sort.Ints(idx)
+	names := make([]string, 0, len(idx))
+	for _, i := range idx {
+		names = append(names, o.names[i])
+	}
+	return strings.Join(names, ", ")
+}
+
+// note returns "  (from <layer>)" for key, or "" if no layer is known.
+func (o *layerOrigins) note(key string) string {
+	if from := o.of(key); from != "" {
+		return "  (from " + from + ")"
+	}
+	return ""
+}
+
+// settingsPlan is a computed settings merge that has not been written yet.
+type settingsPlan struct {
+	masterPath, localPath string   // masterPath is the highest layer's file
+	masterPaths           []string // every master file merged, lowest first
+	masterRaws            [][]byte
+	localRaw              []byte
+	master, local         map[string]any
+	origins               *layerOrigins // nil with a single layer
+	snapPath              string
+	snap                  *snapshot.Snapshot
+	result                merge.Result
+}
+
+// planSettings loads the master settings of every layer in masters, lowest
+// precedence first, localPath, and the recorded snapshot, and merges them
+// according to opts without writing anything. The layers are combined with
+// merge.Overlay before being merged into local. With several layers, a layer
+// without a settings file is skipped.
+func planSettings(masters []source, localPath string, opts runOptions) (*settingsPlan, error) {
+	p := &settingsPlan{localPath: localPath}
+
+	var docs []map[string]any
+	var names []string
+	for _, m := range masters {
+		raw, doc, err := loadDocument(m.path)
+		if err != nil {
+			if len(masters) > 1 && errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to load master settings: %w", err)
+		}
+		p.masterPath = m.path
+		p.masterPaths = append(p.masterPaths, m.path)
+		p.masterRaws = append(p.masterRaws, raw)
+		docs = append(docs, doc)
+		names = append(names, m.layer)
+	}
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("failed to load master settings: no layer has %s", filepath.Join(".claude", filepath.Base(localPath)))
+	}
+	var keys map[string]int
+	p.master, keys = merge.Overlay(docs, opts.arrays)
+	if len(masters) > 1 {
+		p.origins = &layerOrigins{names: names, keys: keys}
+	}
+
+	var err error
+	p.localRaw, p.local, err = loadDocument(localPath)
+	if opts.createLocal && errors.Is(err, os.ErrNotExist) {
+		p.localRaw, p.local, err = []byte("{}\n"), map[string]any{}, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to load master settings: %w", err)
+		return nil, fmt.Errorf("failed to load local settings (%s): %w", localPath, err)
 	}
 
-	localData, err := loadJSON(localPath)
+	p.snapPath = snapshot.Path(localPath)
+	p.snap, err = snapshot.Load(p.snapPath)
 	if err != nil {
-		return fmt.Errorf("failed to load local settings (%s): %w", localPath, err)
+		return nil, err
+	}
+	if p.snap == nil {
+		p.snap = &snapshot.Snapshot{}
+	}
+	introduced := make(map[string]bool, len(p.snap.Introduced))
+	for _, k := range p.snap.Introduced {
+		introduced[k] = true
 	}
 
-	result := merge.Merge(masterData, localData, force)
+	p.result = merge.Merge(p.master, p.local, merge.Options{
+		Force:           opts.force,
+		ArrayStrategies: opts.arrays,
+		Rules:           opts.rules,
+		Base:            p.snap.Master,
+		Prune:           opts.prune,
+		Introduced:      introduced,
+		Resolutions:     opts.resolutions,
+	})
+	return p, nil
+}
 
-	// Always print the full keys report first, then decide whether to write.
-	printMergeReport(&result, w)
-
-	if len(result.Added) == 0 && len(result.Forced) == 0 {
-		fmt.Fprintf(w, "Keys added: %d  |  Forced: %d  |  Conflicts: %d  |  Matching: %d  |  Local-only: %d\n",
-			len(result.Added), len(result.Forced), len(result.Conflicts), len(result.Matching), len(result.LocalOnly))
-		if len(result.Conflicts) > 0 {
-			fmt.Fprintf(w, "Settings: no keys added. %d conflict(s) kept local value (use -f to let master win).\n",
-				len(result.Conflicts))
-		} else {
-			fmt.Fprintf(w, "Settings: up to date, nothing to write.\n")
+// render returns the contents of the local file with the merge applied. The
+// local file is patched rather than re-marshalled, so untouched keys keep
+// their order, formatting, and comments.
+func (p *settingsPlan) render() ([]byte, error) {
+	out, err := jsonc.Patch(p.localRaw, p.result.Merged, p.masterRaws...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render merged settings: %w", err)
+	}
+	return out, nil
+}
+
+// run performs the merge of the master settings layers in masters into
+// localPath, writing output to w. When opts.force is true, conflicting keys
+// use the master value instead of keeping local; when opts.interactive is
+// true the user picks a value for each conflict first. Returns an error if any
+// step fails.
+func run(masters []source, localPath string, opts runOptions, w io.Writer) error {
+	_, err := mergeSettings(masters, localPath, opts, w)
+	return err
+}
+
+// mergeSettings is run, returning the merged settings as written to
+// localPath, or as they would be with opts.dryRun.
+func mergeSettings(masters []source, localPath string, opts runOptions, w io.Writer) (map[string]any, error) {
+	plan, err := planSettings(masters, localPath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.interactive && len(plan.result.Conflicts) > 0 {
+		if plan, err = resolveAndReplan(masters, localPath, plan, opts, w); err != nil {
+			return nil, err
 		}
+	}
+	result := plan.result
+
+	entry := opts.report.addSettings(plan, &result)
+
+	// Always print the full keys report first, then decide whether to write.
+	printMergeReport(&result, plan.origins, w)
+
+	if !result.Changed() {
+		return result.Merged, finishUnchanged(plan, &result, localPath, opts, w)
+	}
+
+	printKeyList(w, "Keys added:", result.Added, plan.origins)
+
+	if opts.dryRun {
+		fmt.Fprintf(w, "%s\n", formatCounts(&result))
+		fmt.Fprintf(w, "Dry run: would write merged settings to %s (no files changed).\n", localPath)
+		return result.Merged, nil
+	}
+
+	if err := writeSettings(plan, &result, localPath, opts, entry, w); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(w, "Done. %s\n", formatCounts(&result))
+	fmt.Fprintf(w, "Written to: %s\n", localPath)
+
+	return result.Merged, nil
+}
+
+// resolveAndReplan asks the user to resolve each conflict of plan and plans
+// the merge again with their answers.
+func resolveAndReplan(masters []source, localPath string, plan *settingsPlan, opts runOptions, w io.Writer) (*settingsPlan, error) {
+	in := opts.in
+	if in == nil {
+		in = os.Stdin
+	}
+	var err error
+	opts.resolutions, err = resolveConflicts(plan.result.Conflicts, in, w, editInEditor)
+	if err != nil {
+		return nil, err
+	}
+	return planSettings(masters, localPath, opts)
+}
+
+// finishUnchanged reports a merge that leaves localPath as it is and records
+// the snapshot, unless opts.dryRun is set.
+func finishUnchanged(plan *settingsPlan, result *merge.Result, localPath string, opts runOptions, w io.Writer) error {
+	fmt.Fprintf(w, "%s\n", formatCounts(result))
+	if len(result.Conflicts) > 0 {
+		fmt.Fprintf(w, "Settings: no keys added. %d conflict(s) kept local value (use -f to let master win).\n",
+			len(result.Conflicts))
+	} else {
+		fmt.Fprintf(w, "Settings: up to date, nothing to write.\n")
+	}
+	if opts.dryRun || !dirExists(filepath.Dir(localPath)) {
+		// Don't create a project's .claude just to hold the snapshot.
 		return nil
 	}
+	return recordSnapshot(opts.session, plan.snapPath, plan.snap, plan.master, result)
+}
 
-	out, err := json.MarshalIndent(result.Merged, "", "  ")
+// writeSettings replaces localPath with the merged settings of plan, after
+// backing it up and the snapshot to opts.session, or to a session of its own,
+// and then records the snapshot. The write is noted in entry.
+func writeSettings(plan *settingsPlan, result *merge.Result, localPath string, opts runOptions, entry *settingsReport, w io.Writer) error {
+	out, err := plan.render()
 	if err != nil {
-		return fmt.Errorf("failed to marshal merged settings: %w", err)
+		return err
 	}
 
 	dir := filepath.Dir(localPath)
-	tmp, err := os.CreateTemp(dir, ".settings-merge-*")
+	if opts.createLocal {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return fmt.Errorf("creating %s: %w", dir, err)
+		}
+	}
+	tmpName, err := writeTemp(dir, out)
 	if err != nil {
-		return fmt.Errorf("creating temp file: %w", err)
+		return err
 	}
-	tmpName := tmp.Name()
 	// ensure temp is cleaned up on any error path
 	defer func() {
 		if tmpName != "" {
 			_ = os.Remove(tmpName)
 		}
 	}()
-	if _, err := tmp.Write(append(out, '\n')); err != nil {
-		_ = tmp.Close()
-		return fmt.Errorf("writing temp file: %w", err)
+
+	// Backup is created only after the temp file is fully written and ready to
+	// rename. The snapshot is saved with it so a rollback restores both.
+	session := opts.session
+	if session == nil {
+		session = backup.NewSession(dir, time.Now())
 	}
-	if err := tmp.Close(); err != nil {
-		return fmt.Errorf("closing temp file: %w", err)
+	backupPath, err := session.Save(localPath)
+	if err == nil {
+		_, err = session.Save(plan.snapPath)
 	}
-
-	// Backup is created only after the temp file is fully written and ready to rename.
-	backupPath, err := backup.Create(localPath)
 	if err != nil {
 		return fmt.Errorf("failed to create backup: %w", err)
 	}
-	fmt.Fprintf(w, "Backup created: %s\n", backupPath)
+	if backupPath != "" {
+		fmt.Fprintf(w, "Backup created: %s\n", backupPath)
+		entry.Backup = backupPath
+		opts.report.addBackup(backupPath)
+	}
 
 	if err := os.Rename(tmpName, localPath); err != nil {
 		return fmt.Errorf("failed to write merged settings: %w", err)
 	}
 	tmpName = "" // disarm the defer
+	entry.Written = true
 
-	if len(result.Added) > 0 {
-		fmt.Fprintf(w, "Keys added:\n")
-		for _, k := range result.Added {
-			fmt.Fprintf(w, "  %s\n", k)
-		}
-		fmt.Fprintf(w, "\n")
+	return recordSnapshot(session, plan.snapPath, plan.snap, plan.master, result)
+}
+
+// writeTemp writes data to a new temporary file in dir and returns its name.
+func writeTemp(dir string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(dir, ".settings-merge-*")
+	if err != nil {
+		return "", fmt.Errorf("creating temp file: %w", err)
 	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("writing temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("closing temp file: %w", err)
+	}
+	return tmp.Name(), nil
+}
 
-	fmt.Fprintf(w, "Done. Keys added: %d  |  Forced: %d  |  Conflicts: %d  |  Matching: %d  |  Local-only: %d\n", len(result.Added), len(result.Forced), len(result.Conflicts), len(result.Matching), len(result.LocalOnly))
-	fmt.Fprintf(w, "Written to: %s\n", localPath)
+// formatCounts returns the one-line summary of a merge result.
+func formatCounts(result *merge.Result) string {
+	return fmt.Sprintf("Keys added: %d  |  Updated: %d  |  Forced: %d  |  Removed: %d  |  Conflicts: %d  |  Matching: %d  |  Local-only: %d",
+		len(result.Added), len(result.Updated), len(result.Forced), len(result.Removed), len(result.Conflicts), len(result.Matching), len(result.LocalOnly))
+}
 
+// recordSnapshot saves master as the base for the next three-way merge and
+// updates the set of keys the tool introduced. Conflicting keys keep their
+// previous base, so they are reported again on the next run instead of being
+// mistaken for deliberate local edits. An unchanged snapshot is not
+// rewritten; otherwise it is first saved in session, if set, so a rollback
+// restores it.
+func recordSnapshot(session *backup.Session, path string, prev *snapshot.Snapshot, master map[string]any, result *merge.Result) error {
+	conflicts := make([]string, 0, len(result.Conflicts))
+	for _, c := range result.Conflicts {
+		conflicts = append(conflicts, c.Key)
+	}
+	next := snapshot.Snapshot{Master: snapshot.Rebase(master, prev.Master, conflicts)}
+
+	removed := make(map[string]bool, len(result.Removed))
+	for _, k := range result.Removed {
+		removed[k] = true
+	}
+	for _, k := range prev.Introduced {
+		if !removed[k] {
+			next.Introduced = append(next.Introduced, k)
+		}
+	}
+	next.Introduced = append(next.Introduced, result.Added...)
+	sort.Strings(next.Introduced)
+	next.Introduced = slices.Compact(next.Introduced)
+
+	if prev.Master != nil && reflect.DeepEqual(next.Master, prev.Master) && slices.Equal(next.Introduced, prev.Introduced) {
+		return nil
+	}
+	if session != nil {
+		if _, err := session.Save(path); err != nil {
+			return fmt.Errorf("failed to create backup: %w", err)
+		}
+	}
+	if err := snapshot.Save(path, &next); err != nil {
+		return fmt.Errorf("failed to record master snapshot: %w", err)
+	}
 	return nil
 }
 
-// printMergeReport writes the conflict, forced, matching, and local-only
-// sections of the merge report to w.
-func printMergeReport(result *merge.Result, w io.Writer) {
-	const sep = "  ------------------------------------------------------------"
-
+// printMergeReport writes the conflict, forced, removed, resolved, updated,
+// kept-local, array, rule, matching, and local-only sections of the merge
+// report to w. Forced and updated keys name the layer they came from, if
+// origins is set.
+func printMergeReport(result *merge.Result, origins *layerOrigins, w io.Writer) {
 	if len(result.Conflicts) > 0 {
 		fmt.Fprintf(w, "\nConflicts (local value kept):\n")
 		for _, c := range result.Conflicts {
-			fmt.Fprintf(w, "\n%s\n", sep)
+			fmt.Fprintf(w, "\n%s\n", reportSeparator)
 			fmt.Fprintf(w, "  %s\n", c.Key)
 			fmt.Fprintf(w, "    master: %s\n", formatValue(c.MasterValue))
 			fmt.Fprintf(w, "    local:  %s\n", formatValue(c.LocalValue))
 		}
-		fmt.Fprintf(w, "\n%s\n\n", sep)
+		fmt.Fprintf(w, "\n%s\n\n", reportSeparator)
 	}
 
 	if len(result.Forced) > 0 {
 		fmt.Fprintf(w, "\nForced overwrites (master value applied):\n")
 		for _, k := range result.Forced {
-			fmt.Fprintf(w, "\n%s\n", sep)
-			fmt.Fprintf(w, "  %s\n", k)
+			fmt.Fprintf(w, "\n%s\n", reportSeparator)
+			fmt.Fprintf(w, "  %s%s\n", k, origins.note(k))
 		}
-		fmt.Fprintf(w, "\n%s\n\n", sep)
+		fmt.Fprintf(w, "\n%s\n\n", reportSeparator)
 	}
 
-	if len(result.Matching) > 0 {
-		fmt.Fprintf(w, "Matching keys:\n")
-		for _, k := range result.Matching {
-			fmt.Fprintf(w, "  %s\n", k)
+	printKeyList(w, "Removed (dropped from master):", result.Removed, nil)
+	printKeyList(w, "Resolved interactively:", result.Resolved, nil)
+	printKeyList(w, "Updated from master (unchanged locally since last sync):", result.Updated, origins)
+	printKeyList(w, "Local values kept (changed or deleted only locally, a local-wins rule, or chosen with -i):", result.KeptLocal, nil)
+
+	if len(result.ArrayAdditions) > 0 {
+		fmt.Fprintf(w, "Array elements added:\n")
+		for _, a := range result.ArrayAdditions {
+			fmt.Fprintf(w, "  %s\n", a.Key)
+			for _, v := range a.Values {
+				fmt.Fprintf(w, "    + %s\n", formatValue(v))
+			}
 		}
 		fmt.Fprintf(w, "\n")
 	}
 
-	if len(result.LocalOnly) > 0 {
-		fmt.Fprintf(w, "Local-only keys (not in master):\n")
-		for _, k := range result.LocalOnly {
-			fmt.Fprintf(w, "  %s\n", k)
+	if len(result.Decisions) > 0 {
+		fmt.Fprintf(w, "Decided by rules:\n")
+		for _, d := range result.Decisions {
+			fmt.Fprintf(w, "  %s  (%s, rule %q)\n", d.Key, d.Policy, d.Pattern)
 		}
 		fmt.Fprintf(w, "\n")
 	}
+
+	printKeyList(w, "Duplicates removed (union strategy):", result.Deduplicated, nil)
+	printKeyList(w, "Matching keys:", result.Matching, nil)
+	printKeyList(w, "Local-only keys (not in master):", result.LocalOnly, nil)
+}
+
+// printKeyList writes heading and keys, one per line, to w, noting the layer
+// each key came from if origins is set. Nothing is written for no keys.
+func printKeyList(w io.Writer, heading string, keys []string, origins *layerOrigins) {
+	if len(keys) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s\n", heading)
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %s%s\n", k, origins.note(k))
+	}
+	fmt.Fprintf(w, "\n")
 }
 
 // formatValue returns a human-readable string for a conflict value.
@@ -156,19 +500,28 @@ func formatValue(v any) string {
 	}
 }
 
-// loadJSON reads a JSON file at path and unmarshals it into a map. It returns
-// an informative error that includes the underlying parse error and reminds the
-// caller that JSON does not support // or /* */ comments.
+// loadJSON reads a JSON file at path and unmarshals it into a map. The file
+// may contain // and /* */ comments and trailing commas (JSONC).
 func loadJSON(path string) (map[string]any, error) {
+	_, m, err := loadDocument(path)
+	return m, err
+}
+
+// loadDocument is like loadJSON but also returns the raw file contents, which
+// are needed to patch the file without disturbing its formatting.
+func loadDocument(path string) ([]byte, map[string]any, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("reading %s: %w", path, err)
+		return nil, nil, fmt.Errorf("reading %s: %w", path, err)
 	}
 
 	var m map[string]any
-	if err := json.Unmarshal(data, &m); err != nil {
-		return nil, fmt.Errorf("parsing %s: %w (note: if the file contains // or /* */ comments, remove them — they are not valid JSON)", path, err)
+	if err := jsonc.Unmarshal(data, &m); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if m == nil {
+		return nil, nil, fmt.Errorf("parsing %s: top-level value must be a JSON object", path)
 	}
 
-	return m, nil
+	return data, m, nil
 }
@@ -7,6 +7,10 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/jeff/claude-config-merge/internal/backup"
+	"github.com/jeff/claude-config-merge/internal/merge"
+	"github.com/jeff/claude-config-merge/internal/snapshot"
 )
 
 func writeJSON(t *testing.T, path string, v map[string]any) {
@@ -42,7 +46,7 @@ func TestRun_MergesNewKeys(t *testing.T) {
 	writeJSON(t, localPath, map[string]any{"fromLocal": "yes", "shared": "local"})
 
 	var buf bytes.Buffer
-	if err := run(masterPath, localPath, false, &buf); err != nil {
+	if err := run(single(masterPath), localPath, runOptions{}, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -68,7 +72,7 @@ func TestRun_ReportsConflictsInOutput(t *testing.T) {
 	writeJSON(t, localPath, map[string]any{"key": "local-value"})
 
 	var buf bytes.Buffer
-	if err := run(masterPath, localPath, false, &buf); err != nil {
+	if err := run(single(masterPath), localPath, runOptions{}, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -88,7 +92,7 @@ func TestRun_MatchingKeysSkipsWrite(t *testing.T) {
 	writeJSON(t, localPath, map[string]any{"key": "same"})
 
 	var buf bytes.Buffer
-	if err := run(masterPath, localPath, false, &buf); err != nil {
+	if err := run(single(masterPath), localPath, runOptions{}, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -101,7 +105,7 @@ func TestRun_MatchingKeysSkipsWrite(t *testing.T) {
 		t.Fatal(err)
 	}
 	for _, e := range entries {
-		if strings.HasSuffix(e.Name(), ".bak") {
+		if strings.HasSuffix(e.Name(), ".bak") || e.Name() == backup.DirName {
 			t.Errorf("expected no backup file, found %s", e.Name())
 		}
 	}
@@ -116,23 +120,20 @@ func TestRun_CreatesBackupFile(t *testing.T) {
 	writeJSON(t, localPath, map[string]any{})
 
 	var buf bytes.Buffer
-	if err := run(masterPath, localPath, false, &buf); err != nil {
+	if err := run(single(masterPath), localPath, runOptions{}, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	entries, err := os.ReadDir(dir)
+	sessions, err := backup.ListSessions(dir)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	var hasBackup bool
-	for _, e := range entries {
-		if strings.HasPrefix(e.Name(), "local.json.") && strings.HasSuffix(e.Name(), ".bak") {
-			hasBackup = true
-		}
+	if len(sessions) != 1 {
+		t.Fatalf("expected one backup session, got %v", sessions)
 	}
-	if !hasBackup {
-		t.Error("expected a backup file to be created")
+	got, err := os.ReadFile(filepath.Join(sessions[0].Path, "local.json"))
+	if err != nil || string(got) != "{}" {
+		t.Errorf("backup of local.json = %q, %v; want the pre-merge content", got, err)
 	}
 }
 
@@ -141,7 +142,7 @@ func TestRun_MissingMaster(t *testing.T) {
 	localPath := filepath.Join(dir, "local.json")
 	writeJSON(t, localPath, map[string]any{})
 
-	err := run("/nonexistent/master.json", localPath, false, &bytes.Buffer{})
+	err := run(single("/nonexistent/master.json"), localPath, runOptions{}, &bytes.Buffer{})
 	if err == nil {
 		t.Fatal("expected error for missing master, got nil")
 	}
@@ -152,7 +153,7 @@ func TestRun_MissingLocal(t *testing.T) {
 	masterPath := filepath.Join(dir, "master.json")
 	writeJSON(t, masterPath, map[string]any{})
 
-	err := run(masterPath, "/nonexistent/local.json", false, &bytes.Buffer{})
+	err := run(single(masterPath), "/nonexistent/local.json", runOptions{}, &bytes.Buffer{})
 	if err == nil {
 		t.Fatal("expected error for missing local, got nil")
 	}
@@ -189,7 +190,7 @@ func TestRun_WriteFailure(t *testing.T) {
 	}
 	t.Cleanup(func() { _ = os.Chmod(dir, 0o755) }) //nolint:gosec // restoring directory to normal permissions after test
 
-	err := run(masterPath, localPath, false, &bytes.Buffer{})
+	err := run(single(masterPath), localPath, runOptions{}, &bytes.Buffer{})
 	if err == nil {
 		t.Fatal("expected error when writing to read-only directory, got nil")
 	}
@@ -213,15 +214,15 @@ func TestRun_BackupFailure(t *testing.T) {
 	writeJSON(t, masterPath, map[string]any{"key": "value"})
 	writeJSON(t, localPath, map[string]any{})
 
-	// Make the local directory read-only so backup.Create fails when it
-	// tries to write the backup file there.
+	// Make the local directory read-only so the backup session cannot be
+	// created there.
 	if err := os.Chmod(localDir, 0o555); err != nil { //nolint:gosec // 0o555 intentional to test backup failure
 		t.Fatal(err)
 	}
 	t.Cleanup(func() { _ = os.Chmod(localDir, 0o755) }) //nolint:gosec // restore directory permissions after test
 
 	var buf bytes.Buffer
-	err := run(masterPath, localPath, false, &buf)
+	err := run(single(masterPath), localPath, runOptions{}, &buf)
 	if err == nil {
 		t.Fatal("expected error when backup directory is read-only, got nil")
 	}
@@ -236,7 +237,7 @@ func TestRun_ListsAddedKeys(t *testing.T) {
 	writeJSON(t, localPath, map[string]any{})
 
 	var buf bytes.Buffer
-	if err := run(masterPath, localPath, false, &buf); err != nil {
+	if err := run(single(masterPath), localPath, runOptions{}, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -261,7 +262,7 @@ func TestRun_ListsMatchingKeys(t *testing.T) {
 	writeJSON(t, localPath, map[string]any{"sharedKey": "same-value"})
 
 	var buf bytes.Buffer
-	if err := run(masterPath, localPath, false, &buf); err != nil {
+	if err := run(single(masterPath), localPath, runOptions{}, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -283,7 +284,7 @@ func TestRun_ConflictWithNestedObjectValue(t *testing.T) {
 	writeJSON(t, localPath, map[string]any{"key": map[string]any{"nested": "local-val"}})
 
 	var buf bytes.Buffer
-	if err := run(masterPath, localPath, false, &buf); err != nil {
+	if err := run(single(masterPath), localPath, runOptions{}, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -307,7 +308,7 @@ func TestRun_ConflictWithSliceValue(t *testing.T) {
 	}
 
 	var buf bytes.Buffer
-	if err := run(masterPath, localPath, false, &buf); err != nil {
+	if err := run(single(masterPath), localPath, runOptions{}, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -329,7 +330,7 @@ func TestRun_ListsLocalOnlyKeys(t *testing.T) {
 	writeJSON(t, localPath, map[string]any{"localOnlyKey": "local-value", "masterKey": "value"})
 
 	var buf bytes.Buffer
-	if err := run(masterPath, localPath, false, &buf); err != nil {
+	if err := run(single(masterPath), localPath, runOptions{}, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -352,7 +353,7 @@ func TestRun_ForceOnlyNoEmptyAddedSection(t *testing.T) {
 	writeJSON(t, localPath, map[string]any{"key": "local-value"})
 
 	var buf bytes.Buffer
-	if err := run(masterPath, localPath, true, &buf); err != nil {
+	if err := run(single(masterPath), localPath, runOptions{force: true}, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -377,7 +378,7 @@ func TestRun_ForceOverwritesConflict(t *testing.T) {
 	writeJSON(t, localPath, map[string]any{"key": "local-value"})
 
 	var buf bytes.Buffer
-	if err := run(masterPath, localPath, true, &buf); err != nil {
+	if err := run(single(masterPath), localPath, runOptions{force: true}, &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -391,3 +392,382 @@ func TestRun_ForceOverwritesConflict(t *testing.T) {
 		t.Errorf("expected 'Forced overwrites' in output, got:\n%s", output)
 	}
 }
+
+func TestRun_ArrayStrategyUnion(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+
+	if err := os.WriteFile(masterPath, []byte(`{"permissions":{"allow":["Bash(ls)","Bash(git status)"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(localPath, []byte(`{"permissions":{"allow":["Bash(make)","Bash(ls)"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := runOptions{arrays: map[string]merge.ArrayStrategy{"permissions.allow": merge.ArrayUnion}}
+	var buf bytes.Buffer
+	if err := run(single(masterPath), localPath, opts, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result := readJSON(t, localPath)
+	allow := result["permissions"].(map[string]any)["allow"].([]any)
+	want := []any{"Bash(make)", "Bash(ls)", "Bash(git status)"}
+	if len(allow) != len(want) {
+		t.Fatalf("permissions.allow = %v; want %v", allow, want)
+	}
+	for i := range want {
+		if allow[i] != want[i] {
+			t.Errorf("permissions.allow[%d] = %v; want %v", i, allow[i], want[i])
+		}
+	}
+
+	output := buf.String()
+	if !strings.Contains(output, "Array elements added:") {
+		t.Errorf("expected 'Array elements added:' section in output, got:\n%s", output)
+	}
+	if !strings.Contains(output, `+ "Bash(git status)"`) {
+		t.Errorf("expected added element listed in output, got:\n%s", output)
+	}
+}
+
+func TestRun_RulesDecideKeys(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+
+	writeJSON(t, masterPath, map[string]any{"model": "opus", "theme": "light", "editor": "vim"})
+	writeJSON(t, localPath, map[string]any{"model": "sonnet", "theme": "dark", "editor": "emacs"})
+
+	opts := runOptions{rules: map[string]merge.Policy{
+		"model": merge.PolicyMasterWins, "theme": merge.PolicyIgnore, "editor": merge.PolicyLocalWins,
+	}}
+	var buf bytes.Buffer
+	if err := run(single(masterPath), localPath, opts, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result := readJSON(t, localPath)
+	if result["model"] != "opus" {
+		t.Errorf("model = %v; want opus", result["model"])
+	}
+	if result["theme"] != "dark" {
+		t.Errorf("theme = %v; want dark", result["theme"])
+	}
+
+	output := buf.String()
+	for _, want := range []string{
+		"Decided by rules:", `model  (master-wins, rule "model")`, `theme  (ignore, rule "theme")`,
+		"Local values kept (changed or deleted only locally, a local-wins rule, or chosen with -i):\n  editor\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, "Conflicts (local value kept)") {
+		t.Errorf("expected no conflicts, got:\n%s", output)
+	}
+}
+
+func TestRun_ThreeWayUpdatesKeysChangedOnlyInMaster(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+
+	// First sync records the base snapshot.
+	writeJSON(t, masterPath, map[string]any{"model": "sonnet", "theme": "dark"})
+	writeJSON(t, localPath, map[string]any{})
+	if err := run(single(masterPath), localPath, runOptions{}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	// Master changes model; the user changes theme.
+	writeJSON(t, masterPath, map[string]any{"model": "opus", "theme": "dark"})
+	writeJSON(t, localPath, map[string]any{"model": "sonnet", "theme": "light"})
+
+	var buf bytes.Buffer
+	if err := run(single(masterPath), localPath, runOptions{}, &buf); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+
+	result := readJSON(t, localPath)
+	if result["model"] != "opus" {
+		t.Errorf("model = %v; want opus (changed only in master)", result["model"])
+	}
+	if result["theme"] != "light" {
+		t.Errorf("theme = %v; want light (changed only locally)", result["theme"])
+	}
+
+	output := buf.String()
+	if !strings.Contains(output, "Updated from master") {
+		t.Errorf("expected 'Updated from master' section in output, got:\n%s", output)
+	}
+	if !strings.Contains(output, "Local values kept") {
+		t.Errorf("expected 'Local values kept' section in output, got:\n%s", output)
+	}
+	if strings.Contains(output, "Conflicts (local value kept)") {
+		t.Errorf("expected no conflicts, got:\n%s", output)
+	}
+}
+
+func TestRun_SnapshotNotAdvancedWhileConflictsRemain(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+
+	writeJSON(t, masterPath, map[string]any{"model": "opus"})
+	writeJSON(t, localPath, map[string]any{"model": "sonnet"})
+
+	for i := range 2 {
+		var buf bytes.Buffer
+		if err := run(single(masterPath), localPath, runOptions{}, &buf); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		if !strings.Contains(buf.String(), "conflict(s) kept local value") {
+			t.Errorf("run %d: expected conflict to be reported, got:\n%s", i, buf.String())
+		}
+	}
+}
+
+func TestRun_SnapshotAdvancesKeysOutsideStandingConflict(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+
+	// The first sync adds "x" and leaves env.MODE in conflict.
+	writeJSON(t, masterPath, map[string]any{"env": map[string]any{"MODE": "a"}, "x": 1})
+	writeJSON(t, localPath, map[string]any{"env": map[string]any{"MODE": "b"}})
+	if err := run(single(masterPath), localPath, runOptions{}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	// Only master changes "x"; the conflict still stands.
+	writeJSON(t, masterPath, map[string]any{"env": map[string]any{"MODE": "a"}, "x": 2})
+
+	var buf bytes.Buffer
+	if err := run(single(masterPath), localPath, runOptions{}, &buf); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+
+	result := readJSON(t, localPath)
+	if result["x"] != float64(2) {
+		t.Errorf("x = %v; want 2 updated from master", result["x"])
+	}
+	if env, _ := result["env"].(map[string]any); env["MODE"] != "b" {
+		t.Errorf("env.MODE = %v; want b kept local", result["env"])
+	}
+	output := buf.String()
+	if !strings.Contains(output, "Updated from master") {
+		t.Errorf("expected x in 'Updated from master', got:\n%s", output)
+	}
+	if !strings.Contains(output, "Conflicts (local value kept)") || !strings.Contains(output, "env.MODE") {
+		t.Errorf("expected env.MODE still reported as a conflict, got:\n%s", output)
+	}
+}
+
+func TestRun_PruneRemovesKeysDroppedFromMaster(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+
+	// First sync introduces "legacy" from master; "mine" is the user's own.
+	writeJSON(t, masterPath, map[string]any{"legacy": "v", "keep": "k"})
+	writeJSON(t, localPath, map[string]any{"mine": "user"})
+	if err := run(single(masterPath), localPath, runOptions{}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	// Master drops "legacy"; "mine" was never in master.
+	writeJSON(t, masterPath, map[string]any{"keep": "k"})
+
+	var buf bytes.Buffer
+	if err := run(single(masterPath), localPath, runOptions{prune: true}, &buf); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+
+	result := readJSON(t, localPath)
+	if _, ok := result["legacy"]; ok {
+		t.Error("legacy still present; want removed")
+	}
+	if result["mine"] != "user" {
+		t.Errorf("mine = %v; want user (user keys are never removed)", result["mine"])
+	}
+	if !strings.Contains(buf.String(), "Removed (dropped from master):") {
+		t.Errorf("expected 'Removed' section in output, got:\n%s", buf.String())
+	}
+}
+
+func TestRun_WithoutPruneKeepsDroppedKeys(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+
+	writeJSON(t, masterPath, map[string]any{"legacy": "v"})
+	writeJSON(t, localPath, map[string]any{})
+	if err := run(single(masterPath), localPath, runOptions{}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	writeJSON(t, masterPath, map[string]any{})
+	if err := run(single(masterPath), localPath, runOptions{}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+
+	if readJSON(t, localPath)["legacy"] != "v" {
+		t.Error("legacy removed without -prune; want kept")
+	}
+}
+
+func TestLoadJSON_AllowsComments(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "settings.json")
+	data := []byte(`{
+  // team model
+  "model": "opus", /* pinned */
+  "permissions": {"allow": ["Bash(ls)",],},
+}`)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := loadJSON(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m["model"] != "opus" {
+		t.Errorf("model = %v; want opus", m["model"])
+	}
+}
+
+func TestRun_PreservesLocalOrderAndComments(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+
+	if err := os.WriteFile(masterPath, []byte(`{"model": "opus", "alwaysThinkingEnabled": true, "theme": "dark"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	local := "{\n  // personal preferences\n  \"theme\": \"light\",\n  \"editor\": \"vim\"\n}\n"
+	if err := os.WriteFile(localPath, []byte(local), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run(single(masterPath), localPath, runOptions{}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n  // personal preferences\n  \"theme\": \"light\",\n  \"editor\": \"vim\",\n  \"model\": \"opus\",\n  \"alwaysThinkingEnabled\": true\n}\n"
+	if string(got) != want {
+		t.Errorf("local file =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRun_NumbersRoundTripExactly(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+
+	master := `{"maxTokens": 12345678901234567890, "ratio": 1.50, "budget": 1e3, "new": 100000000}`
+	local := "{\n  \"maxTokens\": 12345678901234567890,\n  \"ratio\": 1.5,\n  \"budget\": 1000\n}\n"
+	if err := os.WriteFile(masterPath, []byte(master), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(localPath, []byte(local), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := run(single(masterPath), localPath, runOptions{}, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n  \"maxTokens\": 12345678901234567890,\n  \"ratio\": 1.5,\n  \"budget\": 1000,\n  \"new\": 100000000\n}\n"
+	if string(got) != want {
+		t.Errorf("local file =\n%s\nwant\n%s", got, want)
+	}
+	// 1.50 vs 1.5 and 1e3 vs 1000 are the same number, not conflicts.
+	if strings.Contains(buf.String(), "Conflicts (local value kept)") {
+		t.Errorf("expected no conflicts for numerically equal values, got:\n%s", buf.String())
+	}
+}
+
+func TestFormatValue_KeepsNumberText(t *testing.T) {
+	if got := formatValue(json.Number("12345678901234567890")); got != "12345678901234567890" {
+		t.Errorf("formatValue = %s; want 12345678901234567890", got)
+	}
+}
+
+func TestRun_DryRunWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+
+	writeJSON(t, masterPath, map[string]any{"newKey": "value", "key": "master"})
+	writeJSON(t, localPath, map[string]any{"key": "local"})
+	before, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := run(single(masterPath), localPath, runOptions{force: true, dryRun: true}, &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	after, err := os.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("local file changed during dry run:\n%s", after)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("dir entries = %v; want only master.json and local.json", names)
+	}
+
+	output := buf.String()
+	if !strings.Contains(output, "Forced overwrites") {
+		t.Errorf("expected full report in dry-run output, got:\n%s", output)
+	}
+	if !strings.Contains(output, "Keys added:\n  newKey\n") {
+		t.Errorf("expected the keys that would be added in dry-run output, got:\n%s", output)
+	}
+	if !strings.Contains(output, "Dry run: would write") {
+		t.Errorf("expected dry-run notice in output, got:\n%s", output)
+	}
+}
+
+func TestRun_DryRunUpToDateRecordsNoSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	masterPath := filepath.Join(dir, "master.json")
+	localPath := filepath.Join(dir, "local.json")
+
+	writeJSON(t, masterPath, map[string]any{"key": "same"})
+	writeJSON(t, localPath, map[string]any{"key": "same"})
+
+	if err := run(single(masterPath), localPath, runOptions{dryRun: true}, &bytes.Buffer{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(snapshot.Path(localPath)); !os.IsNotExist(err) {
+		t.Errorf("snapshot written during dry run (stat err = %v)", err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/jeff/claude-config-merge/internal/dirsync"
 )
@@ -14,59 +16,128 @@ func dirExists(path string) bool {
 	return err == nil && info.IsDir()
 }
 
-// runSync syncs files from srcDir to dstDir, printing a report to w.
+// runSync syncs files from the directories in srcs, one per config layer
+// and lowest precedence first, to dstDir, printing a report to w. A file in
+// more than one layer is taken from the last; with several layers, copied,
+// updated, and forced files name the layer they came from.
 // label is the human-readable name used in output (e.g., "Agents").
-// If srcDir does not exist, a short notice is printed and nil is returned.
+// If no source directory exists, a short notice is printed and nil is returned.
 // If dstDir is a symlink it is skipped with a warning — the tool will not
 // follow or overwrite a symlink that may be managed by another process.
-func runSync(srcDir, dstDir string, force bool, label string, w io.Writer) error {
+// Ignored files are listed only with opts.verbose.
+// With opts.prune, files previously synced from srcDir that are gone from it
+// are removed. With opts.dryRun the report is printed but nothing is written.
+// Every file changed or removed is first saved to opts.session, if set.
+func runSync(srcs []source, dstDir string, opts runOptions, label string, w io.Writer) error {
+	entry := opts.report.addSync(label, srcs[len(srcs)-1].path, dstDir)
+	if len(srcs) > 1 {
+		entry.Layers = paths(srcs)
+	}
+
 	if info, err := os.Lstat(dstDir); err == nil && info.Mode()&os.ModeSymlink != 0 {
 		fmt.Fprintf(w, "%s: destination %s is a symbolic link — skipping.\n", label, dstDir)
 		fmt.Fprintf(w, "  If this symlink was created by mistake, remove it first: rm %q\n", dstDir)
+		entry.SymlinkSkipped = true
 		return nil
 	}
 
-	res, err := dirsync.Sync(srcDir, dstDir, force)
+	res, err := dirsync.SyncLayers(paths(srcs), dstDir, syncOptions(opts))
 	if err != nil {
 		return fmt.Errorf("%s: %w", label, err)
 	}
+	entry.setResult(&res)
+	origins := syncOrigins(srcs, &res)
+	entry.Origins = origins
 
-	total := len(res.Copied) + len(res.Skipped) + len(res.Forced)
+	total := len(res.Copied) + len(res.Skipped) + len(res.Forced) + len(res.Removed) +
+		len(res.Updated) + len(res.Modified) + len(res.Unchanged) + len(res.Ignored)
 
 	// Distinguish between "src did not exist" and "src existed but was empty".
 	// dirsync.Sync returns an empty result for both cases, so we check directly.
 	if total == 0 {
 		// Re-stat to tell the two zero cases apart.
-		if !dirExists(srcDir) {
-			fmt.Fprintf(w, "%s: source directory not found, skipping (%s)\n", label, srcDir)
+		if !slices.ContainsFunc(srcs, func(s source) bool { return dirExists(s.path) }) {
+			fmt.Fprintf(w, "%s: source directory not found, skipping (%s)\n", label, strings.Join(paths(srcs), ", "))
+			entry.SourceMissing = true
 			return nil
 		}
 		fmt.Fprintf(w, "%s: nothing to sync\n", label)
 		return nil
 	}
 
-	fmt.Fprintf(w, "%s: copied %d, skipped %d, forced %d\n", label, len(res.Copied), len(res.Skipped), len(res.Forced))
+	prefix := ""
+	if opts.dryRun {
+		prefix = "[dry run] "
+	}
+	fmt.Fprintf(w, "%s%s: copied %d, updated %d, unchanged %d, skipped %d, locally edited %d, forced %d, removed %d\n", prefix, label,
+		len(res.Copied), len(res.Updated), len(res.Unchanged), len(res.Skipped), len(res.Modified), len(res.Forced), len(res.Removed))
 
-	if len(res.Copied) > 0 {
-		fmt.Fprintf(w, "  Copied:\n")
-		for _, name := range res.Copied {
-			fmt.Fprintf(w, "    %s\n", name)
-		}
+	printSyncList(w, "Copied", res.Copied, origins)
+	printSyncList(w, "Updated (unchanged locally since last sync)", res.Updated, origins)
+	printSyncList(w, "Skipped (differs from source; use -f to overwrite)", res.Skipped, nil)
+	printSyncList(w, "Warning: edited locally since last sync, kept (use -f to overwrite)", res.Modified, nil)
+	printSyncList(w, "Forced", res.Forced, origins)
+	printSyncList(w, "Removed (no longer in source)", res.Removed, nil)
+	if opts.verbose {
+		printSyncList(w, "Ignored", res.Ignored, nil)
 	}
+	return nil
+}
 
-	if len(res.Skipped) > 0 {
-		fmt.Fprintf(w, "  Skipped (use -f to overwrite):\n")
-		for _, name := range res.Skipped {
-			fmt.Fprintf(w, "    %s\n", name)
+// syncOptions returns the dirsync options for opts. Every file the sync
+// changes or removes is first saved to opts.session, if set.
+func syncOptions(opts runOptions) dirsync.Options {
+	syncOpts := dirsync.Options{
+		Force:    opts.force,
+		DryRun:   opts.dryRun,
+		Manifest: opts.manifest,
+		Prune:    opts.prune,
+		Include:  opts.include,
+		Exclude:  opts.exclude,
+	}
+	if opts.session != nil {
+		syncOpts.BeforeWrite = func(path string) error {
+			saved, err := opts.session.Save(path)
+			if saved != "" {
+				opts.report.addBackup(saved)
+			}
+			return err
 		}
 	}
+	return syncOpts
+}
 
-	if len(res.Forced) > 0 {
-		fmt.Fprintf(w, "  Forced:\n")
-		for _, name := range res.Forced {
-			fmt.Fprintf(w, "    %s\n", name)
+// syncOrigins maps each copied, updated, or forced file of res to the name of
+// the layer it came from. It returns nil for a single layer.
+func syncOrigins(srcs []source, res *dirsync.Result) map[string]string {
+	if len(srcs) < 2 {
+		return nil
+	}
+	layerOf := make(map[string]string, len(srcs))
+	for _, src := range srcs {
+		layerOf[src.path] = src.layer
+	}
+	origins := map[string]string{}
+	for _, names := range [][]string{res.Copied, res.Updated, res.Forced} {
+		for _, name := range names {
+			origins[name] = layerOf[res.Sources[name]]
 		}
 	}
+	return origins
+}
 
-	return nil
+// printSyncList writes heading and then names to w, each with the layer it
+// came from if origins has one. Nothing is written without names.
+func printSyncList(w io.Writer, heading string, names []string, origins map[string]string) {
+	if len(names) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  %s:\n", heading)
+	for _, name := range names {
+		note := ""
+		if layer, ok := origins[name]; ok {
+			note = "  (from " + layer + ")"
+		}
+		fmt.Fprintf(w, "    %s%s\n", name, note)
+	}
 }
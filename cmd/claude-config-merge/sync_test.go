@@ -47,7 +47,7 @@ func TestRunSync_CopiesAndReports(t *testing.T) {
 	}
 
 	var buf bytes.Buffer
-	if err := runSync(src, dst, false, "Agents", &buf); err != nil {
+	if err := runSync(single(src), dst, runOptions{}, "Agents", &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -73,7 +73,7 @@ func TestRunSync_SkipsExistingAndReports(t *testing.T) {
 	src, dst := setupSyncDirs(t, "existing.md", "new content", "original content")
 
 	var buf bytes.Buffer
-	if err := runSync(src, dst, false, "Agents", &buf); err != nil {
+	if err := runSync(single(src), dst, runOptions{}, "Agents", &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -100,7 +100,7 @@ func TestRunSync_ForceOverwrites(t *testing.T) {
 	src, dst := setupSyncDirs(t, "file.md", "new content", "old content")
 
 	var buf bytes.Buffer
-	if err := runSync(src, dst, true, "Skills", &buf); err != nil {
+	if err := runSync(single(src), dst, runOptions{force: true}, "Skills", &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -126,7 +126,7 @@ func TestRunSync_SourceNotExist(t *testing.T) {
 	dst := filepath.Join(dir, "dst")
 
 	var buf bytes.Buffer
-	if err := runSync(src, dst, false, "Agents", &buf); err != nil {
+	if err := runSync(single(src), dst, runOptions{}, "Agents", &buf); err != nil {
 		t.Fatalf("expected nil error for missing src, got: %v", err)
 	}
 
@@ -150,7 +150,7 @@ func TestRunSync_NothingToSync(t *testing.T) {
 	}
 
 	var buf bytes.Buffer
-	if err := runSync(src, dst, false, "Skills", &buf); err != nil {
+	if err := runSync(single(src), dst, runOptions{}, "Skills", &buf); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
@@ -178,7 +178,7 @@ func TestRunSync_SymlinkDstSkips(t *testing.T) {
 	}
 
 	var buf bytes.Buffer
-	err := runSync(srcDir, dst, false, "Test", &buf)
+	err := runSync(single(srcDir), dst, runOptions{}, "Test", &buf)
 	if err != nil {
 		t.Fatalf("expected nil error for symlink dst, got: %v", err)
 	}
@@ -191,3 +191,139 @@ func TestRunSync_SymlinkDstSkips(t *testing.T) {
 		t.Errorf("expected 'rm' hint in output, got:\n%s", output)
 	}
 }
+
+func TestRunSync_DryRun(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := os.MkdirAll(src, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "agent.md"), []byte("a"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := runSync(single(src), dst, runOptions{dryRun: true}, "Agents", &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if dirExists(dst) {
+		t.Error("destination directory created during dry run")
+	}
+	if !strings.Contains(buf.String(), "[dry run] Agents: copied 1") {
+		t.Errorf("expected dry-run summary in output, got:\n%s", buf.String())
+	}
+}
+
+func TestRunSync_PruneRemovesFilesDroppedFromSource(t *testing.T) {
+	src, dst := setupSyncDirs(t, "old.md", "old", "")
+	opts := runOptions{manifest: filepath.Join(filepath.Dir(dst), "manifest.json")}
+
+	if err := runSync(single(src), dst, opts, "Agents", &bytes.Buffer{}); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	if err := os.Remove(filepath.Join(src, "old.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	opts.prune = true
+	var buf bytes.Buffer
+	if err := runSync(single(src), dst, opts, "Agents", &buf); err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "old.md")); !os.IsNotExist(err) {
+		t.Errorf("old.md still exists (stat err = %v)", err)
+	}
+	if !strings.Contains(buf.String(), "removed 1") || !strings.Contains(buf.String(), "Removed (no longer in source):\n    old.md") {
+		t.Errorf("expected removal reported, got:\n%s", buf.String())
+	}
+}
+
+func TestRunSync_UpdatesUntouchedAndWarnsOnEdited(t *testing.T) {
+	src, dst := setupSyncDirs(t, "a.md", "v1", "")
+	if err := os.WriteFile(filepath.Join(src, "b.md"), []byte("v1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts := runOptions{manifest: filepath.Join(filepath.Dir(dst), "manifest.json")}
+	if err := runSync(single(src), dst, opts, "Agents", &bytes.Buffer{}); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+
+	for _, name := range []string{"a.md", "b.md"} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte("v2"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dst, "b.md"), []byte("my edit"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := runSync(single(src), dst, opts, "Agents", &buf); err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+
+	output := buf.String()
+	if !strings.Contains(output, "Updated (unchanged locally since last sync):\n    a.md") {
+		t.Errorf("expected a.md listed as updated, got:\n%s", output)
+	}
+	if !strings.Contains(output, "kept (use -f to overwrite):\n    b.md") {
+		t.Errorf("expected warning for b.md, got:\n%s", output)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "b.md")); string(data) != "my edit" {
+		t.Errorf("b.md = %q; want local edit kept", data)
+	}
+}
+
+func TestRunSync_IdenticalFileReportedUnchanged(t *testing.T) {
+	src, dst := setupSyncDirs(t, "agent.md", "same", "same")
+
+	var buf bytes.Buffer
+	if err := runSync(single(src), dst, runOptions{force: true}, "Agents", &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "unchanged 1") || !strings.Contains(buf.String(), "forced 0") {
+		t.Errorf("expected identical file counted as unchanged, got:\n%s", buf.String())
+	}
+}
+
+func TestRunSync_VerboseListsIgnored(t *testing.T) {
+	src, dst := setupSyncDirs(t, "agent.md", "a", "")
+	if err := os.WriteFile(filepath.Join(src, "README.md"), []byte("docs"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opts := runOptions{exclude: []string{"README.md"}}
+
+	var quiet bytes.Buffer
+	if err := runSync(single(src), dst, opts, "Agents", &quiet); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(quiet.String(), "Ignored:") {
+		t.Errorf("expected no ignored list without -v, got:\n%s", quiet.String())
+	}
+	if _, err := os.Stat(filepath.Join(dst, "README.md")); !os.IsNotExist(err) {
+		t.Errorf("README.md was copied (stat err = %v)", err)
+	}
+
+	opts.verbose = true
+	var verbose bytes.Buffer
+	if err := runSync(single(src), dst, opts, "Agents", &verbose); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(verbose.String(), "Ignored:\n    README.md") {
+		t.Errorf("expected README.md listed as ignored with -v, got:\n%s", verbose.String())
+	}
+
+	// Files left out are listed even when nothing else is synced.
+	opts.exclude = []string{"*.md"}
+	verbose.Reset()
+	if err := runSync(single(src), t.TempDir(), opts, "Agents", &verbose); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(verbose.String(), "nothing to sync") || !strings.Contains(verbose.String(), "Ignored:\n    README.md\n    agent.md") {
+		t.Errorf("expected both files listed as ignored with -v, got:\n%s", verbose.String())
+	}
+}
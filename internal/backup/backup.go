@@ -1,43 +1,185 @@
-// Package backup creates timestamped copies of files before modification.
+// Package backup keeps copies of files from before a run modified them, so
+// the run can be rolled back.
 package backup
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// Create copies the file at path to a timestamped backup and returns the backup path.
-func Create(path string) (string, error) {
-	data, err := os.ReadFile(path)
+// timeLayout is the timestamp format embedded in backup file names.
+const timeLayout = "20060102T150405.000"
+
+// Backup describes one backup: a session directory, or a single
+// settings.json.<timestamp>.bak file as written by earlier versions.
+type Backup struct {
+	Path string    // full path of the session directory or backup file
+	ID   string    // the timestamp naming the backup
+	Time time.Time // when the backup was taken
+	Size int64     // size in bytes of the saved copies
+	// Entries lists the files recorded by a session; nil for a single file.
+	Entries []Entry
+}
+
+// List returns the single-file backups of path, newest first. The time
+// of each backup is read from its name, falling back to its modification time
+// for names that do not parse.
+func List(path string) ([]Backup, error) {
+	dir := filepath.Dir(path)
+	prefix := filepath.Base(path) + "."
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return "", fmt.Errorf("reading %s: %w", path, err)
+		return nil, fmt.Errorf("reading directory %s: %w", dir, err)
+	}
+
+	var backups []Backup
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".bak") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("stat %s: %w", name, err)
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".bak")
+		b := Backup{Path: filepath.Join(dir, name), ID: id, Time: info.ModTime(), Size: info.Size()}
+		for _, layout := range []string{timeLayout, "20060102T150405"} {
+			if t, err := time.ParseInLocation(layout, id, time.Local); err == nil {
+				b.Time = t
+				break
+			}
+		}
+		backups = append(backups, b)
 	}
 
-	backupPath := fmt.Sprintf("%s.%s.bak", path, time.Now().Format("20060102T150405.000"))
+	SortNewestFirst(backups)
+	return backups, nil
+}
 
-	dir := filepath.Dir(backupPath)
-	tmp, err := os.CreateTemp(dir, ".backup-*")
+// Select returns the backup selected by id from backups, which must be
+// sorted newest first: "latest" (or "") for the newest, otherwise the backup
+// whose ID is id or starts with it. A prefix must select exactly one backup.
+func Select(backups []Backup, id string) (Backup, error) {
+	if len(backups) == 0 {
+		return Backup{}, errors.New("no backups found")
+	}
+	if id == "" || id == "latest" {
+		return backups[0], nil
+	}
+
+	var matches []Backup
+	for _, b := range backups {
+		if b.ID == id {
+			return b, nil
+		}
+		if strings.HasPrefix(b.ID, id) {
+			matches = append(matches, b)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Backup{}, fmt.Errorf("no backup matches %q", id)
+	case 1:
+		return matches[0], nil
+	default:
+		return Backup{}, fmt.Errorf("%q matches %d backups; use a longer timestamp", id, len(matches))
+	}
+}
+
+// Restore atomically replaces path with the contents of the single-file
+// backup b. The current state of path is saved to undo first.
+func Restore(path string, b Backup, undo *Session) error {
+	data, err := os.ReadFile(b.Path)
 	if err != nil {
-		return "", fmt.Errorf("creating temp backup file: %w", err)
+		return fmt.Errorf("reading backup %s: %w", b.Path, err)
+	}
+	if _, err := undo.Save(path); err != nil {
+		return err
 	}
-	tmpName := tmp.Name()
-	defer func() {
-		if tmpName != "" {
-			_ = os.Remove(tmpName)
+	if err := writeFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("restoring %s: %w", path, err)
+	}
+	return nil
+}
+
+// Retention selects which backups to keep. A backup is kept if either limit
+// keeps it; the zero Retention keeps every backup.
+type Retention struct {
+	Keep   int           // keep the Keep newest backups; 0 sets no count limit
+	MaxAge time.Duration // keep backups younger than MaxAge; 0 sets no age limit
+}
+
+// IsZero reports whether r sets no limit.
+func (r Retention) IsZero() bool {
+	return r.Keep <= 0 && r.MaxAge <= 0
+}
+
+// String describes r, such as "newest 5 or younger than 30d".
+func (r Retention) String() string {
+	var parts []string
+	if r.Keep > 0 {
+		parts = append(parts, fmt.Sprintf("newest %d", r.Keep))
+	}
+	if r.MaxAge > 0 {
+		parts = append(parts, "younger than "+formatAge(r.MaxAge))
+	}
+	if len(parts) == 0 {
+		return "all"
+	}
+	return strings.Join(parts, " or ")
+}
+
+// Expired returns the backups r does not keep at now. backups must be sorted
+// newest first, as List returns them.
+func (r Retention) Expired(backups []Backup, now time.Time) []Backup {
+	if r.IsZero() {
+		return nil
+	}
+	var expired []Backup
+	for i, b := range backups {
+		if (r.Keep > 0 && i < r.Keep) || (r.MaxAge > 0 && now.Sub(b.Time) < r.MaxAge) {
+			continue
 		}
-	}()
-	if _, err := tmp.Write(data); err != nil {
-		_ = tmp.Close()
-		return "", fmt.Errorf("writing backup: %w", err)
+		expired = append(expired, b)
 	}
-	if err := tmp.Close(); err != nil {
-		return "", fmt.Errorf("closing temp backup: %w", err)
+	return expired
+}
+
+// ParseAge parses a backup age such as "30d", "2w", or "12h". Besides the
+// units of time.ParseDuration it accepts whole days (d) and weeks (w).
+func ParseAge(s string) (time.Duration, error) {
+	for unit, size := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, unit); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid age %q (want e.g. 30d, 2w, or 12h)", s)
+			}
+			return time.Duration(count) * size, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q (want e.g. 30d, 2w, or 12h)", s)
 	}
-	if err := os.Rename(tmpName, backupPath); err != nil {
-		return "", fmt.Errorf("finalising backup %s: %w", backupPath, err)
+	return d, nil
+}
+
+// formatAge renders d in whole weeks or days when it divides evenly, and as
+// a time.Duration otherwise.
+func formatAge(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d%(7*day) == 0:
+		return fmt.Sprintf("%dw", d/(7*day))
+	case d%day == 0:
+		return fmt.Sprintf("%dd", d/day)
+	default:
+		return d.String()
 	}
-	tmpName = "" // disarm defer
-	return backupPath, nil
 }
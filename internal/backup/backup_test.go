@@ -1,91 +1,151 @@
 package backup
 
 import (
-	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"slices"
 	"testing"
+	"time"
 )
 
-func TestCreate_WritesBackupFile(t *testing.T) {
+func TestList_NewestFirst(t *testing.T) {
 	dir := t.TempDir()
 	original := filepath.Join(dir, "settings.json")
-	content := []byte(`{"key": "value"}`)
-
-	if err := os.WriteFile(original, content, 0o600); err != nil {
-		t.Fatal(err)
+	for _, name := range []string{
+		"settings.json.20240101T120000.000.bak",
+		"settings.json.20240301T120000.000.bak",
+		"settings.json.20240201T120000.bak",
+		"other.json.20240401T120000.000.bak",
+		"settings.json",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0o600); err != nil {
+			t.Fatal(err)
+		}
 	}
 
-	backupPath, err := Create(original)
+	backups, err := List(original)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if !strings.HasPrefix(backupPath, original+".") || !strings.HasSuffix(backupPath, ".bak") {
-		t.Errorf("backupPath = %q; want format %q", backupPath, original+".TIMESTAMP.bak")
+	want := []string{"20240301T120000.000", "20240201T120000", "20240101T120000.000"}
+	if len(backups) != len(want) {
+		t.Fatalf("List = %v; want IDs %v", backups, want)
 	}
-
-	got, err := os.ReadFile(backupPath)
-	if err != nil {
-		t.Fatalf("reading backup: %v", err)
+	for i, id := range want {
+		if backups[i].ID != id {
+			t.Errorf("backups[%d].ID = %q; want %q", i, backups[i].ID, id)
+		}
+		if backups[i].Size != 2 {
+			t.Errorf("backups[%d].Size = %d; want 2", i, backups[i].Size)
+		}
 	}
+	if backups[0].Time.Month() != 3 {
+		t.Errorf("backups[0].Time = %v; want parsed from name", backups[0].Time)
+	}
+}
+
+func TestSelect_LatestAndPrefix(t *testing.T) {
+	backups := []Backup{{ID: "20240102T120000.000"}, {ID: "20240101T120000.000"}}
 
-	if !bytes.Equal(got, content) {
-		t.Errorf("backup content = %q; want %q", string(got), string(content))
+	if b, err := Select(backups, "latest"); err != nil || b.ID != "20240102T120000.000" {
+		t.Errorf("Select(latest) = %v, %v; want 20240102T120000.000", b.ID, err)
+	}
+	if b, err := Select(backups, "20240101"); err != nil || b.ID != "20240101T120000.000" {
+		t.Errorf("Select(20240101) = %v, %v; want 20240101T120000.000", b.ID, err)
+	}
+	if _, err := Select(backups, "2024"); err == nil {
+		t.Error("Select(2024) succeeded; want ambiguous prefix error")
+	}
+	if _, err := Select(backups, "1999"); err == nil {
+		t.Error("Select(1999) succeeded; want no match error")
+	}
+	if _, err := Select(nil, "latest"); err == nil {
+		t.Error("Select(nil) succeeded; want no backups error")
 	}
 }
 
-func TestCreate_PreservesOriginalFile(t *testing.T) {
+func TestRestore_BacksUpCurrentAndSwapsIn(t *testing.T) {
 	dir := t.TempDir()
 	original := filepath.Join(dir, "settings.json")
-	content := []byte(`{"key": "value"}`)
-
-	if err := os.WriteFile(original, content, 0o600); err != nil {
+	if err := os.WriteFile(original, []byte("current"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := Backup{Path: filepath.Join(dir, "settings.json.20240101T120000.000.bak")}
+	if err := os.WriteFile(old.Path, []byte("old"), 0o600); err != nil {
 		t.Fatal(err)
 	}
 
-	if _, err := Create(original); err != nil {
+	undo := NewSession(dir, time.Now())
+	if err := Restore(original, old, undo); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	got, err := os.ReadFile(original)
-	if err != nil {
-		t.Fatalf("reading original: %v", err)
+	if got, _ := os.ReadFile(original); string(got) != "old" {
+		t.Errorf("restored content = %q; want old", got)
 	}
-
-	if !bytes.Equal(got, content) {
-		t.Errorf("original content changed; got %q, want %q", string(got), string(content))
+	if got, _ := os.ReadFile(filepath.Join(undo.Dir(), "settings.json")); string(got) != "current" {
+		t.Errorf("backup of current = %q; want current", got)
 	}
-}
-
-func TestCreate_MissingFile(t *testing.T) {
-	_, err := Create("/nonexistent/settings.json")
-	if err == nil {
-		t.Fatal("expected error for missing file, got nil")
+	if got, _ := os.ReadFile(old.Path); string(got) != "old" {
+		t.Errorf("restored backup changed: %q", got)
 	}
 }
 
-func TestCreate_WriteFailure(t *testing.T) {
-	if os.Getuid() == 0 {
-		t.Skip("skipping permission test: running as root")
+func TestRetention_Expired(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	var backups []Backup
+	for i := range 5 {
+		// Newest first, one per week.
+		backups = append(backups, Backup{ID: fmt.Sprint(i), Time: now.Add(-time.Duration(i) * 7 * 24 * time.Hour)})
 	}
 
-	dir := t.TempDir()
-	original := filepath.Join(dir, "settings.json")
-
-	if err := os.WriteFile(original, []byte(`{"key": "value"}`), 0o600); err != nil {
-		t.Fatal(err)
+	tests := []struct {
+		name string
+		r    Retention
+		want []string
+	}{
+		{"zero keeps all", Retention{}, nil},
+		{"keep newest", Retention{Keep: 2}, []string{"2", "3", "4"}},
+		{"max age", Retention{MaxAge: 10 * 24 * time.Hour}, []string{"2", "3", "4"}},
+		{"either limit keeps", Retention{Keep: 3, MaxAge: 8 * 24 * time.Hour}, []string{"3", "4"}},
+		{"age keeps beyond count", Retention{Keep: 1, MaxAge: 15 * 24 * time.Hour}, []string{"3", "4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, b := range tt.r.Expired(backups, now) {
+				got = append(got, b.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Expired = %v; want %v", got, tt.want)
+			}
+		})
 	}
+}
 
-	// Make the directory read-only so os.WriteFile for the backup will fail.
-	if err := os.Chmod(dir, 0o555); err != nil { //nolint:gosec // 0o555 intentional to test write failure
-		t.Fatal(err)
+func TestParseAge(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"30d": 30 * 24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+		"12h": 12 * time.Hour,
+	} {
+		got, err := ParseAge(in)
+		if err != nil || got != want {
+			t.Errorf("ParseAge(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "d", "-1d", "soon", "-5h"} {
+		if _, err := ParseAge(in); err == nil {
+			t.Errorf("ParseAge(%q): want error", in)
+		}
 	}
-	t.Cleanup(func() { _ = os.Chmod(dir, 0o755) }) //nolint:gosec // restore directory permissions after test
+}
 
-	_, err := Create(original)
-	if err == nil {
-		t.Fatal("expected error when backup directory is read-only, got nil")
+func TestRetention_String(t *testing.T) {
+	r := Retention{Keep: 5, MaxAge: 30 * 24 * time.Hour}
+	if got := r.String(); got != "newest 5 or younger than 30d" {
+		t.Errorf("String = %q", got)
 	}
 }
@@ -0,0 +1,351 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DirName is the directory, inside the directory whose files a Session backs
+// up (normally ~/.claude), that holds one subdirectory per session.
+const DirName = ".backups"
+
+// indexName is the file in each session directory listing its entries.
+const indexName = ".session.json"
+
+// Entry is one file recorded in a session.
+type Entry struct {
+	// Path is the slash-separated path of the file relative to the session
+	// base. Its copy, if any, is at the same path in the session directory.
+	Path string `json:"path"`
+	// Existed is false if the file did not exist before the run, so rolling
+	// the session back removes it.
+	Existed bool `json:"existed"`
+	// Link is the target of the file if it was a symbolic link. Links are
+	// recorded here rather than copied.
+	Link string `json:"link,omitempty"`
+}
+
+// Session records the prior state of every file one run modifies under
+// <base>/.backups/<timestamp>/, at the same relative paths, so that the run
+// can be rolled back as one unit. The directory is created by the first Save.
+type Session struct {
+	base    string
+	dir     string
+	entries []Entry
+	seen    map[string]bool
+}
+
+// NewSession returns a session for files under base, named after now.
+func NewSession(base string, now time.Time) *Session {
+	return &Session{
+		base: base,
+		dir:  filepath.Join(base, DirName, now.Format(timeLayout)),
+		seen: map[string]bool{},
+	}
+}
+
+// Dir returns the session directory. It exists once Save has recorded a file.
+func (s *Session) Dir() string {
+	return s.dir
+}
+
+// Entries returns the files recorded so far, in the order they were saved.
+func (s *Session) Entries() []Entry {
+	return s.entries
+}
+
+// Save records the state of the file at path, which must lie under the
+// session base, before it is created, overwritten, or removed. An existing
+// file is copied into the session directory, and a symbolic link recorded by
+// its target; a missing one is noted so that rolling back removes it. Only the first Save of a path counts, so the
+// session keeps the state from before the run. Save returns the path of the
+// copy, or "" if it made none.
+func (s *Session) Save(path string) (string, error) {
+	rel, err := filepath.Rel(s.base, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("cannot back up %s: not under %s", path, s.base)
+	}
+	key := filepath.ToSlash(rel)
+	if s.seen[key] {
+		return "", nil
+	}
+
+	entry := Entry{Path: key}
+	copyPath := ""
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil && info.Mode().IsRegular():
+		entry.Existed = true
+		copyPath = filepath.Join(s.dir, rel)
+		if err := saveCopy(path, copyPath, info.Mode().Perm()); err != nil {
+			return "", err
+		}
+	case err == nil && info.Mode()&fs.ModeSymlink != 0:
+		entry.Existed = true
+		if entry.Link, err = os.Readlink(path); err != nil {
+			return "", fmt.Errorf("reading link %s: %w", path, err)
+		}
+	case err == nil:
+		return "", fmt.Errorf("cannot back up %s: not a regular file", path)
+	case !errors.Is(err, os.ErrNotExist):
+		return "", fmt.Errorf("stat %s: %w", path, err)
+	}
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return "", fmt.Errorf("creating backup directory: %w", err)
+	}
+
+	s.seen[key] = true
+	s.entries = append(s.entries, entry)
+	// Rewrite the index after every file, so a run that fails part way can
+	// still be rolled back.
+	if err := s.writeIndex(); err != nil {
+		return "", err
+	}
+	return copyPath, nil
+}
+
+// writeIndex writes the entries recorded so far to the session index.
+func (s *Session) writeIndex() error {
+	data, err := json.MarshalIndent(struct {
+		Files []Entry `json:"files"`
+	}{s.entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling backup index: %w", err)
+	}
+	if err := writeFile(filepath.Join(s.dir, indexName), append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("writing backup index: %w", err)
+	}
+	return nil
+}
+
+// saveCopy copies the file at path to copyPath, with permissions perm.
+func saveCopy(path, copyPath string, perm fs.FileMode) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(copyPath), 0o750); err != nil {
+		return fmt.Errorf("creating backup directory: %w", err)
+	}
+	if err := writeFile(copyPath, data, perm); err != nil {
+		return fmt.Errorf("writing backup of %s: %w", path, err)
+	}
+	return nil
+}
+
+// ListSessions returns the sessions under <base>/.backups, newest first. The
+// Path of each is its directory and Size the total size of its copies.
+func ListSessions(base string) ([]Backup, error) {
+	root := filepath.Join(base, DirName)
+	dirs, err := os.ReadDir(root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading directory %s: %w", root, err)
+	}
+
+	var sessions []Backup
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		b, err := readSession(filepath.Join(root, d.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, b)
+	}
+	SortNewestFirst(sessions)
+	return sessions, nil
+}
+
+// readSession returns the session stored in dir.
+func readSession(dir string) (Backup, error) {
+	id := filepath.Base(dir)
+	b := Backup{Path: dir, ID: id}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return b, fmt.Errorf("stat %s: %w", dir, err)
+	}
+	b.Time = info.ModTime()
+	if t, err := time.ParseInLocation(timeLayout, id, time.Local); err == nil {
+		b.Time = t
+	}
+
+	var index struct {
+		Files []Entry `json:"files"`
+	}
+	data, err := os.ReadFile(filepath.Join(dir, indexName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return b, fmt.Errorf("reading backup index: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &index); err != nil {
+			return b, fmt.Errorf("parsing backup index %s: %w", filepath.Join(dir, indexName), err)
+		}
+	}
+	b.Entries = index.Files
+	if b.Entries == nil {
+		b.Entries = []Entry{}
+	}
+
+	for _, e := range b.Entries {
+		if info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(e.Path))); err == nil && e.Existed && e.Link == "" {
+			b.Size += info.Size()
+		}
+	}
+	return b, nil
+}
+
+// Rollback restores the files recorded in session b under base: saved copies
+// and links replace the current files and files created by the session's run are
+// removed, along with directories left empty. The current state of every
+// file is saved to undo first, so the rollback can itself be rolled back.
+func Rollback(base string, b Backup, undo *Session) error {
+	return rollback(base, b.Path, b.Entries, undo)
+}
+
+// Rollback reverts every file recorded so far to its state before the run,
+// as Rollback does for a listed session. Once all are reverted the session
+// directory is removed, since it no longer holds anything to undo.
+func (s *Session) Rollback() error {
+	if err := rollback(s.base, s.dir, s.entries, nil); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.dir); err != nil {
+		return fmt.Errorf("removing backup directory %s: %w", s.dir, err)
+	}
+	s.entries = nil
+	s.seen = map[string]bool{}
+	return nil
+}
+
+// rollback restores entries, whose copies are in dir, under base. With a
+// non-nil undo the current state of each file is saved to it first.
+func rollback(base, dir string, entries []Entry, undo *Session) error {
+	for _, e := range entries {
+		path := filepath.Join(base, filepath.FromSlash(e.Path))
+		if undo != nil {
+			if _, err := undo.Save(path); err != nil {
+				return err
+			}
+		}
+
+		if !e.Existed {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("removing %s: %w", path, err)
+			}
+			removeEmptyParents(filepath.Dir(path), base)
+			continue
+		}
+		if e.Link != "" {
+			if err := restoreLink(path, e.Link); err != nil {
+				return err
+			}
+			continue
+		}
+
+		copyPath := filepath.Join(dir, filepath.FromSlash(e.Path))
+		info, err := os.Stat(copyPath)
+		if err != nil {
+			return fmt.Errorf("stat backup %s: %w", copyPath, err)
+		}
+		data, err := os.ReadFile(copyPath)
+		if err != nil {
+			return fmt.Errorf("reading backup %s: %w", copyPath, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			return fmt.Errorf("creating %s: %w", filepath.Dir(path), err)
+		}
+		if err := writeFile(path, data, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("restoring %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// restoreLink replaces the file at path with a symbolic link to target.
+func restoreLink(path, target string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("creating %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing %s: %w", path, err)
+	}
+	if err := os.Symlink(target, path); err != nil {
+		return fmt.Errorf("restoring link %s: %w", path, err)
+	}
+	return nil
+}
+
+// Prune deletes the backups, single files or session directories, that r
+// does not keep at now and returns the ones deleted. backups must be sorted
+// newest first. It stops at the first backup that cannot be removed.
+func Prune(backups []Backup, r Retention, now time.Time) ([]Backup, error) {
+	var deleted []Backup
+	for _, b := range r.Expired(backups, now) {
+		if err := os.RemoveAll(b.Path); err != nil {
+			return deleted, fmt.Errorf("removing backup %s: %w", b.Path, err)
+		}
+		deleted = append(deleted, b)
+	}
+	return deleted, nil
+}
+
+// removeEmptyParents removes dir and its parents up to, but not including,
+// stop, for as long as they are empty.
+func removeEmptyParents(dir, stop string) {
+	for ; dir != stop && strings.HasPrefix(dir, stop); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// SortNewestFirst sorts backups by time, newest first, breaking ties by ID.
+func SortNewestFirst(backups []Backup) {
+	sort.SliceStable(backups, func(i, j int) bool {
+		if !backups[i].Time.Equal(backups[j].Time) {
+			return backups[i].Time.After(backups[j].Time)
+		}
+		return backups[i].ID > backups[j].ID
+	})
+}
+
+// writeFile atomically writes data to path with permissions perm.
+func writeFile(path string, data []byte, perm fs.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".backup-*")
+	if err != nil {
+		return fmt.Errorf("creating temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		if tmpName != "" {
+			_ = os.Remove(tmpName)
+		}
+	}()
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("setting permissions on temp file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temp file: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("renaming temp file to %s: %w", path, err)
+	}
+	tmpName = "" // disarm defer
+	return nil
+}
@@ -0,0 +1,255 @@
+package backup
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSession_SaveCopiesFile(t *testing.T) {
+	base := t.TempDir()
+	original := filepath.Join(base, "agents", "a.md")
+	content := []byte("agent")
+	if err := os.MkdirAll(filepath.Dir(original), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(original, content, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSession(base, time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local))
+	copyPath, err := s.Save(original)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := filepath.Join(base, DirName, "20240102T030405.000", "agents", "a.md")
+	if copyPath != want {
+		t.Errorf("copy path = %q; want %q", copyPath, want)
+	}
+	if got, err := os.ReadFile(copyPath); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("copy = %q, %v; want %q", got, err, content)
+	}
+	if info, err := os.Stat(copyPath); err != nil || info.Mode().Perm() != 0o700 {
+		t.Errorf("copy mode = %v, %v; want 0700", info.Mode(), err)
+	}
+	if got, _ := os.ReadFile(original); !bytes.Equal(got, content) {
+		t.Errorf("original changed to %q", got)
+	}
+}
+
+func TestSession_SaveKeepsFirstState(t *testing.T) {
+	base := t.TempDir()
+	path := filepath.Join(base, "settings.json")
+	if err := os.WriteFile(path, []byte("before"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSession(base, time.Now())
+	first, err := s.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("after"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := s.Save(path); err != nil || again != "" {
+		t.Errorf("second Save = %q, %v; want no copy", again, err)
+	}
+	if got, _ := os.ReadFile(first); string(got) != "before" {
+		t.Errorf("backup = %q; want the state before the run", got)
+	}
+}
+
+func TestSession_SaveOutsideBase(t *testing.T) {
+	s := NewSession(t.TempDir(), time.Now())
+	if _, err := s.Save(filepath.Join(t.TempDir(), "x")); err == nil {
+		t.Fatal("expected error for a path outside the session base, got nil")
+	}
+}
+
+func TestSession_WriteFailure(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("skipping permission test: running as root")
+	}
+
+	base := t.TempDir()
+	original := filepath.Join(base, "settings.json")
+	if err := os.WriteFile(original, []byte(`{"key": "value"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make the base read-only so the session directory cannot be created.
+	if err := os.Chmod(base, 0o555); err != nil { //nolint:gosec // 0o555 intentional to test write failure
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(base, 0o755) }) //nolint:gosec // restore directory permissions after test
+
+	if _, err := NewSession(base, time.Now()).Save(original); err == nil {
+		t.Fatal("expected error when backup directory is read-only, got nil")
+	}
+}
+
+// writeFiles writes each file of files, keyed by path, creating its
+// directory if needed.
+func writeFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRollback_RestoresAndRemovesCreated(t *testing.T) {
+	base := t.TempDir()
+	settings := filepath.Join(base, "settings.json")
+	created := filepath.Join(base, "skills", "new", "SKILL.md")
+	if err := os.WriteFile(settings, []byte("before"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A run overwrites settings.json and creates a skill file.
+	run := NewSession(base, time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local))
+	for _, p := range []string{settings, created} {
+		if _, err := run.Save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFiles(t, map[string]string{settings: "after", created: "skill"})
+
+	sessions, err := ListSessions(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || len(sessions[0].Entries) != 2 || sessions[0].Size != int64(len("before")) {
+		t.Fatalf("ListSessions = %+v; want one session with two entries", sessions)
+	}
+
+	undo := NewSession(base, time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local))
+	if err := Rollback(base, sessions[0], undo); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, _ := os.ReadFile(settings); string(got) != "before" {
+		t.Errorf("settings.json = %q; want before", got)
+	}
+	if _, err := os.Stat(filepath.Join(base, "skills")); !os.IsNotExist(err) {
+		t.Errorf("created file and its directories should be gone, stat err = %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(undo.Dir(), "settings.json")); string(got) != "after" {
+		t.Errorf("undo backup of settings.json = %q; want after", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(undo.Dir(), "skills", "new", "SKILL.md")); string(got) != "skill" {
+		t.Errorf("undo backup of created file = %q; want skill", got)
+	}
+}
+
+func TestPrune_DeletesExpired(t *testing.T) {
+	base := t.TempDir()
+	path := filepath.Join(base, "settings.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, day := range []int{1, 2, 3} {
+		if _, err := NewSession(base, time.Date(2024, 1, day, 0, 0, 0, 0, time.Local)).Save(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sessions, err := ListSessions(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := Prune(sessions, Retention{Keep: 1}, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deleted) != 2 {
+		t.Fatalf("deleted %v; want the two older sessions", deleted)
+	}
+	if left, _ := ListSessions(base); len(left) != 1 || left[0].ID != "20240103T000000.000" {
+		t.Errorf("sessions left = %v; want only the newest", left)
+	}
+}
+
+func TestSession_RollbackRevertsAndRemovesSession(t *testing.T) {
+	base := t.TempDir()
+	settings := filepath.Join(base, "settings.json")
+	created := filepath.Join(base, "agents", "a.md")
+	if err := os.WriteFile(settings, []byte("before"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSession(base, time.Now())
+	for _, p := range []string{settings, created} {
+		if _, err := s.Save(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(settings, []byte("after"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(created), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(created, []byte("agent"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Rollback(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, _ := os.ReadFile(settings); string(got) != "before" {
+		t.Errorf("settings.json = %q; want before", got)
+	}
+	if _, err := os.Stat(filepath.Join(base, "agents")); !os.IsNotExist(err) {
+		t.Errorf("created file should be gone with its directory (stat err = %v)", err)
+	}
+	if _, err := os.Stat(s.Dir()); !os.IsNotExist(err) {
+		t.Errorf("session directory should be removed (stat err = %v)", err)
+	}
+	if len(s.Entries()) != 0 {
+		t.Errorf("Entries = %v; want none after rollback", s.Entries())
+	}
+}
+
+func TestSession_SaveRecordsLinkAndRollbackRestoresIt(t *testing.T) {
+	base := t.TempDir()
+	link := filepath.Join(base, "agents", "a.md")
+	if err := os.MkdirAll(filepath.Dir(link), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../shared/a.md", link); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSession(base, time.Now())
+	if copyPath, err := s.Save(link); err != nil || copyPath != "" {
+		t.Fatalf("Save = %q, %v; want no copy and no error", copyPath, err)
+	}
+	want := []Entry{{Path: "agents/a.md", Existed: true, Link: "../shared/a.md"}}
+	if got := s.Entries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Entries = %+v; want %+v", got, want)
+	}
+
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(link, []byte("agent"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rollback(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, err := os.Readlink(link); err != nil || got != "../shared/a.md" {
+		t.Errorf("agents/a.md links to %q, %v; want ../shared/a.md", got, err)
+	}
+}
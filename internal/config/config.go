@@ -2,18 +2,158 @@
 package config
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jeff/claude-config-merge/internal/backup"
+	"github.com/jeff/claude-config-merge/internal/dirsync"
+	"github.com/jeff/claude-config-merge/internal/jsonc"
+	"github.com/jeff/claude-config-merge/internal/merge"
+	"github.com/jeff/claude-config-merge/internal/repos"
 )
 
 // Config holds the tool's own configuration.
 type Config struct {
-	ConfigDir string `json:"configDir"`
+	// ConfigDir is the master config directory. Use Layers instead for more
+	// than one.
+	ConfigDir string `json:"configDir,omitempty"`
+
+	// Layers lists master config directories in order of increasing
+	// precedence, such as a company baseline, a team layer, and a personal
+	// layer. Settings are merged layer by layer and agents and skills are
+	// overlaid, so later layers win.
+	Layers []Layer `json:"layers,omitempty"`
+
+	// ArrayStrategies maps dotted settings key paths to the array merge
+	// strategy used for them (union, append, or replace).
+	ArrayStrategies map[string]merge.ArrayStrategy `json:"arrayStrategies,omitempty"`
+
+	// Rules maps dotted settings key paths or glob patterns to a merge
+	// policy (master-wins, local-wins, ignore, or an array strategy). Rules
+	// from RulesFileName in each layer are added for patterns not listed
+	// here, later layers taking precedence.
+	Rules map[string]merge.Policy `json:"rules,omitempty"`
+
+	// LocalSettings is the policy for merging settings.local.json. Its
+	// array strategies and rules are added to those above, overriding them
+	// for the same key; see LocalPolicy.
+	LocalSettings SettingsPolicy `json:"localSettings"`
+
+	// SyncInclude, when non-empty, limits the agents and skills sync to
+	// files matching one of these gitignore-style patterns, or lying in a
+	// directory that does.
+	SyncInclude []string `json:"syncInclude,omitempty"`
+	// SyncExclude lists gitignore-style patterns for agent and skill files
+	// to leave out, in addition to each source's .claudesyncignore.
+	SyncExclude []string `json:"syncExclude,omitempty"`
+
+	// BackupRetention limits the backups kept in the target .claude
+	// directory: the sessions under .backups and any older settings.json
+	// .bak files. It is applied after every run but a dry run, and by
+	// cleanup-bak.
+	BackupRetention RetentionConfig `json:"backupRetention"`
+
+	// Retention is BackupRetention parsed by Load.
+	Retention backup.Retention `json:"-"`
+
+	// Repos tells the repos command where to find the project repositories
+	// it applies the project template to.
+	Repos ReposConfig `json:"repos"`
+
+	// Profiles holds named alternatives, such as one per client, each with
+	// its own config directories and options. The fields above are shared by
+	// all profiles; see ProfileConfig for how one overrides them.
+	Profiles map[string]ProfileConfig `json:"profiles,omitempty"`
+	// DefaultProfile is the profile used when none is given on the command
+	// line.
+	DefaultProfile string `json:"defaultProfile,omitempty"`
+
+	// Profile is the name of the profile Load applied, or "" for none.
+	Profile string `json:"-"`
+	// Target is the project directory whose .claude directory commands
+	// apply to, as given with -target, or "" for the home directory.
+	Target string `json:"-"`
+}
+
+// ProfileConfig is one named profile. ConfigDir or Layers, if set, replace
+// the shared config directories, and SyncInclude, SyncExclude,
+// BackupRetention, and Repos, if set, replace the shared ones. ArrayStrategies and Rules
+// are added to the shared ones, overriding them for the same key.
+type ProfileConfig struct {
+	ConfigDir       string                         `json:"configDir,omitempty"`
+	Layers          []Layer                        `json:"layers,omitempty"`
+	ArrayStrategies map[string]merge.ArrayStrategy `json:"arrayStrategies,omitempty"`
+	Rules           map[string]merge.Policy        `json:"rules,omitempty"`
+	SyncInclude     []string                       `json:"syncInclude,omitempty"`
+	SyncExclude     []string                       `json:"syncExclude,omitempty"`
+	BackupRetention *RetentionConfig               `json:"backupRetention,omitempty"`
+	Repos           *ReposConfig                   `json:"repos,omitempty"`
+}
+
+// SettingsPolicy holds merge options for one settings file.
+type SettingsPolicy struct {
+	ArrayStrategies map[string]merge.ArrayStrategy `json:"arrayStrategies,omitempty"`
+	Rules           map[string]merge.Policy        `json:"rules,omitempty"`
+}
+
+// Layer is one master config directory, laid out like ~.
+type Layer struct {
+	// Name identifies the layer in reports. It defaults to the base name of
+	// Dir.
+	Name string `json:"name,omitempty"`
+	Dir  string `json:"dir"`
+}
+
+// Sources returns the master config directories in order of increasing
+// precedence: Layers, or ConfigDir as the only layer.
+func (c *Config) Sources() []Layer {
+	if len(c.Layers) > 0 {
+		return c.Layers
+	}
+	return []Layer{{Name: filepath.Base(c.ConfigDir), Dir: c.ConfigDir}}
+}
+
+// LocalPolicy returns the array strategies and rules for merging
+// settings.local.json: the shared ones with those of LocalSettings added.
+func (c *Config) LocalPolicy() (map[string]merge.ArrayStrategy, map[string]merge.Policy) {
+	return overlayMap(c.ArrayStrategies, c.LocalSettings.ArrayStrategies), overlayMap(c.Rules, c.LocalSettings.Rules)
+}
+
+// RetentionConfig is the backup retention policy as written in the config
+// file. A backup is kept if either limit keeps it; with neither set all
+// backups are kept.
+type RetentionConfig struct {
+	// Keep is the number of newest backups to keep.
+	Keep int `json:"keep,omitempty"`
+	// MaxAge keeps backups younger than this age, such as "30d", "2w", or
+	// "12h".
+	MaxAge string `json:"maxAge,omitempty"`
 }
 
+// ReposConfig lists the workspace roots searched for project repositories,
+// directories holding .claude or .git, and which of them to use.
+type ReposConfig struct {
+	// Roots are the directories to search. Paths given to the repos
+	// command replace them.
+	Roots []string `json:"roots,omitempty"`
+	// Include, when non-empty, limits the repositories to those matching
+	// one of these globs.
+	Include []string `json:"include,omitempty"`
+	// Exclude lists globs for directories not to search.
+	Exclude []string `json:"exclude,omitempty"`
+	// MaxDepth is how many levels below a root to search; 0 means
+	// repos.DefaultMaxDepth.
+	MaxDepth int `json:"maxDepth,omitempty"`
+}
+
+// RulesFileName is the optional file in ConfigDir holding shared merge rules
+// as a JSON object mapping patterns to policies.
+const RulesFileName = ".claude-config-merge-rules.json"
+
 // DefaultPath returns the default config file location, or "" if the home
 // directory cannot be determined.
 func DefaultPath() string {
@@ -24,28 +164,292 @@ func DefaultPath() string {
 	return filepath.Join(home, ".claude-config-merge.json")
 }
 
-// Load reads and validates the config at path.
+// Load reads and validates the config at path, selecting its default
+// profile, if any. The file may contain comments and trailing commas.
 func Load(path string) (*Config, error) {
+	return LoadProfile(path, "")
+}
+
+// LoadProfile reads and validates the config at path with the named profile
+// applied, or with DefaultProfile if profile is "". Every profile is
+// validated, but only the directories of the one applied must exist. The
+// returned config's Profile names it.
+func LoadProfile(path, profile string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("reading config %s: %w", path, err)
 	}
 
 	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
+	if err := jsonc.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("parsing config %s: %w", path, err)
 	}
 
-	if cfg.ConfigDir == "" {
-		return nil, fmt.Errorf("configDir is required in %s", path)
+	names, err := cfg.validateProfiles(path)
+	if err != nil {
+		return nil, err
 	}
 
-	if _, err := os.Stat(cfg.ConfigDir); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, fmt.Errorf("configDir %q does not exist (check %s)", cfg.ConfigDir, path)
+	if profile == "" {
+		profile = cfg.DefaultProfile
+	}
+	if profile != "" {
+		if _, ok := cfg.Profiles[profile]; !ok {
+			if len(names) == 0 {
+				return nil, fmt.Errorf("profile %q not found: %s defines no profiles", profile, path)
+			}
+			return nil, fmt.Errorf("profile %q not found in %s (profiles: %s)", profile, path, strings.Join(names, ", "))
 		}
-		return nil, fmt.Errorf("checking configDir %q: %w", cfg.ConfigDir, err)
+		cfg = cfg.withProfile(profile)
+		path = fmt.Sprintf("profile %q in %s", profile, path)
+	} else if len(names) > 0 && cfg.ConfigDir == "" && len(cfg.Layers) == 0 {
+		return nil, fmt.Errorf("no profile selected in %s: use -profile or set defaultProfile (profiles: %s)", path, strings.Join(names, ", "))
 	}
 
+	if err := cfg.validate(path); err != nil {
+		return nil, err
+	}
+	if err := cfg.loadSources(path); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
+
+// validateProfiles validates c with each of its profiles applied and returns
+// the profile names, sorted. path names the config in errors.
+func (c *Config) validateProfiles(path string) ([]string, error) {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("profiles in %s: empty profile name", path)
+		}
+		p := c.withProfile(name)
+		if err := p.validate(fmt.Sprintf("profile %q in %s", name, path)); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// withProfile returns c with the profile name applied. Directories and sync
+// patterns set in the profile replace those of c; array strategies and rules
+// are added to c's, replacing them for the same key.
+func (c Config) withProfile(name string) Config {
+	p := c.Profiles[name]
+	c.Profile = name
+	if p.ConfigDir != "" || len(p.Layers) > 0 {
+		c.ConfigDir = p.ConfigDir
+		c.Layers = p.Layers
+	}
+	// Copy the layers, which validate fills in.
+	c.Layers = append([]Layer(nil), c.Layers...)
+	c.ArrayStrategies = overlayMap(c.ArrayStrategies, p.ArrayStrategies)
+	c.Rules = overlayMap(c.Rules, p.Rules)
+	if p.SyncInclude != nil {
+		c.SyncInclude = p.SyncInclude
+	}
+	if p.SyncExclude != nil {
+		c.SyncExclude = p.SyncExclude
+	}
+	if p.BackupRetention != nil {
+		c.BackupRetention = *p.BackupRetention
+	}
+	if p.Repos != nil {
+		c.Repos = *p.Repos
+	}
+	return c
+}
+
+// overlayMap returns a new map holding the entries of base and then those of
+// over, or nil if both are empty.
+func overlayMap[V any](base, over map[string]V) map[string]V {
+	if len(base) == 0 && len(over) == 0 {
+		return nil
+	}
+	m := make(map[string]V, len(base)+len(over))
+	for k, v := range base {
+		m[k] = v
+	}
+	for k, v := range over {
+		m[k] = v
+	}
+	return m
+}
+
+// validate checks c without touching the file system, fills in default
+// layer names, and parses BackupRetention into Retention. where names the
+// config in errors.
+func (c *Config) validate(where string) error {
+	if err := c.validateLayers(where); err != nil {
+		return err
+	}
+
+	for key, strategy := range c.ArrayStrategies {
+		if _, err := merge.ParseArrayStrategy(string(strategy)); err != nil {
+			return fmt.Errorf("arrayStrategies[%q] in %s: %w", key, where, err)
+		}
+	}
+	for key, strategy := range c.LocalSettings.ArrayStrategies {
+		if _, err := merge.ParseArrayStrategy(string(strategy)); err != nil {
+			return fmt.Errorf("localSettings.arrayStrategies[%q] in %s: %w", key, where, err)
+		}
+	}
+	if err := validateRules(c.LocalSettings.Rules, "localSettings of "+where); err != nil {
+		return err
+	}
+
+	for _, list := range []struct {
+		name     string
+		patterns []string
+	}{{"syncInclude", c.SyncInclude}, {"syncExclude", c.SyncExclude}} {
+		for _, p := range list.patterns {
+			if err := dirsync.ValidatePattern(p); err != nil {
+				return fmt.Errorf("%s in %s: %w", list.name, where, err)
+			}
+		}
+	}
+
+	if err := c.Repos.validate(where); err != nil {
+		return err
+	}
+	if err := c.parseRetention(where); err != nil {
+		return err
+	}
+	return validateRules(c.Rules, where)
+}
+
+// validateLayers checks that c has either a config directory or layers, each
+// with a directory, and names unnamed layers after their directory.
+func (c *Config) validateLayers(where string) error {
+	switch {
+	case c.ConfigDir == "" && len(c.Layers) == 0:
+		return fmt.Errorf("configDir or layers is required in %s", where)
+	case c.ConfigDir != "" && len(c.Layers) > 0:
+		return fmt.Errorf("configDir and layers are mutually exclusive in %s", where)
+	}
+	names := make(map[string]bool, len(c.Layers))
+	for i := range c.Layers {
+		l := &c.Layers[i]
+		if l.Dir == "" {
+			return fmt.Errorf("layers[%d].dir is required in %s", i, where)
+		}
+		if l.Name == "" {
+			l.Name = filepath.Base(l.Dir)
+		}
+		if names[l.Name] {
+			return fmt.Errorf("layers[%d] in %s: duplicate layer name %q", i, where, l.Name)
+		}
+		names[l.Name] = true
+	}
+	return nil
+}
+
+// validate checks the globs, roots, and depth of r.
+func (r ReposConfig) validate(where string) error {
+	for _, list := range []struct {
+		name     string
+		patterns []string
+	}{{"repos.include", r.Include}, {"repos.exclude", r.Exclude}} {
+		for _, p := range list.patterns {
+			if err := repos.ValidatePattern(p); err != nil {
+				return fmt.Errorf("%s in %s: %w", list.name, where, err)
+			}
+		}
+	}
+	for i, root := range r.Roots {
+		if root == "" {
+			return fmt.Errorf("repos.roots[%d] in %s: empty path", i, where)
+		}
+	}
+	if r.MaxDepth < 0 {
+		return fmt.Errorf("repos.maxDepth in %s: must not be negative", where)
+	}
+	return nil
+}
+
+// parseRetention checks BackupRetention and parses it into Retention.
+func (c *Config) parseRetention(where string) error {
+	if c.BackupRetention.Keep < 0 {
+		return fmt.Errorf("backupRetention.keep in %s: must not be negative", where)
+	}
+	c.Retention = backup.Retention{Keep: c.BackupRetention.Keep}
+	if c.BackupRetention.MaxAge != "" {
+		age, err := backup.ParseAge(c.BackupRetention.MaxAge)
+		if err != nil {
+			return fmt.Errorf("backupRetention.maxAge in %s: %w", where, err)
+		}
+		c.Retention.MaxAge = age
+	}
+	return nil
+}
+
+// loadSources checks that every config directory of c exists and adds the
+// shared rules each one holds. where names the config in errors.
+func (c *Config) loadSources(where string) error {
+	for _, l := range c.Sources() {
+		if _, err := os.Stat(l.Dir); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("config directory %q does not exist (check %s)", l.Dir, where)
+			}
+			return fmt.Errorf("checking config directory %q: %w", l.Dir, err)
+		}
+	}
+
+	// Walk the layers from the top, so each pattern keeps the policy of the
+	// highest layer that sets it.
+	sources := c.Sources()
+	for i := len(sources) - 1; i >= 0; i-- {
+		shared, err := loadRules(filepath.Join(sources[i].Dir, RulesFileName))
+		if err != nil {
+			return err
+		}
+		for pattern, policy := range shared {
+			if _, ok := c.Rules[pattern]; ok {
+				continue
+			}
+			if c.Rules == nil {
+				c.Rules = make(map[string]merge.Policy, len(shared))
+			}
+			c.Rules[pattern] = policy
+		}
+	}
+	return nil
+}
+
+// loadRules reads the rules file at path. A missing file yields no rules.
+func loadRules(path string) (map[string]merge.Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading rules %s: %w", path, err)
+	}
+
+	var rules map[string]merge.Policy
+	if err := jsonc.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing rules %s: %w", path, err)
+	}
+	if err := validateRules(rules, path); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// validateRules checks every pattern and policy in rules, naming path in
+// the error.
+func validateRules(rules map[string]merge.Policy, path string) error {
+	for pattern, policy := range rules {
+		if err := merge.ValidatePattern(pattern); err != nil {
+			return fmt.Errorf("rules in %s: %w", path, err)
+		}
+		if _, err := merge.ParsePolicy(string(policy)); err != nil {
+			return fmt.Errorf("rules[%q] in %s: %w", pattern, path, err)
+		}
+	}
+	return nil
+}
@@ -5,6 +5,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/jeff/claude-config-merge/internal/merge"
 )
 
 func TestLoad_ValidConfig(t *testing.T) {
@@ -94,3 +97,473 @@ func TestDefaultPath_ReturnsHomeRelativePath(t *testing.T) {
 		t.Errorf("DefaultPath base = %q; want .claude-config-merge.json", filepath.Base(path))
 	}
 }
+
+func TestLoad_ArrayStrategies(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	data, err := json.Marshal(map[string]any{
+		"configDir":       dir,
+		"arrayStrategies": map[string]string{"permissions.allow": "union"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ArrayStrategies["permissions.allow"] != merge.ArrayUnion {
+		t.Errorf("ArrayStrategies[permissions.allow] = %q; want %q", got.ArrayStrategies["permissions.allow"], merge.ArrayUnion)
+	}
+}
+
+func TestLoad_InvalidArrayStrategy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	data, err := json.Marshal(map[string]any{
+		"configDir":       dir,
+		"arrayStrategies": map[string]string{"permissions.allow": "shuffle"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected error for unknown array strategy, got nil")
+	}
+}
+
+func TestLoad_AllowsCommentsAndTrailingCommas(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	data := []byte("{\n  // where the team configs live\n  \"configDir\": " + quoteJSON(dir) + ",\n}\n")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ConfigDir != dir {
+		t.Errorf("ConfigDir = %q; want %q", got.ConfigDir, dir)
+	}
+}
+
+func quoteJSON(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func TestLoad_RulesMergedFromConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	data, err := json.Marshal(map[string]any{
+		"configDir": dir,
+		"rules":     map[string]string{"theme": "local-wins"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	shared := []byte(`{
+  // team rules
+  "model": "master-wins",
+  "theme": "ignore",
+  "permissions.*": "union",
+}`)
+	if err := os.WriteFile(filepath.Join(dir, RulesFileName), shared, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]merge.Policy{
+		"model":         merge.PolicyMasterWins,
+		"theme":         merge.PolicyLocalWins, // the tool config overrides the shared file
+		"permissions.*": "union",
+	}
+	if len(got.Rules) != len(want) {
+		t.Fatalf("Rules = %v; want %v", got.Rules, want)
+	}
+	for k, v := range want {
+		if got.Rules[k] != v {
+			t.Errorf("Rules[%q] = %q; want %q", k, got.Rules[k], v)
+		}
+	}
+}
+
+func TestLoad_InvalidRule(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	data, err := json.Marshal(map[string]any{"configDir": dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, RulesFileName), []byte(`{"model": "newest"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected error for unknown policy, got nil")
+	}
+}
+
+func TestLoad_SyncIncludeExclude(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	write := func(v map[string]any) {
+		t.Helper()
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(map[string]any{"configDir": dir, "syncInclude": []string{"*.md"}, "syncExclude": []string{"drafts/"}})
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.SyncInclude) != 1 || len(got.SyncExclude) != 1 {
+		t.Errorf("SyncInclude = %v, SyncExclude = %v; want one pattern each", got.SyncInclude, got.SyncExclude)
+	}
+
+	write(map[string]any{"configDir": dir, "syncExclude": []string{"[a"}})
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected error for malformed syncExclude pattern, got nil")
+	}
+}
+
+func TestLoad_BackupRetention(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	write := func(v map[string]any) {
+		t.Helper()
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(map[string]any{"configDir": dir, "backupRetention": map[string]any{"keep": 5, "maxAge": "30d"}})
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Retention.Keep != 5 || got.Retention.MaxAge != 30*24*time.Hour {
+		t.Errorf("Retention = %+v; want keep 5, max age 30d", got.Retention)
+	}
+
+	write(map[string]any{"configDir": dir, "backupRetention": map[string]any{"maxAge": "soon"}})
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected error for malformed backupRetention.maxAge, got nil")
+	}
+
+	write(map[string]any{"configDir": dir, "backupRetention": map[string]any{"keep": -1}})
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected error for negative backupRetention.keep, got nil")
+	}
+}
+
+func TestLoad_Repos(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	write := func(v map[string]any) {
+		t.Helper()
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(map[string]any{"configDir": dir, "repos": map[string]any{
+		"roots": []string{"/src"}, "include": []string{"svc-*"}, "exclude": []string{"archive"}, "maxDepth": 2,
+	}})
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.Repos.Roots) != 1 || len(got.Repos.Include) != 1 || len(got.Repos.Exclude) != 1 || got.Repos.MaxDepth != 2 {
+		t.Errorf("Repos = %+v; want one root, include, and exclude, max depth 2", got.Repos)
+	}
+
+	for _, bad := range []map[string]any{
+		{"include": []string{"[a"}},
+		{"roots": []string{""}},
+		{"maxDepth": -1},
+	} {
+		write(map[string]any{"configDir": dir, "repos": bad})
+		if _, err := Load(path); err == nil {
+			t.Errorf("expected error for repos %v, got nil", bad)
+		}
+	}
+}
+
+func TestLoad_LocalSettingsPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	write := func(v map[string]any) {
+		t.Helper()
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(map[string]any{
+		"configDir":       dir,
+		"arrayStrategies": map[string]string{"permissions.allow": "union"},
+		"rules":           map[string]string{"model": "master-wins", "theme": "ignore"},
+		"localSettings":   map[string]any{"rules": map[string]string{"model": "local-wins"}},
+	})
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	arrays, rules := got.LocalPolicy()
+	if arrays["permissions.allow"] != merge.ArrayUnion {
+		t.Errorf("local arrays = %v; want the shared union strategy", arrays)
+	}
+	if rules["model"] != merge.PolicyLocalWins || rules["theme"] != merge.PolicyIgnore {
+		t.Errorf("local rules = %v; want model local-wins and the shared theme rule", rules)
+	}
+	if got.Rules["model"] != merge.PolicyMasterWins {
+		t.Errorf("shared rules = %v; want model master-wins for settings.json", got.Rules)
+	}
+
+	for _, bad := range []map[string]any{
+		{"rules": map[string]string{"model": "sometimes"}},
+		{"arrayStrategies": map[string]string{"a": "zip"}},
+	} {
+		write(map[string]any{"configDir": dir, "localSettings": bad})
+		if _, err := Load(path); err == nil {
+			t.Errorf("expected error for localSettings %v, got nil", bad)
+		}
+	}
+}
+
+func TestLoad_Layers(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	company := filepath.Join(dir, "company")
+	team := filepath.Join(dir, "team-configs")
+	for _, d := range []string{company, team} {
+		if err := os.MkdirAll(d, 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(company, RulesFileName), []byte(`{"model": "master-wins", "theme": "ignore"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(team, RulesFileName), []byte(`{"theme": "local-wins"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(map[string]any{
+		"layers": []map[string]string{
+			{"name": "company", "dir": company},
+			{"dir": team},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Layer{{Name: "company", Dir: company}, {Name: "team-configs", Dir: team}}
+	sources := got.Sources()
+	if len(sources) != len(want) {
+		t.Fatalf("Sources() = %v; want %v", sources, want)
+	}
+	for i := range want {
+		if sources[i] != want[i] {
+			t.Errorf("Sources()[%d] = %v; want %v", i, sources[i], want[i])
+		}
+	}
+	if got.Rules["model"] != merge.PolicyMasterWins || got.Rules["theme"] != merge.PolicyLocalWins {
+		t.Errorf("Rules = %v; want model from company and theme from team", got.Rules)
+	}
+}
+
+func TestLoad_InvalidLayers(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	for name, cfg := range map[string]map[string]any{
+		"both":      {"configDir": dir, "layers": []map[string]string{{"dir": dir}}},
+		"no dir":    {"layers": []map[string]string{{"name": "team"}}},
+		"duplicate": {"layers": []map[string]string{{"name": "a", "dir": dir}, {"name": "a", "dir": dir}}},
+		"missing":   {"layers": []map[string]string{{"dir": filepath.Join(dir, "nope")}}},
+	} {
+		data, err := json.Marshal(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestSources_ConfigDir(t *testing.T) {
+	cfg := Config{ConfigDir: "/configs/company"}
+	got := cfg.Sources()
+	if len(got) != 1 || got[0] != (Layer{Name: "company", Dir: "/configs/company"}) {
+		t.Errorf("Sources() = %v; want the config dir as the only layer", got)
+	}
+}
+
+// writeProfiles writes a config with shared options and a work and a client
+// profile, whose directories are created under dir, and returns its path.
+func writeProfiles(t *testing.T, dir string, extra map[string]any) string {
+	t.Helper()
+	for _, d := range []string{"work", "client-a", "client-b"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := map[string]any{
+		"rules":       map[string]string{"model": "master-wins", "theme": "ignore"},
+		"syncExclude": []string{"*.tmp"},
+		"profiles": map[string]any{
+			"work": map[string]any{"configDir": filepath.Join(dir, "work")},
+			"client-x": map[string]any{
+				"layers": []map[string]string{
+					{"dir": filepath.Join(dir, "client-a")},
+					{"dir": filepath.Join(dir, "client-b")},
+				},
+				"rules":           map[string]string{"theme": "local-wins"},
+				"backupRetention": map[string]any{"keep": 3},
+			},
+		},
+	}
+	for k, v := range extra {
+		cfg[k] = v
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadProfile_AppliesProfile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeProfiles(t, dir, map[string]any{"defaultProfile": "work"})
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Profile != "work" || got.ConfigDir != filepath.Join(dir, "work") {
+		t.Errorf("Profile = %q, ConfigDir = %q; want the default work profile", got.Profile, got.ConfigDir)
+	}
+	if got.Rules["theme"] != merge.PolicyIgnore || len(got.SyncExclude) != 1 {
+		t.Errorf("Rules = %v, SyncExclude = %v; want the shared options", got.Rules, got.SyncExclude)
+	}
+
+	got, err = LoadProfile(path, "client-x")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Profile != "client-x" || got.ConfigDir != "" || len(got.Sources()) != 2 {
+		t.Errorf("Profile = %q, Sources() = %v; want the client layers", got.Profile, got.Sources())
+	}
+	if got.Rules["model"] != merge.PolicyMasterWins || got.Rules["theme"] != merge.PolicyLocalWins {
+		t.Errorf("Rules = %v; want shared model rule and the profile's theme rule", got.Rules)
+	}
+	if got.Retention.Keep != 3 {
+		t.Errorf("Retention = %v; want the profile's keep 3", got.Retention)
+	}
+}
+
+func TestLoadProfile_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := Load(writeProfiles(t, dir, nil)); err == nil {
+		t.Error("want error when no profile is selected and there is no shared configDir")
+	}
+	if _, err := LoadProfile(writeProfiles(t, dir, nil), "nope"); err == nil {
+		t.Error("want error for an unknown profile")
+	}
+	// An invalid profile fails even when another one is selected.
+	path := writeProfiles(t, dir, map[string]any{
+		"defaultProfile": "work",
+		"profiles": map[string]any{
+			"work":   map[string]any{"configDir": filepath.Join(dir, "work")},
+			"broken": map[string]any{"configDir": dir, "arrayStrategies": map[string]string{"a": "zip"}},
+		},
+	})
+	if _, err := Load(path); err == nil {
+		t.Error("want error for an invalid inactive profile")
+	}
+}
+
+func TestLoadProfile_InactiveDirectoryNeedNotExist(t *testing.T) {
+	dir := t.TempDir()
+	path := writeProfiles(t, dir, map[string]any{
+		"configDir": filepath.Join(dir, "work"),
+		"profiles": map[string]any{
+			"offline": map[string]any{"configDir": filepath.Join(dir, "unmounted")},
+		},
+	})
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Profile != "" || got.ConfigDir != filepath.Join(dir, "work") {
+		t.Errorf("Profile = %q, ConfigDir = %q; want the shared config", got.Profile, got.ConfigDir)
+	}
+	if _, err := LoadProfile(path, "offline"); err == nil {
+		t.Error("want error when the selected profile's directory is missing")
+	}
+}
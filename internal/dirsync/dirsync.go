@@ -5,114 +5,491 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 )
 
-// Result holds the outcome of a directory sync operation.
+// Result holds the outcome of a directory sync operation. Every list holds
+// slash-separated file paths relative to the source directory.
 type Result struct {
-	Copied  []string // entries copied (new)
-	Skipped []string // entries skipped (already exist, no force)
-	Forced  []string // entries overwritten because force=true
+	Copied    []string // files copied (new)
+	Skipped   []string // files skipped (exist with different content, no force)
+	Unchanged []string // files whose content already matches the source
+	Forced    []string // files overwritten because force=true
+	Removed   []string // files removed because their source is gone (Prune)
+	Ignored   []string // files and directories left out by ignore or include rules
+
+	// Updated lists files refreshed from a changed source without force,
+	// because the manifest shows they were not edited since the last sync.
+	Updated []string
+	// Modified lists files the tool copied earlier but the user has since
+	// edited. They are left in place (unless force) instead of being
+	// overwritten or pruned.
+	Modified []string
+
+	// Sources maps each file taken from a source, whether copied, updated,
+	// forced, unchanged, skipped, or edited locally, to that source's
+	// directory.
+	Sources map[string]string
+}
+
+// Options controls how Sync copies files.
+type Options struct {
+	// Force overwrites existing files in dst instead of skipping them.
+	Force bool
+	// DryRun computes the Result without creating, copying, overwriting, or
+	// removing anything in dst, and without updating the manifest.
+	DryRun bool
+	// Manifest is the path of the ownership manifest that records every file
+	// Sync copies with its hash. Existing files the manifest shows as
+	// unedited since their last sync are updated without Force. Empty
+	// disables tracking.
+	Manifest string
+	// Prune removes files under dst that the manifest records as copied from
+	// a source file that no longer exists, unless they were edited since.
+	// Files the manifest does not list are never removed. Prune has no effect
+	// without Manifest.
+	Prune bool
+	// Exclude lists gitignore-style patterns for source paths to leave out,
+	// applied after those in the source's IgnoreFileName.
+	Exclude []string
+	// Include, when non-empty, limits the sync to files matching at least one
+	// of these gitignore-style patterns, or lying in a directory that does.
+	Include []string
+	// BeforeWrite, if set, is called with the path of each file under dst,
+	// and of the manifest, just before Sync creates, overwrites, or removes
+	// it, so the caller can back it up. An error aborts the sync. It is not
+	// called with DryRun.
+	BeforeWrite func(path string) error
 }
 
-// Sync copies regular files and subdirectories from src to dst.
-// If force is false, existing entries in dst are skipped.
-// If force is true, existing entries in dst are overwritten.
+// Sync copies the regular files in the tree at src to the same relative paths
+// under dst, creating subdirectories as needed. Each file is handled on its
+// own and reported by its slash-separated path relative to src, so a file
+// added to an existing skill directory is copied like any other new file.
+// Existing files whose content already matches src are left as they are.
+// If opts.Force is false, other existing files in dst are skipped, except
+// that files the manifest shows as unedited since the last sync are updated.
+// If opts.Force is true, existing files in dst are overwritten.
 // src not existing is not an error — returns empty Result.
-// dst is created if it does not exist.
-func Sync(src, dst string, force bool) (Result, error) {
+// dst is created if it does not exist, unless opts.DryRun is set.
+// With opts.Manifest set, copied files are recorded in the manifest and, if
+// opts.Prune is also set, recorded files whose source is gone are removed.
+// Paths matched by IgnoreFileName in src or opts.Exclude, and files not
+// matched by a non-empty opts.Include, are left out and listed as Ignored.
+func Sync(src, dst string, opts Options) (Result, error) {
+	return SyncLayers([]string{src}, dst, opts)
+}
+
+// SyncLayers is like Sync but overlays several source trees, given in order
+// of increasing precedence, onto dst: a file present in more than one source
+// is taken from the last. Each source's IgnoreFileName applies to that source
+// only. Sources that do not exist are skipped, and Result.Sources tells which
+// source each file came from.
+func SyncLayers(srcs []string, dst string, opts Options) (Result, error) {
 	var res Result
 
-	entries, err := os.ReadDir(src)
+	present, err := existingSources(srcs)
+	if err != nil || len(present) == 0 {
+		return res, err
+	}
+
+	manifest, base, err := openManifest(opts)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return res, nil
-		}
-		return res, fmt.Errorf("reading source directory %s: %w", src, err)
+		return res, err
 	}
 
-	if err := os.MkdirAll(dst, 0o750); err != nil && !errors.Is(err, os.ErrExist) {
-		return res, fmt.Errorf("creating destination directory %s: %w", dst, err)
+	if !opts.DryRun {
+		if err := os.MkdirAll(dst, 0o750); err != nil && !errors.Is(err, os.ErrExist) {
+			return res, fmt.Errorf("creating destination directory %s: %w", dst, err)
+		}
 	}
 
-	for _, entry := range entries {
-		name := entry.Name()
-		srcPath := filepath.Join(src, name)
-		dstPath := filepath.Join(dst, name)
+	// Walk the sources from the top, so each file is taken from the highest
+	// source that has it and lower ones only fill the gaps.
+	res.Sources = map[string]string{}
+	s := &syncer{
+		dst:      dst,
+		opts:     opts,
+		manifest: manifest,
+		base:     base,
+		res:      &res,
+		include:  newMatcher(opts.Include),
+	}
+	for i := len(present) - 1; i >= 0; i-- {
+		if err := s.walk(present[i]); err != nil {
+			return res, err
+		}
+	}
 
-		// Use Lstat so broken/circular symlinks are treated as "exists"
-		// rather than causing an infinite-follow error.
-		_, statErr := os.Lstat(dstPath)
-		if statErr != nil && !errors.Is(statErr, os.ErrNotExist) {
-			return res, fmt.Errorf("stat %s: %w", dstPath, statErr)
+	if manifest != nil && opts.Prune {
+		if err := prune(manifest, base, dst, opts, &res); err != nil {
+			return res, err
 		}
-		exists := statErr == nil
+	}
 
-		if exists && !force {
-			res.Skipped = append(res.Skipped, name)
-			continue
+	sort.Strings(res.Copied)
+	sort.Strings(res.Unchanged)
+	sort.Strings(res.Forced)
+	sort.Strings(res.Removed)
+	sort.Strings(res.Updated)
+	sort.Strings(res.Modified)
+	// A path may be skipped or ignored in more than one source.
+	sort.Strings(res.Skipped)
+	res.Skipped = slices.Compact(res.Skipped)
+	sort.Strings(res.Ignored)
+	res.Ignored = slices.Compact(res.Ignored)
+
+	if manifest != nil && !opts.DryRun {
+		if err := saveManifest(manifest, opts); err != nil {
+			return res, err
 		}
+	}
 
-		switch {
-		case entry.Type().IsRegular():
-			if err := copyFile(srcPath, dstPath); err != nil {
-				return res, err
-			}
-		case entry.IsDir():
-			if err := copyDir(srcPath, dstPath); err != nil {
-				return res, err
+	return res, nil
+}
+
+// existingSources returns the directories in srcs that exist, in order.
+func existingSources(srcs []string) ([]string, error) {
+	var present []string
+	for _, src := range srcs {
+		if _, err := os.ReadDir(src); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
 			}
-		default:
-			// Skip symlinks and other special types.
-			continue
+			return nil, fmt.Errorf("reading source directory %s: %w", src, err)
+		}
+		present = append(present, src)
+	}
+	return present, nil
+}
+
+// openManifest loads the manifest at opts.Manifest and returns it with the
+// directory holding it, or nil if opts.Manifest is not set.
+func openManifest(opts Options) (manifest *Manifest, base string, err error) {
+	if opts.Manifest == "" {
+		return nil, "", nil
+	}
+	if manifest, err = LoadManifest(opts.Manifest); err != nil {
+		return nil, "", err
+	}
+	return manifest, filepath.Dir(opts.Manifest), nil
+}
+
+// saveManifest writes manifest to opts.Manifest, first passing the path to
+// opts.BeforeWrite if the manifest changed.
+func saveManifest(manifest *Manifest, opts Options) error {
+	if manifest.dirty {
+		if err := opts.beforeWrite(opts.Manifest); err != nil {
+			return err
 		}
+	}
+	return manifest.Save(opts.Manifest)
+}
+
+// syncer holds the state of one Sync call. src and exclude belong to the
+// source being walked.
+type syncer struct {
+	src, dst string
+	opts     Options
+	manifest *Manifest // nil unless opts.Manifest is set
+	base     string    // directory holding the manifest
+	res      *Result
+	exclude  *matcher // paths to leave out
+	include  *matcher // files to keep; empty keeps all
+}
+
+// walk syncs the tree at src, leaving out the paths its IgnoreFileName and
+// opts.Exclude match.
+func (s *syncer) walk(src string) error {
+	patterns, err := loadIgnoreFile(filepath.Join(src, IgnoreFileName))
+	if err != nil {
+		return err
+	}
+	s.src = src
+	s.exclude = newMatcher(append(patterns, s.opts.Exclude...))
+	return filepath.WalkDir(src, s.visit)
+}
 
-		if exists {
-			res.Forced = append(res.Forced, name)
-		} else {
-			res.Copied = append(res.Copied, name)
+// visit is the filepath.WalkDir callback that syncs one entry of the source
+// tree.
+func (s *syncer) visit(srcPath string, d fs.DirEntry, err error) error {
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", srcPath, err)
+	}
+	if srcPath == s.src {
+		return nil
+	}
+	rel, err := filepath.Rel(s.src, srcPath)
+	if err != nil {
+		return fmt.Errorf("relating %s to %s: %w", srcPath, s.src, err)
+	}
+	name := filepath.ToSlash(rel)
+	dstPath := filepath.Join(s.dst, rel)
+
+	if name == IgnoreFileName {
+		return nil
+	}
+	if _, taken := s.res.Sources[name]; taken && !d.IsDir() {
+		// A higher source already provides this file.
+		return nil
+	}
+	if s.ignore(name, d.IsDir()) {
+		if d.IsDir() {
+			return fs.SkipDir
 		}
+		return nil
 	}
 
-	sort.Strings(res.Copied)
-	sort.Strings(res.Skipped)
-	sort.Strings(res.Forced)
+	// Use Lstat so broken/circular symlinks are treated as "exists"
+	// rather than causing an infinite-follow error.
+	info, statErr := os.Lstat(dstPath)
+	if statErr != nil && !errors.Is(statErr, os.ErrNotExist) {
+		return fmt.Errorf("stat %s: %w", dstPath, statErr)
+	}
+	exists := statErr == nil
 
-	return res, nil
+	switch {
+	case d.IsDir():
+		// A file or symlink where the directory belongs is never replaced.
+		if exists && !info.IsDir() {
+			s.res.Skipped = append(s.res.Skipped, name)
+			return fs.SkipDir
+		}
+		return nil
+	case !d.Type().IsRegular():
+		// Skip symlinks and other special types.
+		return nil
+	}
+
+	s.res.Sources[name] = s.src
+	return s.syncFile(name, srcPath, dstPath, exists)
+}
+
+// ignore reports whether the exclude or include rules leave out the entry
+// name, listing it as Ignored if so. Include rules leave out files only, as
+// they match the files below a directory they name.
+func (s *syncer) ignore(name string, isDir bool) bool {
+	if s.exclude.match(name, isDir) ||
+		(!isDir && !s.include.empty() && !s.include.match(name, false)) {
+		s.res.Ignored = append(s.res.Ignored, name)
+		return true
+	}
+	return false
+}
+
+// syncFile syncs the regular file srcPath, named name, to dstPath, which
+// exists if exists is set, and lists it in the result.
+func (s *syncer) syncFile(name, srcPath, dstPath string, exists bool) error {
+	if exists {
+		same, err := sameFile(srcPath, dstPath)
+		if err != nil {
+			return err
+		}
+		if same {
+			s.res.Unchanged = append(s.res.Unchanged, name)
+			return s.claim(srcPath, dstPath)
+		}
+	}
+
+	update := false
+	if exists && !s.opts.Force {
+		ok, err := s.overwritable(name, srcPath, dstPath)
+		if err != nil || !ok {
+			return err
+		}
+		update = true
+	}
+
+	if err := s.copy(srcPath, dstPath); err != nil {
+		return err
+	}
+
+	switch {
+	case update:
+		s.res.Updated = append(s.res.Updated, name)
+	case exists:
+		s.res.Forced = append(s.res.Forced, name)
+	default:
+		s.res.Copied = append(s.res.Copied, name)
+	}
+	return nil
 }
 
-// copyDir recursively copies the directory tree at src to dst.
-func copyDir(src, dst string) error {
-	entries, err := os.ReadDir(src)
+// claim records in the manifest, if any, that dstPath holds the content of
+// srcPath. Identical content is as good as a fresh copy: the file is claimed,
+// or its hash and source refreshed, unless already recorded as is.
+func (s *syncer) claim(srcPath, dstPath string) error {
+	if s.manifest == nil {
+		return nil
+	}
+	sum, err := hashFile(srcPath)
 	if err != nil {
-		return fmt.Errorf("reading %s: %w", src, err)
+		return err
+	}
+	if s.manifest.recorded(s.base, srcPath, dstPath, sum) {
+		return nil
 	}
+	return s.manifest.record(s.base, srcPath, dstPath)
+}
 
-	if err := os.MkdirAll(dst, 0o750); err != nil && !errors.Is(err, os.ErrExist) {
-		return fmt.Errorf("creating %s: %w", dst, err)
+// overwritable reports whether dstPath, named name, which differs from
+// srcPath, may be overwritten without Force: only if the manifest shows it
+// unedited since it was copied from a source that has changed since.
+// Otherwise it is listed as Modified or Skipped.
+func (s *syncer) overwritable(name, srcPath, dstPath string) (bool, error) {
+	var st ownership
+	if s.manifest != nil {
+		var err error
+		if st, err = s.manifest.ownership(s.base, srcPath, dstPath); err != nil {
+			return false, err
+		}
 	}
+	switch {
+	case st.edited:
+		s.res.Modified = append(s.res.Modified, name)
+		return false, nil
+	case st.owned && st.stale:
+		return true, nil
+	default:
+		s.res.Skipped = append(s.res.Skipped, name)
+		return false, nil
+	}
+}
+
+// copy copies srcPath to dstPath, unless opts.DryRun is set, and records it
+// in the manifest, if any.
+func (s *syncer) copy(srcPath, dstPath string) error {
+	if !s.opts.DryRun {
+		if err := s.opts.beforeWrite(dstPath); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0o750); err != nil {
+			return fmt.Errorf("creating %s: %w", filepath.Dir(dstPath), err)
+		}
+		if err := copyFile(srcPath, dstPath); err != nil {
+			return err
+		}
+	}
+	if s.manifest == nil {
+		return nil
+	}
+	return s.manifest.record(s.base, srcPath, dstPath)
+}
+
+// prune removes the files under dst that manifest lists but whose source no
+// longer exists, then any directories left empty by that. Files a source
+// still provides, as listed in res.Sources, are never removed. Removed paths,
+// relative to dst, are added to res.Removed; files edited since they were
+// copied are kept and added to res.Modified. With opts.DryRun nothing is
+// removed.
+func prune(manifest *Manifest, base, dst string, opts Options, res *Result) error {
+	dstKey, err := manifestKey(base, dst)
+	if err != nil {
+		return err
+	}
+
+	var dirs []string
+	for _, key := range manifest.owned(dstKey) {
+		rel := strings.TrimPrefix(key, dstKey+"/")
+		if _, provided := res.Sources[rel]; provided {
+			continue
+		}
+		entry := manifest.Files[key]
+		if _, err := os.Lstat(entry.Source); err == nil {
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("stat %s: %w", entry.Source, err)
+		}
+
+		path := filepath.Join(base, filepath.FromSlash(key))
+		sum, err := hashFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// Already deleted by the user; just forget it.
+			manifest.forget(key)
+			continue
+		}
+		if err != nil {
+			return err
+		}
 
-	for _, entry := range entries {
-		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
+		if sum != entry.SHA256 {
+			res.Modified = append(res.Modified, rel)
+			continue
+		}
 
-		switch {
-		case entry.IsDir():
-			if err := copyDir(srcPath, dstPath); err != nil {
+		if !opts.DryRun {
+			if err := opts.beforeWrite(path); err != nil {
 				return err
 			}
-		case entry.Type().IsRegular():
-			if err := copyFile(srcPath, dstPath); err != nil {
-				return err
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("removing %s: %w", path, err)
 			}
+			dirs = append(dirs, filepath.Dir(path))
 		}
+		manifest.forget(key)
+		res.Removed = append(res.Removed, rel)
 	}
 
+	removeEmptyDirs(dirs, dst)
 	return nil
 }
 
+// removeEmptyDirs removes each of dirs, and then its parents up to dst, if
+// empty, deepest first. A directory that still holds anything (such as a file
+// the user added) is kept.
+func removeEmptyDirs(dirs []string, dst string) {
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		for ; dir != dst && strings.HasPrefix(dir, dst); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+}
+
+// beforeWrite calls o.BeforeWrite, if set, with path.
+func (o Options) beforeWrite(path string) error {
+	if o.BeforeWrite == nil {
+		return nil
+	}
+	return o.BeforeWrite(path)
+}
+
+// sameFile reports whether dst is a regular file with the same contents as
+// src. Sizes are compared before hashing.
+func sameFile(src, dst string) (bool, error) {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false, fmt.Errorf("stat %s: %w", src, err)
+	}
+	dstInfo, err := os.Lstat(dst)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("stat %s: %w", dst, err)
+	}
+	if !dstInfo.Mode().IsRegular() || srcInfo.Size() != dstInfo.Size() {
+		return false, nil
+	}
+
+	srcSum, err := hashFile(src)
+	if err != nil {
+		return false, err
+	}
+	dstSum, err := hashFile(dst)
+	if err != nil {
+		return false, err
+	}
+	return srcSum == dstSum, nil
+}
+
 // copyFile copies the file at src to dst, preserving the source file's permissions.
 func copyFile(src, dst string) error {
 	in, err := os.Open(src)
@@ -3,6 +3,7 @@ package dirsync_test
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/jeff/claude-config-merge/internal/dirsync"
@@ -46,7 +47,7 @@ func TestSync_CopiesNewFiles(t *testing.T) {
 	writeFile(t, filepath.Join(src, "a.md"), "agent a")
 	writeFile(t, filepath.Join(src, "b.md"), "agent b")
 
-	res, err := dirsync.Sync(src, dst, false)
+	res, err := dirsync.Sync(src, dst, dirsync.Options{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -78,7 +79,7 @@ func TestSync_SkipsExistingWithoutForce(t *testing.T) {
 	writeFile(t, filepath.Join(src, "a.md"), "new content")
 	writeFile(t, filepath.Join(dst, "a.md"), "original content")
 
-	res, err := dirsync.Sync(src, dst, false)
+	res, err := dirsync.Sync(src, dst, dirsync.Options{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -107,7 +108,7 @@ func TestSync_ForcesOverwriteWithForce(t *testing.T) {
 	writeFile(t, filepath.Join(src, "a.md"), "new content")
 	writeFile(t, filepath.Join(dst, "a.md"), "original content")
 
-	res, err := dirsync.Sync(src, dst, true)
+	res, err := dirsync.Sync(src, dst, dirsync.Options{Force: true})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -132,7 +133,7 @@ func TestSync_SourceNotExist(t *testing.T) {
 	src := filepath.Join(dir, "nonexistent")
 	dst := filepath.Join(dir, "dst")
 
-	res, err := dirsync.Sync(src, dst, false)
+	res, err := dirsync.Sync(src, dst, dirsync.Options{})
 	if err != nil {
 		t.Fatalf("expected nil error for missing src, got: %v", err)
 	}
@@ -152,7 +153,7 @@ func TestSync_CreatesDestDir(t *testing.T) {
 	}
 	writeFile(t, filepath.Join(src, "file.md"), "content")
 
-	res, err := dirsync.Sync(src, dst, false)
+	res, err := dirsync.Sync(src, dst, dirsync.Options{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -175,13 +176,13 @@ func TestSync_CopiesSubdirectory(t *testing.T) {
 	}
 	writeFile(t, filepath.Join(src, "jeff-skill-foo", "SKILL.md"), "skill content")
 
-	res, err := dirsync.Sync(src, dst, false)
+	res, err := dirsync.Sync(src, dst, dirsync.Options{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if len(res.Copied) != 1 || res.Copied[0] != "jeff-skill-foo" {
-		t.Errorf("Copied = %v; want [jeff-skill-foo]", res.Copied)
+	if len(res.Copied) != 1 || res.Copied[0] != "jeff-skill-foo/SKILL.md" {
+		t.Errorf("Copied = %v; want [jeff-skill-foo/SKILL.md]", res.Copied)
 	}
 
 	got := readFile(t, filepath.Join(dst, "jeff-skill-foo", "SKILL.md"))
@@ -207,13 +208,13 @@ func TestSync_SkipsExistingSubdirectory(t *testing.T) {
 	}
 	writeFile(t, filepath.Join(dst, "jeff-skill-foo", "SKILL.md"), "original content")
 
-	res, err := dirsync.Sync(src, dst, false)
+	res, err := dirsync.Sync(src, dst, dirsync.Options{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if len(res.Skipped) != 1 || res.Skipped[0] != "jeff-skill-foo" {
-		t.Errorf("Skipped = %v; want [jeff-skill-foo]", res.Skipped)
+	if len(res.Skipped) != 1 || res.Skipped[0] != "jeff-skill-foo/SKILL.md" {
+		t.Errorf("Skipped = %v; want [jeff-skill-foo/SKILL.md]", res.Skipped)
 	}
 	// Original content must be preserved.
 	if readFile(t, filepath.Join(dst, "jeff-skill-foo", "SKILL.md")) != "original content" {
@@ -237,13 +238,13 @@ func TestSync_ForcesExistingSubdirectory(t *testing.T) {
 	}
 	writeFile(t, filepath.Join(dst, "jeff-skill-foo", "SKILL.md"), "original content")
 
-	res, err := dirsync.Sync(src, dst, true)
+	res, err := dirsync.Sync(src, dst, dirsync.Options{Force: true})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if len(res.Forced) != 1 || res.Forced[0] != "jeff-skill-foo" {
-		t.Errorf("Forced = %v; want [jeff-skill-foo]", res.Forced)
+	if len(res.Forced) != 1 || res.Forced[0] != "jeff-skill-foo/SKILL.md" {
+		t.Errorf("Forced = %v; want [jeff-skill-foo/SKILL.md]", res.Forced)
 	}
 	if readFile(t, filepath.Join(dst, "jeff-skill-foo", "SKILL.md")) != "new content" {
 		t.Error("subdirectory content should be overwritten when force=true")
@@ -266,7 +267,7 @@ func TestSync_ErrorOnUnreadableSourceFile(t *testing.T) {
 	}
 	t.Cleanup(func() { _ = os.Chmod(srcFile, 0o600) })
 
-	_, err := dirsync.Sync(src, dst, false)
+	_, err := dirsync.Sync(src, dst, dirsync.Options{})
 	if err == nil {
 		t.Fatal("expected error when source file is unreadable, got nil")
 	}
@@ -294,7 +295,7 @@ func TestSync_ErrorOnUnreadableFileInSubdirectory(t *testing.T) {
 	}
 	t.Cleanup(func() { _ = os.Chmod(secretFile, 0o600) })
 
-	_, err := dirsync.Sync(src, dst, false)
+	_, err := dirsync.Sync(src, dst, dirsync.Options{})
 	if err == nil {
 		t.Fatal("expected error when file inside subdirectory is unreadable, got nil")
 	}
@@ -324,8 +325,739 @@ func TestSync_ErrorWhenDestDirNotCreatable(t *testing.T) {
 
 	dst := filepath.Join(parentDir, "newdir")
 
-	_, err := dirsync.Sync(src, dst, false)
+	_, err := dirsync.Sync(src, dst, dirsync.Options{})
 	if err == nil {
 		t.Fatal("expected error when dst cannot be created, got nil")
 	}
 }
+
+func TestSync_DryRunWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := os.MkdirAll(filepath.Join(src, "skill"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "a.md"), "a")
+	writeFile(t, filepath.Join(src, "skill", "SKILL.md"), "s")
+
+	res, err := dirsync.Sync(src, dst, dirsync.Options{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(res.Copied) != 2 {
+		t.Errorf("Copied = %v; want 2 entries", res.Copied)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("dst exists after dry run (stat err = %v); want not created", err)
+	}
+}
+
+func TestSync_DryRunForceReportsForced(t *testing.T) {
+	src, dst := makeSrcDst(t)
+
+	writeFile(t, filepath.Join(src, "a.md"), "new content")
+	writeFile(t, filepath.Join(dst, "a.md"), "original content")
+
+	res, err := dirsync.Sync(src, dst, dirsync.Options{Force: true, DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(res.Forced) != 1 {
+		t.Errorf("Forced = %v; want [a.md]", res.Forced)
+	}
+	if readFile(t, filepath.Join(dst, "a.md")) != "original content" {
+		t.Error("a.md overwritten during dry run")
+	}
+}
+
+func TestSync_RecordsCopiedFilesInManifest(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	manifestPath := filepath.Join(filepath.Dir(dst), dirsync.ManifestName)
+	if err := os.MkdirAll(filepath.Join(src, "skill"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "a.md"), "a")
+	writeFile(t, filepath.Join(src, "skill", "SKILL.md"), "s")
+	writeFile(t, filepath.Join(dst, "mine.md"), "user file")
+
+	if _, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m, err := dirsync.LoadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"dst/a.md", "dst/skill/SKILL.md"} {
+		if _, ok := m.Files[key]; !ok {
+			t.Errorf("manifest missing %s; got %v", key, m.Files)
+		}
+	}
+	if _, ok := m.Files["dst/mine.md"]; ok {
+		t.Error("manifest lists user file dst/mine.md")
+	}
+}
+
+func TestSync_PruneRemovesOnlyOwnedFilesWithMissingSource(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	manifestPath := filepath.Join(filepath.Dir(dst), dirsync.ManifestName)
+	if err := os.MkdirAll(filepath.Join(src, "skill"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "keep.md"), "k")
+	writeFile(t, filepath.Join(src, "gone.md"), "g")
+	writeFile(t, filepath.Join(src, "skill", "SKILL.md"), "s")
+	writeFile(t, filepath.Join(dst, "mine.md"), "user file")
+
+	if _, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath}); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(src, "gone.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(src, "skill")); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath, Prune: true})
+	if err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+
+	if len(res.Removed) != 2 || res.Removed[0] != "gone.md" || res.Removed[1] != "skill/SKILL.md" {
+		t.Errorf("Removed = %v; want [gone.md skill/SKILL.md]", res.Removed)
+	}
+	for _, name := range []string{"gone.md", "skill"} {
+		if _, err := os.Stat(filepath.Join(dst, name)); !os.IsNotExist(err) {
+			t.Errorf("dst/%s still exists (stat err = %v)", name, err)
+		}
+	}
+	if readFile(t, filepath.Join(dst, "mine.md")) != "user file" {
+		t.Error("user file mine.md was changed")
+	}
+	if readFile(t, filepath.Join(dst, "keep.md")) != "k" {
+		t.Error("keep.md was changed")
+	}
+
+	m, err := dirsync.LoadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Files["dst/gone.md"]; ok {
+		t.Error("manifest still lists dst/gone.md")
+	}
+}
+
+func TestSync_PruneKeepsDirectoryWithUserFiles(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	manifestPath := filepath.Join(filepath.Dir(dst), dirsync.ManifestName)
+	if err := os.MkdirAll(filepath.Join(src, "skill"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "skill", "SKILL.md"), "s")
+
+	if _, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath}); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	writeFile(t, filepath.Join(dst, "skill", "notes.md"), "user notes")
+	if err := os.RemoveAll(filepath.Join(src, "skill")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath, Prune: true}); err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "skill", "SKILL.md")); !os.IsNotExist(err) {
+		t.Errorf("SKILL.md still exists (stat err = %v)", err)
+	}
+	if readFile(t, filepath.Join(dst, "skill", "notes.md")) != "user notes" {
+		t.Error("user file notes.md was changed")
+	}
+}
+
+func TestSync_PruneDryRunRemovesNothing(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	manifestPath := filepath.Join(filepath.Dir(dst), dirsync.ManifestName)
+	writeFile(t, filepath.Join(src, "gone.md"), "g")
+
+	if _, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath}); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	if err := os.Remove(filepath.Join(src, "gone.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath, Prune: true, DryRun: true})
+	if err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+
+	if len(res.Removed) != 1 {
+		t.Errorf("Removed = %v; want [gone.md]", res.Removed)
+	}
+	if readFile(t, filepath.Join(dst, "gone.md")) != "g" {
+		t.Error("gone.md removed during dry run")
+	}
+}
+
+func TestSync_ManifestRecordsHashAndTime(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	manifestPath := filepath.Join(filepath.Dir(dst), dirsync.ManifestName)
+	writeFile(t, filepath.Join(src, "a.md"), "hello")
+
+	if _, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m, err := dirsync.LoadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := m.Files["dst/a.md"]
+	// sha256("hello")
+	if entry.SHA256 != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("SHA256 = %q; want hash of file contents", entry.SHA256)
+	}
+	if entry.Source != filepath.Join(src, "a.md") {
+		t.Errorf("Source = %q; want %q", entry.Source, filepath.Join(src, "a.md"))
+	}
+	if entry.SyncedAt.IsZero() {
+		t.Error("SyncedAt is zero; want time of sync")
+	}
+}
+
+func TestSync_UpdatesUntouchedFilesWithoutForce(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	manifestPath := filepath.Join(filepath.Dir(dst), dirsync.ManifestName)
+	if err := os.MkdirAll(filepath.Join(src, "skill"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "a.md"), "v1")
+	writeFile(t, filepath.Join(src, "skill", "SKILL.md"), "v1")
+
+	if _, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath}); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	writeFile(t, filepath.Join(src, "a.md"), "v2")
+	writeFile(t, filepath.Join(src, "skill", "SKILL.md"), "v2")
+
+	res, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath})
+	if err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+
+	if len(res.Updated) != 2 || res.Updated[0] != "a.md" || res.Updated[1] != "skill/SKILL.md" {
+		t.Errorf("Updated = %v; want [a.md skill/SKILL.md]", res.Updated)
+	}
+	if readFile(t, filepath.Join(dst, "a.md")) != "v2" || readFile(t, filepath.Join(dst, "skill", "SKILL.md")) != "v2" {
+		t.Error("untouched files not updated to v2")
+	}
+}
+
+func TestSync_KeepsLocallyEditedFiles(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	manifestPath := filepath.Join(filepath.Dir(dst), dirsync.ManifestName)
+	writeFile(t, filepath.Join(src, "a.md"), "v1")
+
+	if _, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath}); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	writeFile(t, filepath.Join(dst, "a.md"), "my edit")
+	writeFile(t, filepath.Join(src, "a.md"), "v2")
+
+	res, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath})
+	if err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	if len(res.Modified) != 1 || res.Modified[0] != "a.md" {
+		t.Errorf("Modified = %v; want [a.md]", res.Modified)
+	}
+	if readFile(t, filepath.Join(dst, "a.md")) != "my edit" {
+		t.Error("locally edited a.md was overwritten")
+	}
+
+	res, err = dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath, Force: true})
+	if err != nil {
+		t.Fatalf("forced sync: %v", err)
+	}
+	if len(res.Forced) != 1 || readFile(t, filepath.Join(dst, "a.md")) != "v2" {
+		t.Errorf("Forced = %v, a.md = %q; want a.md forced to v2", res.Forced, readFile(t, filepath.Join(dst, "a.md")))
+	}
+}
+
+func TestSync_NeverOwnedFileSkipped(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	manifestPath := filepath.Join(filepath.Dir(dst), dirsync.ManifestName)
+	writeFile(t, filepath.Join(src, "a.md"), "master")
+	writeFile(t, filepath.Join(dst, "a.md"), "mine")
+
+	res, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Skipped) != 1 || len(res.Updated) != 0 || len(res.Modified) != 0 {
+		t.Errorf("Skipped = %v, Updated = %v, Modified = %v; want only skipped", res.Skipped, res.Updated, res.Modified)
+	}
+}
+
+func TestSync_PruneKeepsEditedFiles(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	manifestPath := filepath.Join(filepath.Dir(dst), dirsync.ManifestName)
+	writeFile(t, filepath.Join(src, "gone.md"), "g")
+
+	if _, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath}); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	writeFile(t, filepath.Join(dst, "gone.md"), "my edit")
+	if err := os.Remove(filepath.Join(src, "gone.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath, Prune: true})
+	if err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	if len(res.Removed) != 0 || len(res.Modified) != 1 {
+		t.Errorf("Removed = %v, Modified = %v; want edited file kept and reported", res.Removed, res.Modified)
+	}
+	if readFile(t, filepath.Join(dst, "gone.md")) != "my edit" {
+		t.Error("edited gone.md was removed")
+	}
+}
+
+func TestSync_IdenticalFilesUnchanged(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	if err := os.MkdirAll(filepath.Join(src, "skill"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dst, "skill"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "a.md"), "same")
+	writeFile(t, filepath.Join(dst, "a.md"), "same")
+	writeFile(t, filepath.Join(src, "skill", "SKILL.md"), "same")
+	writeFile(t, filepath.Join(dst, "skill", "SKILL.md"), "same")
+	writeFile(t, filepath.Join(src, "b.md"), "new")
+	writeFile(t, filepath.Join(dst, "b.md"), "old")
+
+	for _, force := range []bool{false, true} {
+		res, err := dirsync.Sync(src, dst, dirsync.Options{Force: force})
+		if err != nil {
+			t.Fatalf("force=%v: unexpected error: %v", force, err)
+		}
+		if len(res.Unchanged) != 2 || res.Unchanged[0] != "a.md" || res.Unchanged[1] != "skill/SKILL.md" {
+			t.Errorf("force=%v: Unchanged = %v; want [a.md skill/SKILL.md]", force, res.Unchanged)
+		}
+		if force {
+			if len(res.Forced) != 1 || res.Forced[0] != "b.md" {
+				t.Errorf("force=true: Forced = %v; want [b.md]", res.Forced)
+			}
+		} else if len(res.Skipped) != 1 || res.Skipped[0] != "b.md" {
+			t.Errorf("force=false: Skipped = %v; want [b.md]", res.Skipped)
+		}
+	}
+}
+
+func TestSync_IdenticalFileClaimedInManifest(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	manifestPath := filepath.Join(filepath.Dir(dst), dirsync.ManifestName)
+	writeFile(t, filepath.Join(src, "a.md"), "v1")
+	writeFile(t, filepath.Join(dst, "a.md"), "v1")
+
+	if _, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath}); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	writeFile(t, filepath.Join(src, "a.md"), "v2")
+
+	res, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath})
+	if err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	if len(res.Updated) != 1 || readFile(t, filepath.Join(dst, "a.md")) != "v2" {
+		t.Errorf("Updated = %v; want identical file claimed and then updated", res.Updated)
+	}
+}
+
+func TestSync_NewFileInExistingSkillArrivesWithoutForce(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	for _, d := range []string{filepath.Join(src, "skill", "scripts"), filepath.Join(dst, "skill")} {
+		if err := os.MkdirAll(d, 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(src, "skill", "SKILL.md"), "master")
+	writeFile(t, filepath.Join(dst, "skill", "SKILL.md"), "mine")
+	writeFile(t, filepath.Join(src, "skill", "scripts", "run.sh"), "echo hi")
+
+	res, err := dirsync.Sync(src, dst, dirsync.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(res.Copied) != 1 || res.Copied[0] != "skill/scripts/run.sh" {
+		t.Errorf("Copied = %v; want [skill/scripts/run.sh]", res.Copied)
+	}
+	if len(res.Skipped) != 1 || res.Skipped[0] != "skill/SKILL.md" {
+		t.Errorf("Skipped = %v; want [skill/SKILL.md]", res.Skipped)
+	}
+	if readFile(t, filepath.Join(dst, "skill", "scripts", "run.sh")) != "echo hi" {
+		t.Error("new file in existing skill not copied")
+	}
+	if readFile(t, filepath.Join(dst, "skill", "SKILL.md")) != "mine" {
+		t.Error("existing SKILL.md overwritten without force")
+	}
+}
+
+func TestSync_FileInPlaceOfDirectorySkipped(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	if err := os.MkdirAll(filepath.Join(src, "skill"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "skill", "SKILL.md"), "s")
+	writeFile(t, filepath.Join(dst, "skill"), "not a directory")
+
+	res, err := dirsync.Sync(src, dst, dirsync.Options{Force: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Skipped) != 1 || res.Skipped[0] != "skill" {
+		t.Errorf("Skipped = %v; want [skill]", res.Skipped)
+	}
+	if readFile(t, filepath.Join(dst, "skill")) != "not a directory" {
+		t.Error("file in place of directory was replaced")
+	}
+}
+
+func TestSync_IgnoreFileFiltersEntries(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	for _, d := range []string{"drafts", "skill/fixtures", "skill/keep"} {
+		if err := os.MkdirAll(filepath.Join(src, d), 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(src, dirsync.IgnoreFileName), "# docs and junk\nREADME.md\n.DS_Store\ndrafts/\n**/fixtures/\n*.tmp\n!important.tmp\n")
+	writeFile(t, filepath.Join(src, "agent.md"), "a")
+	writeFile(t, filepath.Join(src, "README.md"), "r")
+	writeFile(t, filepath.Join(src, ".DS_Store"), "x")
+	writeFile(t, filepath.Join(src, "drafts", "wip.md"), "w")
+	writeFile(t, filepath.Join(src, "skill", "SKILL.md"), "s")
+	writeFile(t, filepath.Join(src, "skill", "README.md"), "r")
+	writeFile(t, filepath.Join(src, "skill", "fixtures", "f.json"), "{}")
+	writeFile(t, filepath.Join(src, "skill", "keep", "scratch.tmp"), "t")
+	writeFile(t, filepath.Join(src, "skill", "keep", "important.tmp"), "i")
+
+	res, err := dirsync.Sync(src, dst, dirsync.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantCopied := []string{"agent.md", "skill/SKILL.md", "skill/keep/important.tmp"}
+	if len(res.Copied) != len(wantCopied) {
+		t.Fatalf("Copied = %v; want %v", res.Copied, wantCopied)
+	}
+	for i := range wantCopied {
+		if res.Copied[i] != wantCopied[i] {
+			t.Errorf("Copied[%d] = %q; want %q", i, res.Copied[i], wantCopied[i])
+		}
+	}
+	wantIgnored := []string{".DS_Store", "README.md", "drafts", "skill/README.md", "skill/fixtures", "skill/keep/scratch.tmp"}
+	if len(res.Ignored) != len(wantIgnored) {
+		t.Fatalf("Ignored = %v; want %v", res.Ignored, wantIgnored)
+	}
+	for i := range wantIgnored {
+		if res.Ignored[i] != wantIgnored[i] {
+			t.Errorf("Ignored[%d] = %q; want %q", i, res.Ignored[i], wantIgnored[i])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, dirsync.IgnoreFileName)); !os.IsNotExist(err) {
+		t.Errorf("ignore file was copied (stat err = %v)", err)
+	}
+}
+
+func TestSync_IncludeAndExcludeOptions(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	if err := os.MkdirAll(filepath.Join(src, "skill"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "a.md"), "a")
+	writeFile(t, filepath.Join(src, "b.md"), "b")
+	writeFile(t, filepath.Join(src, "notes.txt"), "n")
+	writeFile(t, filepath.Join(src, "skill", "SKILL.md"), "s")
+
+	res, err := dirsync.Sync(src, dst, dirsync.Options{Include: []string{"*.md"}, Exclude: []string{"/b.md"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(res.Copied) != 2 || res.Copied[0] != "a.md" || res.Copied[1] != "skill/SKILL.md" {
+		t.Errorf("Copied = %v; want [a.md skill/SKILL.md]", res.Copied)
+	}
+	if len(res.Ignored) != 2 || res.Ignored[0] != "b.md" || res.Ignored[1] != "notes.txt" {
+		t.Errorf("Ignored = %v; want [b.md notes.txt]", res.Ignored)
+	}
+}
+
+func TestSync_IncludeMatchesAncestorDirectories(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	for _, dir := range []string{"skill/ref", "docs", "other"} {
+		if err := os.MkdirAll(filepath.Join(src, filepath.FromSlash(dir)), 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(src, "skill", "SKILL.md"), "s")
+	writeFile(t, filepath.Join(src, "skill", "ref", "notes.txt"), "n")
+	writeFile(t, filepath.Join(src, "skill", "ref", "draft.txt"), "d")
+	writeFile(t, filepath.Join(src, "docs", "a.txt"), "a")
+	writeFile(t, filepath.Join(src, "other", "b.txt"), "b")
+
+	opts := dirsync.Options{Include: []string{"skill", "/docs/", "!draft.txt"}}
+	res, err := dirsync.Sync(src, dst, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantCopied := []string{"docs/a.txt", "skill/SKILL.md", "skill/ref/notes.txt"}
+	if !slices.Equal(res.Copied, wantCopied) {
+		t.Errorf("Copied = %v; want %v", res.Copied, wantCopied)
+	}
+	wantIgnored := []string{"other/b.txt", "skill/ref/draft.txt"}
+	if !slices.Equal(res.Ignored, wantIgnored) {
+		t.Errorf("Ignored = %v; want %v", res.Ignored, wantIgnored)
+	}
+}
+
+func TestSync_BeforeWriteSeesEveryWrite(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	manifestPath := filepath.Join(filepath.Dir(dst), dirsync.ManifestName)
+	writeFile(t, filepath.Join(src, "new.md"), "n")
+	writeFile(t, filepath.Join(src, "same.md"), "s")
+	writeFile(t, filepath.Join(src, "gone.md"), "g")
+	writeFile(t, filepath.Join(dst, "same.md"), "s")
+	writeFile(t, filepath.Join(dst, "mine.md"), "user file")
+
+	if _, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath}); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	if err := os.Remove(filepath.Join(src, "gone.md")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "mine.md"), "master")
+	writeFile(t, filepath.Join(src, "added.md"), "a")
+
+	var seen []string
+	record := func(path string) error {
+		// The hook runs before the change, so the old content is still there.
+		if path == filepath.Join(dst, "mine.md") && readFile(t, path) != "user file" {
+			t.Error("BeforeWrite called after mine.md was overwritten")
+		}
+		rel, err := filepath.Rel(filepath.Dir(dst), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen = append(seen, filepath.ToSlash(rel))
+		return nil
+	}
+	if _, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath, Prune: true, Force: true, BeforeWrite: record}); err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+
+	want := []string{"dst/added.md", "dst/mine.md", "dst/gone.md", dirsync.ManifestName}
+	if len(seen) != len(want) {
+		t.Fatalf("BeforeWrite saw %v; want %v", seen, want)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Errorf("BeforeWrite saw %v; want %v", seen, want)
+			break
+		}
+	}
+
+	// Nothing changes on a repeat run, so nothing is reported.
+	seen = nil
+	if _, err := dirsync.Sync(src, dst, dirsync.Options{Manifest: manifestPath, Prune: true, BeforeWrite: record}); err != nil {
+		t.Fatalf("third sync: %v", err)
+	}
+	if len(seen) != 0 {
+		t.Errorf("BeforeWrite saw %v on a no-op sync; want nothing", seen)
+	}
+}
+
+func TestSync_BeforeWriteErrorAborts(t *testing.T) {
+	src, dst := makeSrcDst(t)
+	writeFile(t, filepath.Join(src, "a.md"), "a")
+
+	_, err := dirsync.Sync(src, dst, dirsync.Options{BeforeWrite: func(string) error { return os.ErrPermission }})
+	if err == nil {
+		t.Fatal("expected BeforeWrite error, got nil")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "a.md")); !os.IsNotExist(err) {
+		t.Errorf("a.md was copied despite the BeforeWrite error (stat err = %v)", err)
+	}
+}
+
+func TestSyncLayers_LaterSourcesWin(t *testing.T) {
+	dir := t.TempDir()
+	company := filepath.Join(dir, "company")
+	team := filepath.Join(dir, "team")
+	dst := filepath.Join(dir, "dst")
+	for _, d := range []string{filepath.Join(company, "skill"), filepath.Join(team, "skill")} {
+		if err := os.MkdirAll(d, 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(company, "a.md"), "company a")
+	writeFile(t, filepath.Join(company, "b.md"), "company b")
+	writeFile(t, filepath.Join(company, "skill", "SKILL.md"), "company skill")
+	writeFile(t, filepath.Join(team, "b.md"), "team b")
+	writeFile(t, filepath.Join(team, "skill", "run.sh"), "team script")
+	writeFile(t, filepath.Join(team, dirsync.IgnoreFileName), "a.md\n")
+
+	res, err := dirsync.SyncLayers([]string{company, team, filepath.Join(dir, "missing")}, dst, dirsync.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"a.md":           "company a", // ignored by team only
+		"b.md":           "team b",
+		"skill/SKILL.md": "company skill",
+		"skill/run.sh":   "team script",
+	} {
+		if got := readFile(t, filepath.Join(dst, filepath.FromSlash(name))); got != want {
+			t.Errorf("%s = %q; want %q", name, got, want)
+		}
+	}
+	if len(res.Copied) != 4 {
+		t.Errorf("Copied = %v; want 4 files", res.Copied)
+	}
+	for name, want := range map[string]string{
+		"a.md": company, "b.md": team, "skill/SKILL.md": company, "skill/run.sh": team,
+	} {
+		if res.Sources[name] != want {
+			t.Errorf("Sources[%q] = %q; want %q", name, res.Sources[name], want)
+		}
+	}
+}
+
+func TestSyncLayers_FallsBackWhenUpperFileRemoved(t *testing.T) {
+	dir := t.TempDir()
+	company := filepath.Join(dir, "company")
+	team := filepath.Join(dir, "team")
+	dst := filepath.Join(dir, "home", "agents")
+	for _, d := range []string{company, team} {
+		if err := os.MkdirAll(d, 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(company, "a.md"), "company a")
+	writeFile(t, filepath.Join(team, "a.md"), "team a")
+	opts := dirsync.Options{Manifest: filepath.Join(dir, "home", dirsync.ManifestName), Prune: true}
+	if _, err := dirsync.SyncLayers([]string{company, team}, dst, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(team, "a.md")); err != nil {
+		t.Fatal(err)
+	}
+	res, err := dirsync.SyncLayers([]string{company, team}, dst, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFile(t, filepath.Join(dst, "a.md")); got != "company a" {
+		t.Errorf("a.md = %q; want the company version", got)
+	}
+	if len(res.Updated) != 1 || len(res.Removed) != 0 {
+		t.Errorf("Updated = %v, Removed = %v; want a.md updated, nothing removed", res.Updated, res.Removed)
+	}
+}
+
+func TestSyncLayers_PruneKeepsFileMovedToLowerLayer(t *testing.T) {
+	dir := t.TempDir()
+	company := filepath.Join(dir, "company")
+	team := filepath.Join(dir, "team")
+	dst := filepath.Join(dir, "home", "agents")
+	for _, d := range []string{company, team} {
+		if err := os.MkdirAll(d, 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(team, "a.md"), "shared a")
+	manifestPath := filepath.Join(dir, "home", dirsync.ManifestName)
+	opts := dirsync.Options{Manifest: manifestPath, Prune: true}
+	if _, err := dirsync.SyncLayers([]string{company, team}, dst, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file moves, unchanged, from the team layer to the company layer.
+	writeFile(t, filepath.Join(company, "a.md"), "shared a")
+	if err := os.Remove(filepath.Join(team, "a.md")); err != nil {
+		t.Fatal(err)
+	}
+	res, err := dirsync.SyncLayers([]string{company, team}, dst, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFile(t, filepath.Join(dst, "a.md")); got != "shared a" {
+		t.Errorf("a.md = %q; want it kept", got)
+	}
+	if len(res.Unchanged) != 1 || len(res.Removed) != 0 {
+		t.Errorf("Unchanged = %v, Removed = %v; want a.md unchanged, nothing removed", res.Unchanged, res.Removed)
+	}
+	m, err := dirsync.LoadManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Files["agents/a.md"].Source; got != filepath.Join(company, "a.md") {
+		t.Errorf("manifest source = %q; want the company file", got)
+	}
+}
+
+func TestSync_PruneKeepsFilesAfterSourceMoves(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "config", "agents")
+	dst := filepath.Join(dir, "home", "agents")
+	if err := os.MkdirAll(src, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "a.md"), "a")
+	opts := dirsync.Options{Manifest: filepath.Join(dir, "home", dirsync.ManifestName), Prune: true}
+	if _, err := dirsync.Sync(src, dst, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config directory is relocated; its files are the same.
+	moved := filepath.Join(dir, "moved")
+	if err := os.Rename(filepath.Join(dir, "config"), moved); err != nil {
+		t.Fatal(err)
+	}
+	res, err := dirsync.Sync(filepath.Join(moved, "agents"), dst, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := readFile(t, filepath.Join(dst, "a.md")); got != "a" {
+		t.Errorf("a.md = %q; want it kept", got)
+	}
+	if len(res.Removed) != 0 {
+		t.Errorf("Removed = %v; want nothing removed", res.Removed)
+	}
+
+	// Once the new source is recorded, removing it prunes the file as usual.
+	if err := os.Remove(filepath.Join(moved, "agents", "a.md")); err != nil {
+		t.Fatal(err)
+	}
+	res, err = dirsync.Sync(filepath.Join(moved, "agents"), dst, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Removed) != 1 {
+		t.Errorf("Removed = %v; want a.md removed", res.Removed)
+	}
+}
@@ -0,0 +1,158 @@
+package dirsync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// IgnoreFileName is the gitignore-style file read from the root of a source
+// directory. It lists the files and directories Sync leaves out.
+const IgnoreFileName = ".claudesyncignore"
+
+// ignoreRule is one parsed line of an ignore file or one configured glob.
+type ignoreRule struct {
+	segments []string // pattern split on "/"; "**" matches any number of segments
+	negate   bool     // "!" prefix: re-include a path an earlier rule excluded
+	dirOnly  bool     // trailing "/": match directories only
+	anchored bool     // pattern contains "/": match from the source root
+}
+
+// matcher decides whether a path relative to the source root matches a list
+// of gitignore-style rules. The last matching rule wins.
+type matcher struct {
+	rules []ignoreRule
+}
+
+// ValidatePattern reports whether pattern is a well-formed ignore pattern.
+func ValidatePattern(pattern string) error {
+	r, ok := parseRule(pattern)
+	if !ok {
+		return nil
+	}
+	for _, seg := range r.segments {
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// newMatcher returns a matcher for patterns, which use gitignore syntax.
+// Blank lines and lines starting with "#" are skipped.
+func newMatcher(patterns []string) *matcher {
+	m := &matcher{}
+	for _, p := range patterns {
+		if r, ok := parseRule(p); ok {
+			m.rules = append(m.rules, r)
+		}
+	}
+	return m
+}
+
+// loadIgnoreFile reads the ignore file at path and returns its patterns. A
+// missing file yields none.
+func loadIgnoreFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return strings.Split(string(data), "\n"), nil
+}
+
+// parseRule parses one pattern. It returns false for blank lines and comments.
+func parseRule(p string) (ignoreRule, bool) {
+	var r ignoreRule
+	p = strings.TrimRight(p, " \t\r")
+	if p == "" || strings.HasPrefix(p, "#") {
+		return r, false
+	}
+	if strings.HasPrefix(p, "!") {
+		r.negate = true
+		p = p[1:]
+	} else if strings.HasPrefix(p, `\`) {
+		// "\#" and "\!" escape a leading special character.
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		r.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	if strings.Contains(p, "/") {
+		r.anchored = true
+		p = strings.TrimPrefix(p, "/")
+	}
+	if p == "" {
+		return r, false
+	}
+	r.segments = strings.Split(p, "/")
+	return r, true
+}
+
+// empty reports whether m has no rules.
+func (m *matcher) empty() bool {
+	return m == nil || len(m.rules) == 0
+}
+
+// match reports whether the slash-separated path rel, relative to the source
+// root, is matched by m. isDir tells whether rel is a directory. A rule that
+// matches a directory above rel matches rel too.
+func (m *matcher) match(rel string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	matched := false
+	segments := strings.Split(rel, "/")
+	for _, r := range m.rules {
+		if r.matches(segments, isDir) {
+			matched = !r.negate
+		}
+	}
+	return matched
+}
+
+// matches reports whether r matches the path made of segments, or one of the
+// directories above it. isDir tells whether the path is a directory.
+func (r ignoreRule) matches(segments []string, isDir bool) bool {
+	for n := len(segments); n > 0; n-- {
+		if r.dirOnly && n == len(segments) && !isDir {
+			continue
+		}
+		var ok bool
+		if r.anchored {
+			ok = matchSegments(r.segments, segments[:n])
+		} else {
+			ok = matchSegments(r.segments, segments[n-1:n])
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments against pattern segments, where a
+// "**" pattern segment matches zero or more path segments.
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, err := path.Match(pattern[0], segments[0])
+	return err == nil && ok && matchSegments(pattern[1:], segments[1:])
+}
@@ -0,0 +1,205 @@
+package dirsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ManifestName is the file name of the ownership manifest, kept in the
+// directory that holds the synced destinations (normally ~/.claude).
+const ManifestName = ".claude-config-merge-manifest.json"
+
+// Manifest records which destination files Sync copied and their contents at
+// the time, so Sync can tell files it copied and nobody touched since apart
+// from files the user edited and files that were never its own.
+type Manifest struct {
+	// Files maps the slash-separated path of each copied file, relative to
+	// the directory holding the manifest, to its entry.
+	Files map[string]ManifestEntry `json:"files"`
+
+	dirty bool // changed since it was loaded
+}
+
+// ManifestEntry describes one file copied by Sync.
+type ManifestEntry struct {
+	// Source is the absolute path the file was copied from.
+	Source string `json:"source"`
+	// SHA256 is the hex-encoded SHA-256 of the file as copied.
+	SHA256 string `json:"sha256"`
+	// SyncedAt is when the file was copied.
+	SyncedAt time.Time `json:"syncedAt"`
+}
+
+// LoadManifest reads the manifest at path. A missing file yields an empty
+// manifest.
+func LoadManifest(path string) (*Manifest, error) {
+	m := &Manifest{Files: map[string]ManifestEntry{}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return m, nil
+		}
+		return nil, fmt.Errorf("reading manifest %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parsing manifest %s: %w", path, err)
+	}
+	if m.Files == nil {
+		m.Files = map[string]ManifestEntry{}
+	}
+	return m, nil
+}
+
+// Save atomically writes m to path.
+func (m *Manifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling manifest: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".manifest-*")
+	if err != nil {
+		return fmt.Errorf("creating temp manifest file: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		if tmpName != "" {
+			_ = os.Remove(tmpName)
+		}
+	}()
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temp manifest: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("finalising manifest %s: %w", path, err)
+	}
+	tmpName = "" // disarm defer
+	return nil
+}
+
+// manifestKey returns the manifest key for the file at path, which is
+// relative to base, the directory holding the manifest.
+func manifestKey(base, path string) (string, error) {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return "", fmt.Errorf("relating %s to manifest directory: %w", path, err)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// owned returns the keys of the files in m that live under the directory
+// whose manifest key is dirKey.
+func (m *Manifest) owned(dirKey string) []string {
+	prefix := dirKey + "/"
+	var keys []string
+	for k := range m.Files {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// ownership describes how an existing destination file relates to the
+// manifest.
+type ownership struct {
+	owned  bool // the manifest lists the file as copied by Sync
+	edited bool // the file differs from its recorded hash
+	stale  bool // the source differs from its recorded hash
+}
+
+// ownership reports the state of dstPath, which is synced from srcPath,
+// relative to the manifest, whose directory is base.
+func (m *Manifest) ownership(base, srcPath, dstPath string) (ownership, error) {
+	var st ownership
+	key, err := manifestKey(base, dstPath)
+	if err != nil {
+		return st, err
+	}
+	entry, ok := m.Files[key]
+	if !ok {
+		return st, nil
+	}
+	st.owned = true
+
+	dstSum, err := hashFile(dstPath)
+	if err != nil {
+		return st, err
+	}
+	srcSum, err := hashFile(srcPath)
+	if err != nil {
+		return st, err
+	}
+	st.edited = dstSum != entry.SHA256
+	st.stale = srcSum != entry.SHA256
+	return st, nil
+}
+
+// recorded reports whether the manifest lists dstPath, under base, as copied
+// from srcPath with the hash sum.
+func (m *Manifest) recorded(base, srcPath, dstPath, sum string) bool {
+	key, err := manifestKey(base, dstPath)
+	if err != nil {
+		return false
+	}
+	abs, err := filepath.Abs(srcPath)
+	if err != nil {
+		return false
+	}
+	entry := m.Files[key]
+	return entry.Source == abs && entry.SHA256 == sum
+}
+
+// record adds the file copied from srcPath to dstPath to the manifest, whose
+// directory is base, with its current hash.
+func (m *Manifest) record(base, srcPath, dstPath string) error {
+	key, err := manifestKey(base, dstPath)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(srcPath)
+	if err != nil {
+		return fmt.Errorf("resolving %s: %w", srcPath, err)
+	}
+	sum, err := hashFile(srcPath)
+	if err != nil {
+		return err
+	}
+	m.Files[key] = ManifestEntry{Source: abs, SHA256: sum, SyncedAt: time.Now().UTC()}
+	m.dirty = true
+	return nil
+}
+
+// forget removes key from the manifest.
+func (m *Manifest) forget(key string) {
+	delete(m.Files, key)
+	m.dirty = true
+}
+
+// hashFile returns the hex-encoded SHA-256 of the file at path. Errors wrap
+// the underlying error, so os.ErrNotExist can be detected with errors.Is.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close() //nolint:errcheck // best-effort close of read-only file
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hashing %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
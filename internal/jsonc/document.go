@@ -0,0 +1,530 @@
+package jsonc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// defaultIndent is used when the document being patched has no indented
+// members to copy the indentation from.
+const defaultIndent = "  "
+
+// node is a parsed JSON value with its location in the source document.
+type node struct {
+	start, end int      // value span
+	object     bool     // true for objects; members is only set for objects
+	members    []member // object members in document order
+}
+
+// member is one "key": value pair of an object. start is the offset just
+// after the preceding '{' or ',' so that src[start:keyStart] holds the
+// whitespace and comments that lead the member. end is the offset of the
+// following ',' or, for the last member, of the closing '}'.
+type member struct {
+	key      string
+	start    int
+	keyStart int
+	value    *node
+	end      int
+}
+
+// document is a JSONC document parsed for patching.
+type document struct {
+	src  []byte // original bytes, comments included
+	std  []byte // Standardize(src); same offsets as src
+	root *node
+}
+
+// parseDocument parses JSONC data, keeping the location of every value.
+func parseDocument(data []byte) (*document, error) {
+	std, err := Standardize(data)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(std) {
+		var v any
+		return nil, json.Unmarshal(std, &v)
+	}
+	p := parser{data: std}
+	root, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	return &document{src: data, std: std, root: root}, nil
+}
+
+// lookup returns the node at path, or nil if there is none.
+func (d *document) lookup(path []string) *node {
+	n := d.root
+	for _, key := range path {
+		if !n.object {
+			return nil
+		}
+		var next *node
+		for _, m := range n.members {
+			if m.key == key {
+				next = m.value // the last duplicate wins, as in encoding/json
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		n = next
+	}
+	return n
+}
+
+// decode returns the Go value of n.
+func (d *document) decode(n *node) (any, error) {
+	return decodeValue(d.std[n.start:n.end])
+}
+
+// parser is a minimal recursive-descent parser over standardized JSON. The
+// input must already be valid JSON.
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) value() (*node, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, fmt.Errorf("unexpected end of JSON input")
+	}
+	start := p.pos
+	switch c := p.data[p.pos]; c {
+	case '{':
+		return p.object()
+	case '[':
+		p.pos++
+		p.skipSpace()
+		if p.data[p.pos] == ']' {
+			p.pos++
+			return &node{start: start, end: p.pos}, nil
+		}
+		for {
+			if _, err := p.value(); err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if p.data[p.pos] == ']' {
+				p.pos++
+				return &node{start: start, end: p.pos}, nil
+			}
+			p.pos++ // ','
+		}
+	case '"':
+		p.pos = skipString(p.data, p.pos) + 1
+	default:
+		for p.pos < len(p.data) && !strings.ContainsRune(" \t\r\n,]}", rune(p.data[p.pos])) {
+			p.pos++
+		}
+	}
+	return &node{start: start, end: p.pos}, nil
+}
+
+func (p *parser) object() (*node, error) {
+	n := &node{start: p.pos, object: true}
+	p.pos++ // '{'
+	memberStart := p.pos
+	for {
+		p.skipSpace()
+		if p.data[p.pos] == '}' {
+			if len(n.members) > 0 {
+				n.members[len(n.members)-1].end = p.pos
+			}
+			p.pos++
+			n.end = p.pos
+			return n, nil
+		}
+
+		keyStart := p.pos
+		p.pos = skipString(p.data, p.pos) + 1
+		var key string
+		if err := json.Unmarshal(p.data[keyStart:p.pos], &key); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		p.pos++ // ':'
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		n.members = append(n.members, member{key: key, start: memberStart, keyStart: keyStart, value: v})
+
+		p.skipSpace()
+		if p.data[p.pos] == ',' {
+			n.members[len(n.members)-1].end = p.pos
+			p.pos++
+			memberStart = p.pos
+		}
+	}
+}
+
+// Patch rewrites the JSONC document local so that it holds merged, changing
+// as little text as possible. Members whose values are unchanged keep their
+// position, formatting and surrounding comments; changed values are replaced
+// in place; members missing from merged are deleted. New members are appended
+// to their object in the key order of the master documents in sources, and a
+// new value that equals the value at the same path in a source is copied from
+// that source so its key order and number formatting are preserved. Later
+// sources take precedence over earlier ones.
+func Patch(local []byte, merged map[string]any, sources ...[]byte) ([]byte, error) {
+	doc, err := parseDocument(local)
+	if err != nil {
+		return nil, fmt.Errorf("parsing local document: %w", err)
+	}
+	if !doc.root.object {
+		return nil, fmt.Errorf("local document is not a JSON object")
+	}
+
+	p := patcher{doc: doc, indent: detectIndent(doc)}
+	for _, src := range sources {
+		sd, err := parseDocument(src)
+		if err != nil {
+			return nil, fmt.Errorf("parsing master document: %w", err)
+		}
+		p.sources = append(p.sources, sd)
+	}
+
+	body, err := p.object(doc.root, merged, nil, 0, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Write(local[:doc.root.start])
+	out.Write(body)
+	out.Write(local[doc.root.end:])
+	return out.Bytes(), nil
+}
+
+// patcher holds the state of a single Patch call.
+type patcher struct {
+	doc     *document
+	sources []*document
+	indent  string // one level of indentation
+}
+
+// object renders the local object n updated to hold merged. path is the key
+// path of n and depth its nesting level (0 for the root). An empty object is
+// laid out on multiple lines if its parent is.
+func (p *patcher) object(n *node, merged map[string]any, path []string, depth int, parentMultiline bool) ([]byte, error) {
+	src := p.doc.src
+	multiline := bytes.IndexByte(src[n.start:n.end], '\n') >= 0 || (len(n.members) == 0 && parentMultiline)
+
+	pieces, present, unchanged, err := p.keptMembers(n, merged, path, depth, multiline)
+	if err != nil {
+		return nil, err
+	}
+	added := p.addedKeys(merged, present, path)
+	if unchanged && len(added) == 0 {
+		return src[n.start:n.end], nil
+	}
+	newMembers, err := p.newMembers(added, merged, path, p.memberIndent(n, depth), depth, multiline)
+	if err != nil {
+		return nil, err
+	}
+	if len(pieces) == 0 {
+		// Nothing of the original object survives; lay the members out afresh.
+		return p.freshObject(n, newMembers, depth, multiline), nil
+	}
+
+	var out bytes.Buffer
+	out.WriteByte('{')
+	out.Write(bytes.Join(pieces, []byte(",")))
+	last := n.members[len(n.members)-1]
+	closing := src[last.value.end : n.end-1]
+	if len(newMembers) == 0 {
+		out.Write(closing)
+		out.WriteByte('}')
+		return out.Bytes(), nil
+	}
+
+	// Insert the new members after the last surviving member, keeping any
+	// comment on that member's line and any trailing comma.
+	closing, trailingComma := p.dropTrailingComma(last.value.end, closing)
+	sameLine, rest := closing, []byte(nil)
+	if i := bytes.IndexByte(closing, '\n'); i >= 0 {
+		sameLine, rest = closing[:i], closing[i:]
+	}
+	out.WriteByte(',')
+	out.Write(sameLine)
+	out.Write(bytes.Join(newMembers, []byte(",")))
+	if trailingComma {
+		out.WriteByte(',')
+	}
+	if rest == nil && multiline {
+		rest = []byte("\n" + strings.Repeat(p.indent, depth))
+	}
+	out.Write(rest)
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+// keptMembers renders the members of the local object n that merged still
+// holds, each with the text up to the next member but the last. It also
+// returns the keys of n and whether every kept member, and so the object if
+// nothing was dropped or added, is unchanged.
+func (p *patcher) keptMembers(n *node, merged map[string]any, path []string, depth int, multiline bool) (pieces [][]byte, present map[string]bool, unchanged bool, err error) {
+	src := p.doc.src
+	present = make(map[string]bool, len(n.members))
+	unchanged = true
+	for i, m := range n.members {
+		present[m.key] = true
+		v, ok := merged[m.key]
+		if !ok {
+			unchanged = false
+			continue
+		}
+
+		text, same, err := p.member(m, v, childPath(path, m.key), depth+1, multiline)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		unchanged = unchanged && same
+
+		var piece []byte
+		piece = append(piece, src[m.start:m.value.start]...)
+		piece = append(piece, text...)
+		if i < len(n.members)-1 {
+			piece = append(piece, src[m.value.end:m.end]...)
+		}
+		pieces = append(pieces, piece)
+	}
+	return pieces, present, unchanged, nil
+}
+
+// newMembers renders the members added to the object at path, indented by
+// memberIndent if multiline. depth is the nesting level of the object.
+func (p *patcher) newMembers(added []string, merged map[string]any, path []string, memberIndent string, depth int, multiline bool) ([][]byte, error) {
+	var members [][]byte
+	for _, k := range added {
+		key, err := marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		val, err := p.value(merged[k], childPath(path, k), depth+1, multiline)
+		if err != nil {
+			return nil, err
+		}
+		var piece []byte
+		if multiline {
+			piece = append(piece, '\n')
+			piece = append(piece, memberIndent...)
+			piece = append(piece, key...)
+			piece = append(piece, ": "...)
+		} else {
+			piece = append(piece, key...)
+			piece = append(piece, ':')
+		}
+		members = append(members, append(piece, val...))
+	}
+	return members, nil
+}
+
+// freshObject lays out the local object n afresh, holding only the rendered
+// members at nesting level depth. Comments inside n, if it was empty, are
+// kept ahead of the members.
+func (p *patcher) freshObject(n *node, members [][]byte, depth int, multiline bool) []byte {
+	var out bytes.Buffer
+	out.WriteByte('{')
+	if len(n.members) == 0 {
+		out.Write(bytes.TrimRight(p.doc.src[n.start+1:n.end-1], " \t\r\n"))
+	}
+	if len(members) > 0 {
+		out.Write(bytes.Join(members, []byte(",")))
+		if multiline {
+			out.WriteByte('\n')
+			out.WriteString(strings.Repeat(p.indent, depth))
+		}
+	}
+	out.WriteByte('}')
+	return out.Bytes()
+}
+
+// member renders the value of local member m updated to v. It reports
+// whether the original text was kept unchanged.
+func (p *patcher) member(m member, v any, path []string, depth int, multiline bool) ([]byte, bool, error) {
+	if sub, ok := v.(map[string]any); ok && m.value.object {
+		text, err := p.object(m.value, sub, path, depth, multiline)
+		if err != nil {
+			return nil, false, err
+		}
+		return text, bytes.Equal(text, p.doc.src[m.value.start:m.value.end]), nil
+	}
+
+	old, err := p.doc.decode(m.value)
+	if err != nil {
+		return nil, false, err
+	}
+	if reflect.DeepEqual(old, v) {
+		return p.doc.src[m.value.start:m.value.end], true, nil
+	}
+	text, err := p.value(v, path, depth, multiline)
+	return text, false, err
+}
+
+// value renders v for the member at path, copying the text from the most
+// precedent source that holds an equal value there.
+func (p *patcher) value(v any, path []string, depth int, multiline bool) ([]byte, error) {
+	raw, err := p.sourceText(v, path)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		if raw, err = marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	var out bytes.Buffer
+	if err := json.Compact(&out, raw); err != nil {
+		return nil, err
+	}
+	if !multiline {
+		return out.Bytes(), nil
+	}
+	compact := out.Bytes()
+	out = bytes.Buffer{}
+	if err := json.Indent(&out, compact, strings.Repeat(p.indent, depth), p.indent); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// sourceText returns the standardized text of the value at path in the most
+// precedent source whose value there equals v, or nil if there is none.
+func (p *patcher) sourceText(v any, path []string) ([]byte, error) {
+	for i := len(p.sources) - 1; i >= 0; i-- {
+		sd := p.sources[i]
+		n := sd.lookup(path)
+		if n == nil {
+			continue
+		}
+		sv, err := sd.decode(n)
+		if err != nil {
+			return nil, err
+		}
+		if reflect.DeepEqual(sv, v) {
+			return sd.std[n.start:n.end], nil
+		}
+	}
+	return nil, nil
+}
+
+// addedKeys returns the keys of merged that are not present locally, in the
+// key order of the sources' object at path. Keys no source orders come last,
+// sorted.
+func (p *patcher) addedKeys(merged map[string]any, present map[string]bool, path []string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for i := len(p.sources) - 1; i >= 0; i-- {
+		n := p.sources[i].lookup(path)
+		if n == nil || !n.object {
+			continue
+		}
+		for _, m := range n.members {
+			if _, ok := merged[m.key]; ok && !present[m.key] && !seen[m.key] {
+				seen[m.key] = true
+				keys = append(keys, m.key)
+			}
+		}
+	}
+
+	var rest []string
+	for k := range merged {
+		if !present[k] && !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// memberIndent returns the indentation for members of n, copied from its
+// first member when that member starts on its own line.
+func (p *patcher) memberIndent(n *node, depth int) string {
+	if len(n.members) > 0 {
+		m := n.members[0]
+		lead := p.doc.src[m.start:m.keyStart]
+		if i := bytes.LastIndexByte(lead, '\n'); i >= 0 && isBlank(lead[i+1:]) {
+			return string(lead[i+1:])
+		}
+	}
+	return strings.Repeat(p.indent, depth+1)
+}
+
+// dropTrailingComma removes a trailing comma from closing, which starts at
+// offset off in the document. Standardize blanks trailing commas, so a comma
+// in src that is a space in std is one.
+func (p *patcher) dropTrailingComma(off int, closing []byte) ([]byte, bool) {
+	for i, c := range closing {
+		if c == ',' && p.doc.std[off+i] == ' ' {
+			out := append([]byte(nil), closing[:i]...)
+			return append(out, closing[i+1:]...), true
+		}
+	}
+	return closing, false
+}
+
+// detectIndent returns one level of indentation as used by the first
+// indented member of the root object.
+func detectIndent(doc *document) string {
+	if len(doc.root.members) == 0 {
+		return defaultIndent
+	}
+	m := doc.root.members[0]
+	lead := doc.src[m.start:m.keyStart]
+	i := bytes.LastIndexByte(lead, '\n')
+	if i < 0 || len(lead[i+1:]) == 0 || !isBlank(lead[i+1:]) {
+		return defaultIndent
+	}
+	return string(lead[i+1:])
+}
+
+// childPath returns a new slice holding path followed by key.
+func childPath(path []string, key string) []string {
+	return append(append(make([]string, 0, len(path)+1), path...), key)
+}
+
+// isBlank reports whether b consists only of spaces and tabs.
+func isBlank(b []byte) bool {
+	return len(bytes.Trim(b, " \t")) == 0
+}
+
+// decodeValue unmarshals a single standard JSON value, as Unmarshal would.
+func decodeValue(data []byte) (any, error) {
+	var v any
+	if err := decode(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// marshal encodes v as compact JSON without escaping <, > and &, which are
+// common in permission rules and shell commands.
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
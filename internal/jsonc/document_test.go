@@ -0,0 +1,180 @@
+package jsonc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func mustUnmarshal(t *testing.T, data string) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := Unmarshal([]byte(data), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestPatch_UnchangedReturnsInput(t *testing.T) {
+	local := "{\n  // keep me\n  \"z\": 1,\n  \"a\": [1,   2]\n}\n"
+
+	out, err := Patch([]byte(local), mustUnmarshal(t, local))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(out) != local {
+		t.Errorf("Patch changed an unchanged document:\n%s", out)
+	}
+}
+
+func TestPatch_AppendsNewKeysInMasterOrder(t *testing.T) {
+	local := "{\n    \"zeta\": true, // mine\n    \"alpha\": 1\n}\n"
+	master := `{"model": "opus", "env": {"B": "2", "A": "1"}, "alpha": 1}`
+
+	merged := mustUnmarshal(t, local)
+	merged["model"] = "opus"
+	merged["env"] = map[string]any{"B": "2", "A": "1"}
+
+	out, err := Patch([]byte(local), merged, []byte(master))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "{\n    \"zeta\": true, // mine\n    \"alpha\": 1,\n    \"model\": \"opus\",\n    \"env\": {\n        \"B\": \"2\",\n        \"A\": \"1\"\n    }\n}\n"
+	if string(out) != want {
+		t.Errorf("Patch =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestPatch_ReplacesChangedValueInPlace(t *testing.T) {
+	local := "{\n  \"a\": \"old\", /* note */\n  \"b\": {\n    \"c\": 1,\n    \"d\": 2\n  }\n}"
+
+	merged := mustUnmarshal(t, local)
+	merged["a"] = "new"
+	merged["b"].(map[string]any)["d"] = 3.0
+
+	out, err := Patch([]byte(local), merged)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "{\n  \"a\": \"new\", /* note */\n  \"b\": {\n    \"c\": 1,\n    \"d\": 3\n  }\n}"
+	if string(out) != want {
+		t.Errorf("Patch =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestPatch_DeletesMembers(t *testing.T) {
+	local := "{\n  \"a\": 1,\n  // about b\n  \"b\": 2,\n  \"c\": 3\n}"
+
+	merged := mustUnmarshal(t, local)
+	delete(merged, "b")
+	delete(merged, "c")
+
+	out, err := Patch([]byte(local), merged)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "{\n  \"a\": 1\n}"
+	if string(out) != want {
+		t.Errorf("Patch =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestPatch_TrailingCommaKept(t *testing.T) {
+	local := "{\n  \"a\": 1, // one\n}"
+
+	merged := mustUnmarshal(t, local)
+	merged["b"] = 2.0
+
+	out, err := Patch([]byte(local), merged)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "{\n  \"a\": 1, // one\n  \"b\": 2,\n}"
+	if string(out) != want {
+		t.Errorf("Patch =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestPatch_EmptyObjectGetsIndented(t *testing.T) {
+	out, err := Patch([]byte("{}"), map[string]any{"k": []any{"x"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := "{\n  \"k\": [\n    \"x\"\n  ]\n}"
+	if string(out) != want {
+		t.Errorf("Patch =\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestPatch_EmptyObjectKeepsComments(t *testing.T) {
+	for _, tc := range []struct {
+		name, local, want string
+	}{
+		{
+			name:  "line comment",
+			local: "{\n  \"env\": {\n    // add variables here\n  }\n}",
+			want:  "{\n  \"env\": {\n    // add variables here\n    \"A\": \"1\"\n  }\n}",
+		},
+		{
+			name:  "block comment",
+			local: "{\n  \"env\": { /* none yet */ }\n}",
+			want:  "{\n  \"env\": { /* none yet */\n    \"A\": \"1\"\n  }\n}",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := Patch([]byte(tc.local), map[string]any{"env": map[string]any{"A": "1"}})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(out) != tc.want {
+				t.Errorf("Patch =\n%s\nwant\n%s", out, tc.want)
+			}
+		})
+	}
+}
+
+func TestPatch_CompactObjectStaysCompact(t *testing.T) {
+	local := `{"a":1}`
+
+	merged := mustUnmarshal(t, local)
+	merged["b"] = map[string]any{"c": true}
+
+	out, err := Patch([]byte(local), merged)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(out) != `{"a":1,"b":{"c":true}}` {
+		t.Errorf("Patch = %s", out)
+	}
+}
+
+func TestPatch_DoesNotEscapeHTML(t *testing.T) {
+	out, err := Patch([]byte(`{}`), map[string]any{"cmd": "a > b && c"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(out, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["cmd"] != "a > b && c" {
+		t.Errorf("cmd = %v", m["cmd"])
+	}
+	if string(out) != "{\n  \"cmd\": \"a > b && c\"\n}" {
+		t.Errorf("Patch = %s", out)
+	}
+}
+
+func TestPatch_InvalidLocal(t *testing.T) {
+	if _, err := Patch([]byte(`{"a":`), map[string]any{}); err == nil {
+		t.Fatal("expected error for invalid local document, got nil")
+	}
+	if _, err := Patch([]byte(`[1]`), map[string]any{}); err == nil {
+		t.Fatal("expected error for non-object local document, got nil")
+	}
+}
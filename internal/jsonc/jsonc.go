@@ -0,0 +1,126 @@
+// Package jsonc reads JSON with comments and trailing commas (JSONC).
+package jsonc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// ErrUnterminatedComment is returned when a /* comment is not closed.
+var ErrUnterminatedComment = errors.New("unterminated /* comment")
+
+// Standardize converts JSONC to plain JSON. Line (//) and block (/* */)
+// comments and trailing commas before a closing ] or } are replaced with
+// spaces; newlines are kept. The output has the same length as data, so byte
+// offsets and line numbers in parse errors still refer to the original input.
+func Standardize(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	// lastComma is the offset of a comma that has not yet been followed by
+	// anything other than whitespace or comments, or -1.
+	lastComma := -1
+	for i := 0; i < len(out); i++ {
+		switch out[i] {
+		case '"':
+			lastComma = -1
+			i = skipString(out, i)
+		case '/':
+			end, err := skipComment(out, i)
+			if err != nil {
+				return nil, err
+			}
+			if end == i {
+				// Not a comment; the JSON parser will reject it.
+				lastComma = -1
+			}
+			i = end
+		case ',':
+			lastComma = i
+		case ']', '}':
+			if lastComma >= 0 {
+				out[lastComma] = ' '
+			}
+			lastComma = -1
+		case ' ', '\t', '\n', '\r':
+		default:
+			lastComma = -1
+		}
+	}
+	return out, nil
+}
+
+// Unmarshal parses JSONC data into v. Numbers stored in interface values are
+// decoded as json.Number so they keep their exact text.
+func Unmarshal(data []byte, v any) error {
+	std, err := Standardize(data)
+	if err != nil {
+		return err
+	}
+	return decode(std, v)
+}
+
+// decode unmarshals a single standard JSON value from data into v using
+// json.Number for numbers.
+func decode(data []byte, v any) error {
+	if !json.Valid(data) {
+		// Let encoding/json produce its usual syntax error.
+		return json.Unmarshal(data, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// skipString returns the offset of the closing quote of the string starting
+// at data[start], or len(data)-1 if it is unterminated.
+func skipString(data []byte, start int) int {
+	for i := start + 1; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return len(data) - 1
+}
+
+// skipComment blanks the comment starting at data[start], if there is one,
+// and returns the offset of its last byte, or start if there is none. A line
+// comment ends before its newline.
+func skipComment(data []byte, start int) (int, error) {
+	if start+1 >= len(data) {
+		return start, nil
+	}
+	switch data[start+1] {
+	case '/':
+		end := start
+		for end < len(data) && data[end] != '\n' {
+			data[end] = ' '
+			end++
+		}
+		return end - 1, nil
+	case '*':
+		end := start + 2
+		for end+1 < len(data) && (data[end] != '*' || data[end+1] != '/') {
+			end++
+		}
+		if end+1 >= len(data) {
+			return 0, ErrUnterminatedComment
+		}
+		blank(data[start : end+2])
+		return end + 1, nil
+	}
+	return start, nil
+}
+
+// blank replaces every byte of b except newlines with a space.
+func blank(b []byte) {
+	for i, c := range b {
+		if c != '\n' && c != '\r' {
+			b[i] = ' '
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package jsonc
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestStandardize_StripsComments(t *testing.T) {
+	in := []byte(`{
+  // line comment
+  "a": 1, /* block */ "b": "// not a comment",
+  "c": "/* nor this */"
+}`)
+
+	out, err := Standardize(in)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(out) != len(in) {
+		t.Errorf("len(out) = %d; want %d (offsets must be preserved)", len(out), len(in))
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(out, &m); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, out)
+	}
+	if m["b"] != "// not a comment" || m["c"] != "/* nor this */" {
+		t.Errorf("string contents altered: %v", m)
+	}
+}
+
+func TestStandardize_StripsTrailingCommas(t *testing.T) {
+	in := []byte(`{"list": [1, 2, /* c */ ], "obj": {"k": "v", // trailing
+},}`)
+
+	var m map[string]any
+	if err := Unmarshal(in, &m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m["list"].([]any)) != 2 {
+		t.Errorf("list = %v; want 2 elements", m["list"])
+	}
+}
+
+func TestStandardize_EscapedQuoteInString(t *testing.T) {
+	in := []byte(`{"a": "say \"hi\", // ok", "b": 1,}`)
+
+	var m map[string]any
+	if err := Unmarshal(in, &m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m["a"] != `say "hi", // ok` {
+		t.Errorf("a = %q", m["a"])
+	}
+}
+
+func TestStandardize_UnterminatedComment(t *testing.T) {
+	_, err := Standardize([]byte(`{"a": 1 /* oops`))
+	if !errors.Is(err, ErrUnterminatedComment) {
+		t.Errorf("err = %v; want ErrUnterminatedComment", err)
+	}
+}
+
+func TestUnmarshal_InvalidJSON(t *testing.T) {
+	var m map[string]any
+	if err := Unmarshal([]byte(`not json`), &m); err == nil {
+		t.Fatal("expected error for invalid JSON, got nil")
+	}
+}
@@ -2,10 +2,130 @@
 package merge
 
 import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"path"
 	"reflect"
 	"sort"
+	"strings"
 )
 
+// ArrayStrategy selects how an array present in both master and local is
+// combined.
+type ArrayStrategy string
+
+const (
+	// ArrayUnion keeps local elements and adds master elements that are not
+	// already present; duplicates are removed from the result.
+	ArrayUnion ArrayStrategy = "union"
+	// ArrayAppend keeps local elements exactly as they are and appends master
+	// elements that are not already present, in master order.
+	ArrayAppend ArrayStrategy = "append"
+	// ArrayReplace replaces the local array with the master array.
+	ArrayReplace ArrayStrategy = "replace"
+)
+
+// ParseArrayStrategy validates s and returns the matching ArrayStrategy.
+func ParseArrayStrategy(s string) (ArrayStrategy, error) {
+	switch st := ArrayStrategy(s); st {
+	case ArrayUnion, ArrayAppend, ArrayReplace:
+		return st, nil
+	default:
+		return "", fmt.Errorf("unknown array strategy %q (want union, append, or replace)", s)
+	}
+}
+
+// Policy decides how a key matched by a rule is merged.
+type Policy string
+
+const (
+	// PolicyMasterWins applies the master value whenever it differs from
+	// local, even if only local changed since the last sync.
+	PolicyMasterWins Policy = "master-wins"
+	// PolicyLocalWins keeps the local value whenever the key exists locally.
+	PolicyLocalWins Policy = "local-wins"
+	// PolicyIgnore leaves the key alone: it is neither added, changed, nor
+	// removed.
+	PolicyIgnore Policy = "ignore"
+)
+
+// ParsePolicy validates s and returns the matching Policy. Besides
+// master-wins, local-wins, and ignore, the name of an array strategy is a
+// valid policy.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case PolicyMasterWins, PolicyLocalWins, PolicyIgnore:
+		return p, nil
+	}
+	if _, err := ParseArrayStrategy(s); err == nil {
+		return Policy(s), nil
+	}
+	return "", fmt.Errorf("unknown policy %q (want master-wins, local-wins, ignore, union, append, or replace)", s)
+}
+
+// ArrayStrategy returns the array strategy named by p, if p names one.
+func (p Policy) ArrayStrategy() (ArrayStrategy, bool) {
+	st, err := ParseArrayStrategy(string(p))
+	return st, err == nil
+}
+
+// ValidatePattern reports whether pattern is a well-formed rule pattern.
+func ValidatePattern(pattern string) error {
+	if _, err := path.Match(slashKey(pattern), ""); err != nil {
+		return fmt.Errorf("invalid rule pattern %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// Options controls how Merge combines master into local.
+type Options struct {
+	// Force makes master values win on conflict for keys no rule matches.
+	Force bool
+	// Rules maps dotted key paths or glob patterns to the policy for the
+	// matching keys. In a pattern * and ? match within one key segment, so
+	// "env.*" matches "env.FOO" but not "env.FOO.BAR". A rule also covers the
+	// keys nested below the ones it matches. When several rules match, an
+	// exact key beats a pattern, the deepest matched key beats its parents,
+	// and the longest pattern wins among the rest. Rules take precedence over
+	// Force, ArrayStrategies, Base, and Resolutions.
+	Rules map[string]Policy
+	// ArrayStrategies maps dotted key paths (e.g. "permissions.allow") to the
+	// strategy used when both sides hold an array at that path. Arrays at
+	// other paths are compared as opaque values.
+	ArrayStrategies map[string]ArrayStrategy
+	// Base is the master settings as last applied to local. When non-nil the
+	// merge is three-way: a key changed only in master is updated, a key
+	// changed only in local is kept, and only keys changed on both sides are
+	// conflicts.
+	Base map[string]any
+	// Prune removes local keys that master no longer has, provided the key
+	// (or one of its parent objects) is listed in Introduced and its local
+	// value still equals the value recorded in Base.
+	Prune bool
+	// Introduced holds the dotted keys that were added to local by earlier
+	// merges. Keys the user created themselves are never in this set.
+	Introduced map[string]bool
+	// Resolutions maps conflicting dotted keys to the value chosen for them,
+	// e.g. by interactive conflict resolution. A resolved key is not reported
+	// as a conflict; it is Resolved if the value differs from local and
+	// KeptLocal otherwise.
+	Resolutions map[string]any
+}
+
+// ArrayAddition lists the elements an array strategy added to a local array.
+type ArrayAddition struct {
+	Key    string
+	Values []any
+}
+
+// Decision records a master key matched by a rule.
+type Decision struct {
+	Key     string
+	Pattern string // the rule that matched
+	Policy  Policy
+}
+
 // Conflict represents a key present in both master and local where values differ.
 type Conflict struct {
 	Key         string
@@ -17,96 +137,511 @@ type Conflict struct {
 type Result struct {
 	Merged    map[string]any
 	Conflicts []Conflict
-	Forced    []string // keys where master overwrote local due to force
+	Forced    []string // keys where master overwrote local due to force, a replace strategy, or a master-wins rule
 	Added     []string // keys from master not in local
 	Matching  []string // keys present in both with identical values
 	LocalOnly []string // keys in local not present in master
+	Updated   []string // keys changed only in master since Base, master value applied
+	KeptLocal []string // keys changed or deleted only in local since Base, kept by a local-wins rule, or resolved to the local value
+	Resolved  []string // conflicting keys replaced by a value from Options.Resolutions
+	Removed   []string // keys dropped from master and removed from local by Prune
+	Ignored   []string // keys left alone because of an ignore rule
+
+	// ArrayAdditions records, per array key, the master elements an array
+	// strategy added to the local array.
+	ArrayAdditions []ArrayAddition
+	// Deduplicated lists the array keys whose duplicates a union strategy
+	// removed from the local array without adding any master element.
+	Deduplicated []string
+
+	// Decisions lists the master keys a rule matched, with the rule, whatever
+	// their outcome. Ignored local-only keys are listed too.
+	Decisions []Decision
 }
 
-// Merge combines master into local. Keys already present in local are kept
-// unless force is true, in which case conflicting keys use the master value.
-// Nested objects are recursively merged. Keys with identical values are counted
-// as matching. Keys with differing values are recorded as conflicts (or forced
-// if force is true). Keys present only in local are recorded for awareness.
-func Merge(master, local map[string]any, force bool) Result {
-	result := Result{
-		Merged: make(map[string]any, len(local)),
-	}
+// Changed reports whether the merge modified local in any way.
+func (r *Result) Changed() bool {
+	return len(r.Added) > 0 || len(r.Forced) > 0 || len(r.Updated) > 0 || len(r.Removed) > 0 ||
+		len(r.Resolved) > 0 || len(r.ArrayAdditions) > 0 || len(r.Deduplicated) > 0
+}
 
-	// Copy all local keys first.
-	for k, v := range local {
-		result.Merged[k] = v
-	}
+// Merge combines master into local. Keys matched by opts.Rules follow their
+// policy. Other keys already present in local are kept unless opts.Force is
+// true, in which case conflicting keys use the master value. Nested objects
+// are recursively merged. Arrays whose key path has an entry in
+// opts.ArrayStrategies are combined element-wise. Keys with identical
+// values are counted as matching. When opts.Base is set, differing keys that
+// changed on one side only are resolved in favour of that side, and keys
+// deleted locally stay deleted, unless opts.Force lets master win. Remaining keys
+// with differing values are recorded as conflicts (or forced if opts.Force is
+// true). Keys present only in local are recorded for awareness, or removed when
+// opts.Prune is set and an earlier merge introduced them. local itself is
+// left untouched.
+func Merge(master, local map[string]any, opts Options) Result {
+	result := Result{Merged: copyObjects(local)}
 
-	mergeInto(result.Merged, master, local, "", force, &result)
+	mergeInto(result.Merged, master, local, opts.Base, "", opts, &result)
 
 	sort.Strings(result.Added)
 	sort.Strings(result.Matching)
 	sort.Strings(result.LocalOnly)
 	sort.Strings(result.Forced)
+	sort.Strings(result.Updated)
+	sort.Strings(result.KeptLocal)
+	sort.Strings(result.Removed)
+	sort.Strings(result.Resolved)
+	sort.Strings(result.Ignored)
+	sort.Strings(result.Deduplicated)
 	sort.Slice(result.Conflicts, func(i, j int) bool {
 		return result.Conflicts[i].Key < result.Conflicts[j].Key
 	})
+	sort.Slice(result.ArrayAdditions, func(i, j int) bool {
+		return result.ArrayAdditions[i].Key < result.ArrayAdditions[j].Key
+	})
+	sort.Slice(result.Decisions, func(i, j int) bool {
+		return result.Decisions[i].Key < result.Decisions[j].Key
+	})
 
 	return result
 }
 
-// mergeInto recursively merges src into dst, tracking additions, matches, conflicts, and local-only keys.
-func mergeInto(dst, src, localSrc map[string]any, prefix string, force bool, result *Result) {
-	for k, srcVal := range src {
+// Overlay stacks layers of master settings, given in order of increasing
+// precedence, into the single master that is merged into local. Nested
+// objects are combined key by key, arrays at a path listed in strategies are
+// combined with that strategy, and any other value in a later layer replaces
+// the one below it. The layers are not modified. Overlay also returns, for
+// every dotted key whose value is not an object, the index of the layer that
+// last set it; an empty object counts as a value.
+func Overlay(layers []map[string]any, strategies map[string]ArrayStrategy) (merged map[string]any, origins map[string]int) {
+	merged = map[string]any{}
+	origins = map[string]int{}
+	for i, layer := range layers {
+		merged = overlay(merged, layer, "", i, strategies, origins)
+	}
+	return merged, origins
+}
+
+// overlay returns a copy of lower with upper, the layer at index layer, laid
+// over it, recording the keys upper sets in origins. prefix is the dotted key
+// of both maps.
+func overlay(lower, upper map[string]any, prefix string, layer int, strategies map[string]ArrayStrategy, origins map[string]int) map[string]any {
+	out := make(map[string]any, len(lower)+len(upper))
+	for k, v := range lower {
+		out[k] = v
+	}
+	for k, v := range upper {
 		key := qualifiedKey(prefix, k)
+		lowerMap, lowerIsMap := out[k].(map[string]any)
+		upperMap, upperIsMap := v.(map[string]any)
+		switch {
+		case upperIsMap && len(upperMap) > 0:
+			if !lowerIsMap {
+				lowerMap = nil
+				delete(origins, key)
+			}
+			out[k] = overlay(lowerMap, upperMap, key, layer, strategies, origins)
+			continue
+		case lowerIsMap:
+			if upperIsMap {
+				// An empty object adds nothing to the one below.
+				continue
+			}
+			forgetPrefix(origins, key)
+		}
 
-		dstVal, exists := dst[k]
-		if !exists {
-			dst[k] = srcVal
-			result.Added = append(result.Added, key)
+		lowerArr, lowerIsArr := out[k].([]any)
+		upperArr, upperIsArr := v.([]any)
+		if strategy, ok := strategies[key]; ok && lowerIsArr && upperIsArr {
+			if merged, _ := mergeArrays(lowerArr, upperArr, strategy); !equal(merged, lowerArr) {
+				out[k] = merged
+				origins[key] = layer
+			}
 			continue
 		}
+		out[k] = v
+		origins[key] = layer
+	}
+	return out
+}
 
-		// Both exist — recurse if both are objects, otherwise compare values.
+// copyObjects returns a copy of m in which nested objects are copied too, so
+// merging into it leaves m as it was.
+func copyObjects(m map[string]any) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		if sub, ok := v.(map[string]any); ok {
+			v = copyObjects(sub)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// forgetPrefix removes the keys nested below key from origins.
+func forgetPrefix(origins map[string]int, key string) {
+	for k := range origins {
+		if strings.HasPrefix(k, key+".") {
+			delete(origins, k)
+		}
+	}
+}
+
+// mergeInto recursively merges src into dst, tracking additions, matches, conflicts, and local-only keys.
+// base is the matching sub-object of opts.Base, or nil if it has none.
+func mergeInto(dst, src, localSrc, base map[string]any, prefix string, opts Options, result *Result) {
+	for k, srcVal := range src {
+		key := qualifiedKey(prefix, k)
+		pattern, policy, ruled := matchRule(opts.Rules, key)
+		dstVal, exists := dst[k]
 		srcMap, srcIsMap := srcVal.(map[string]any)
 		dstMap, dstIsMap := dstVal.(map[string]any)
-
-		if srcIsMap && dstIsMap {
-			var localSubMap map[string]any
-			if localSrc != nil {
-				localSubMap, _ = localSrc[k].(map[string]any)
-			}
-			mergeInto(dstMap, srcMap, localSubMap, key, force, result)
-			dst[k] = dstMap
-			continue
+		if ruled && (policy == PolicyIgnore || !srcIsMap || !dstIsMap) {
+			// Objects merged key by key leave the decisions to their keys.
+			result.Decisions = append(result.Decisions, Decision{Key: key, Pattern: pattern, Policy: policy})
 		}
 
-		if reflect.DeepEqual(srcVal, dstVal) {
+		switch {
+		case ruled && policy == PolicyIgnore:
+			result.Ignored = append(result.Ignored, key)
+		case !exists:
+			mergeMissing(dst, base, k, key, srcVal, policy == PolicyMasterWins || !ruled && opts.Force, result)
+		case srcIsMap && dstIsMap:
+			// Both exist as objects; merge them key by key.
+			localSubMap, _ := localSrc[k].(map[string]any)
+			baseSubMap, _ := base[k].(map[string]any)
+			mergeInto(dstMap, srcMap, localSubMap, baseSubMap, key, opts, result)
+		case equal(srcVal, dstVal):
 			result.Matching = append(result.Matching, key)
+		default:
+			c := Conflict{Key: key, MasterValue: srcVal, LocalValue: dstVal}
+			mergeValue(dst, base, k, c, policy, ruled, opts, result)
+		}
+	}
+
+	mergeLocalOnly(dst, src, localSrc, base, prefix, opts, result)
+}
+
+// mergeMissing adds the master value srcVal of key k, qualified as key, to
+// dst, which lacks it, unless local deleted it since the last sync and
+// masterWins is false.
+func mergeMissing(dst, base map[string]any, k, key string, srcVal any, masterWins bool, result *Result) {
+	if !masterWins && matchesBase(base, k, srcVal) {
+		// Deleted locally since the last sync while master is unchanged;
+		// keep the deletion.
+		result.KeptLocal = append(result.KeptLocal, key)
+		return
+	}
+	dst[k] = srcVal
+	result.Added = append(result.Added, key)
+}
+
+// mergeValue settles key k of dst, where master and local hold the
+// different values in c and they are not both objects. policy is the rule
+// for the key if ruled is set.
+func mergeValue(dst, base map[string]any, k string, c Conflict, policy Policy, ruled bool, opts Options, result *Result) {
+	if mergeArrayValue(dst, k, c, policy, ruled, opts, result) {
+		return
+	}
+
+	switch {
+	case ruled && policy == PolicyMasterWins:
+		dst[k] = c.MasterValue
+		result.Forced = append(result.Forced, c.Key)
+		return
+	case ruled && policy == PolicyLocalWins:
+		result.KeptLocal = append(result.KeptLocal, c.Key)
+		return
+	}
+
+	if baseVal, inBase := base[k]; opts.Base != nil && inBase {
+		switch {
+		case equal(baseVal, c.LocalValue):
+			// Local is untouched since the last sync; take master's change.
+			dst[k] = c.MasterValue
+			result.Updated = append(result.Updated, c.Key)
+			return
+		case equal(baseVal, c.MasterValue) && !opts.Force:
+			// Master is unchanged since the last sync; keep the local edit.
+			result.KeptLocal = append(result.KeptLocal, c.Key)
+			return
+		}
+	}
+
+	if v, ok := opts.Resolutions[c.Key]; ok {
+		if equal(v, c.LocalValue) {
+			result.KeptLocal = append(result.KeptLocal, c.Key)
+		} else {
+			dst[k] = v
+			result.Resolved = append(result.Resolved, c.Key)
+		}
+		return
+	}
+
+	if opts.Force {
+		dst[k] = c.MasterValue
+		result.Forced = append(result.Forced, c.Key)
+		return
+	}
+
+	result.Conflicts = append(result.Conflicts, c)
+}
+
+// mergeArrayValue merges the arrays in c under the array strategy for the
+// key, if both values are arrays and it has one. It reports whether it did.
+func mergeArrayValue(dst map[string]any, k string, c Conflict, policy Policy, ruled bool, opts Options, result *Result) bool {
+	srcArr, srcIsArr := c.MasterValue.([]any)
+	dstArr, dstIsArr := c.LocalValue.([]any)
+	strategy, hasStrategy := opts.ArrayStrategies[c.Key]
+	if ruled {
+		strategy, hasStrategy = policy.ArrayStrategy()
+	}
+	if !hasStrategy || !srcIsArr || !dstIsArr {
+		return false
+	}
+
+	merged, added := mergeArrays(dstArr, srcArr, strategy)
+	if equal(merged, dstArr) {
+		// Local already holds every master element.
+		result.Matching = append(result.Matching, c.Key)
+		return true
+	}
+	dst[k] = merged
+	switch {
+	case strategy == ArrayReplace:
+		result.Forced = append(result.Forced, c.Key)
+	case len(added) == 0:
+		result.Deduplicated = append(result.Deduplicated, c.Key)
+	}
+	if len(added) > 0 {
+		result.ArrayAdditions = append(result.ArrayAdditions, ArrayAddition{Key: c.Key, Values: added})
+	}
+	return true
+}
+
+// mergeLocalOnly records the keys of the original local object localSrc that
+// master's src lacks, removing from dst those that pruning drops.
+func mergeLocalOnly(dst, src, localSrc, base map[string]any, prefix string, opts Options, result *Result) {
+	for k, dstVal := range dst {
+		_, inMaster := src[k]
+		_, inLocal := localSrc[k]
+		if inMaster || !inLocal {
+			// Keys added from master are not local-only.
 			continue
 		}
 
-		if force {
-			dst[k] = srcVal
-			result.Forced = append(result.Forced, key)
+		key := qualifiedKey(prefix, k)
+		pattern, policy, ruled := matchRule(opts.Rules, key)
+		switch {
+		case ruled && policy == PolicyIgnore:
+			result.Ignored = append(result.Ignored, key)
+			result.Decisions = append(result.Decisions, Decision{Key: key, Pattern: pattern, Policy: policy})
+		case ruled && policy != PolicyMasterWins:
+			// Only master-wins rules let pruning remove a key.
+			result.LocalOnly = append(result.LocalOnly, key)
+		case opts.Prune && isIntroduced(opts.Introduced, key) && matchesBase(base, k, dstVal):
+			delete(dst, k)
+			result.Removed = append(result.Removed, key)
+		default:
+			result.LocalOnly = append(result.LocalOnly, key)
+		}
+	}
+}
+
+// matchRule returns the rule in rules that applies to key, trying key itself
+// before each of its parent keys.
+func matchRule(rules map[string]Policy, key string) (pattern string, policy Policy, ok bool) {
+	if len(rules) == 0 {
+		return "", "", false
+	}
+	for {
+		if pattern, ok := matchPattern(rules, key); ok {
+			return pattern, rules[pattern], true
+		}
+		i := strings.LastIndexByte(key, '.')
+		if i < 0 {
+			return "", "", false
+		}
+		key = key[:i]
+	}
+}
+
+// matchPattern returns the most specific pattern in rules that matches key:
+// the key itself if present, otherwise the longest matching glob, with ties
+// broken alphabetically so the choice does not depend on map order.
+func matchPattern(rules map[string]Policy, key string) (string, bool) {
+	if _, ok := rules[key]; ok {
+		return key, true
+	}
+	slashed := slashKey(key)
+	best, found := "", false
+	for pattern := range rules {
+		if matched, _ := path.Match(slashKey(pattern), slashed); !matched {
 			continue
 		}
+		if !found || len(pattern) > len(best) || (len(pattern) == len(best) && pattern < best) {
+			best, found = pattern, true
+		}
+	}
+	return best, found
+}
+
+// slashKey turns a dotted key path into a slash-separated one, so that
+// path.Match wildcards stop at key segment boundaries.
+func slashKey(key string) string {
+	return strings.ReplaceAll(key, ".", "/")
+}
 
-		result.Conflicts = append(result.Conflicts, Conflict{
-			Key:         key,
-			MasterValue: srcVal,
-			LocalValue:  dstVal,
-		})
+// isIntroduced reports whether key or any of its parent keys is in introduced.
+func isIntroduced(introduced map[string]bool, key string) bool {
+	for {
+		if introduced[key] {
+			return true
+		}
+		i := strings.LastIndexByte(key, '.')
+		if i < 0 {
+			return false
+		}
+		key = key[:i]
 	}
+}
 
-	// Find keys in local not present in master.
-	for k := range dst {
+// matchesBase reports whether base records v for k, i.e. the local value has
+// not been edited since master last supplied it.
+func matchesBase(base map[string]any, k string, v any) bool {
+	baseVal, ok := base[k]
+	return ok && equal(baseVal, v)
+}
+
+// mergeArrays combines the master array src into the local array dst using
+// strategy. It returns the resulting array and the master elements that were
+// not present in dst. ArrayReplace yields src whenever it differs from dst,
+// and ArrayUnion drops the duplicates in dst, even if they add nothing.
+// Otherwise an unchanged dst is returned as is.
+func mergeArrays(dst, src []any, strategy ArrayStrategy) (merged, added []any) {
+	for _, v := range src {
+		if !containsValue(dst, v) && !containsValue(added, v) {
+			added = append(added, v)
+		}
+	}
+
+	switch strategy {
+	case ArrayReplace:
+		if equal(dst, src) {
+			return dst, nil
+		}
+		return append([]any(nil), src...), added
+	case ArrayUnion:
+		for _, v := range dst {
+			if !containsValue(merged, v) {
+				merged = append(merged, v)
+			}
+		}
+		if len(added) == 0 && len(merged) == len(dst) {
+			return dst, nil
+		}
+		return append(merged, added...), added
+	default: // ArrayAppend
+		if len(added) == 0 {
+			return dst, nil
+		}
+		return append(append([]any(nil), dst...), added...), added
+	}
+}
+
+// containsValue reports whether vs holds an element deeply equal to v.
+func containsValue(vs []any, v any) bool {
+	for _, e := range vs {
+		if equal(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Shadow is a key set in two settings files read in order, where the value
+// in the upper file takes effect and the lower one is hidden.
+type Shadow struct {
+	Key        string
+	LowerValue any
+	UpperValue any
+}
+
+// Same reports whether both files set the key to the same value.
+func (s Shadow) Same() bool {
+	return equal(s.LowerValue, s.UpperValue)
+}
+
+// Shadowed returns the keys set in both lower and upper, sorted by key.
+// Objects in both are compared key by key, so only the keys inside them that
+// both set are returned; any other value, including an array, is one key.
+func Shadowed(lower, upper map[string]any) []Shadow {
+	var out []Shadow
+	shadowed(lower, upper, "", &out)
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+func shadowed(lower, upper map[string]any, prefix string, out *[]Shadow) {
+	for k, uv := range upper {
+		lv, ok := lower[k]
+		if !ok {
+			continue
+		}
 		key := qualifiedKey(prefix, k)
-		if _, inMaster := src[k]; !inMaster {
-			// Only record if this key existed in the original local (not added from master).
-			if localSrc != nil {
-				if _, inLocal := localSrc[k]; inLocal {
-					result.LocalOnly = append(result.LocalOnly, key)
-				}
+		lm, lok := lv.(map[string]any)
+		um, uok := uv.(map[string]any)
+		if lok && uok {
+			shadowed(lm, um, key, out)
+			continue
+		}
+		*out = append(*out, Shadow{Key: key, LowerValue: lv, UpperValue: uv})
+	}
+}
+
+// equal reports whether two decoded JSON values are the same. Numbers decoded
+// as json.Number compare by numeric value, so 1.0 equals 1 while each keeps
+// its original text.
+func equal(a, b any) bool {
+	switch av := a.(type) {
+	case json.Number:
+		bv, ok := b.(json.Number)
+		return ok && equalNumbers(av, bv)
+	case map[string]any:
+		bv, ok := b.(map[string]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			w, ok := bv[k]
+			if !ok || !equal(v, w) {
+				return false
 			}
 		}
+		return true
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+// equalNumbers reports whether a and b hold the same numeric value.
+func equalNumbers(a, b json.Number) bool {
+	if a == b {
+		return true
 	}
+	ar, aok := new(big.Rat).SetString(string(a))
+	br, bok := new(big.Rat).SetString(string(b))
+	return aok && bok && ar.Cmp(br) == 0
 }
 
 func qualifiedKey(prefix, key string) string {
@@ -1,6 +1,8 @@
 package merge
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
 )
 
@@ -8,7 +10,7 @@ func TestMerge_AddsNewKeys(t *testing.T) {
 	master := map[string]any{"newKey": "value", "another": 42.0}
 	local := map[string]any{"existingKey": "local"}
 
-	result := Merge(master, local, false)
+	result := Merge(master, local, Options{})
 
 	if result.Merged["newKey"] != "value" {
 		t.Errorf("newKey = %v; want %q", result.Merged["newKey"], "value")
@@ -28,7 +30,7 @@ func TestMerge_KeepsLocalOnConflict(t *testing.T) {
 	master := map[string]any{"key": "master-value"}
 	local := map[string]any{"key": "local-value"}
 
-	result := Merge(master, local, false)
+	result := Merge(master, local, Options{})
 
 	if result.Merged["key"] != "local-value" {
 		t.Errorf("key = %v; want %q", result.Merged["key"], "local-value")
@@ -42,7 +44,7 @@ func TestMerge_ReportsConflicts(t *testing.T) {
 	master := map[string]any{"key": "master-value"}
 	local := map[string]any{"key": "local-value"}
 
-	result := Merge(master, local, false)
+	result := Merge(master, local, Options{})
 
 	if len(result.Conflicts) != 1 {
 		t.Fatalf("Conflicts = %d; want 1", len(result.Conflicts))
@@ -73,7 +75,7 @@ func TestMerge_DeepMergesNestedObjects(t *testing.T) {
 		},
 	}
 
-	result := Merge(master, local, false)
+	result := Merge(master, local, Options{})
 
 	nested, ok := result.Merged["nested"].(map[string]any)
 	if !ok {
@@ -96,11 +98,31 @@ func TestMerge_DeepMergesNestedObjects(t *testing.T) {
 	}
 }
 
+func TestMerge_LeavesLocalUntouched(t *testing.T) {
+	master := map[string]any{
+		"nested": map[string]any{"added": "yes", "shared": "master"},
+	}
+	local := map[string]any{
+		"nested": map[string]any{"shared": "local"},
+	}
+
+	result := Merge(master, local, Options{Force: true})
+
+	want := map[string]any{"nested": map[string]any{"shared": "local"}}
+	if !reflect.DeepEqual(local, want) {
+		t.Errorf("local = %v; want %v (unchanged)", local, want)
+	}
+	nested, _ := result.Merged["nested"].(map[string]any)
+	if nested["added"] != "yes" || nested["shared"] != "master" {
+		t.Errorf("Merged.nested = %v; want added and shared from master", nested)
+	}
+}
+
 func TestMerge_EmptyMaster(t *testing.T) {
 	master := map[string]any{}
 	local := map[string]any{"key": "value"}
 
-	result := Merge(master, local, false)
+	result := Merge(master, local, Options{})
 
 	if result.Merged["key"] != "value" {
 		t.Errorf("key = %v; want %q", result.Merged["key"], "value")
@@ -114,7 +136,7 @@ func TestMerge_EmptyLocal(t *testing.T) {
 	master := map[string]any{"key": "value"}
 	local := map[string]any{}
 
-	result := Merge(master, local, false)
+	result := Merge(master, local, Options{})
 
 	if result.Merged["key"] != "value" {
 		t.Errorf("key = %v; want %q", result.Merged["key"], "value")
@@ -128,7 +150,7 @@ func TestMerge_MatchingKeysNotConflicts(t *testing.T) {
 	master := map[string]any{"key": "same"}
 	local := map[string]any{"key": "same"}
 
-	result := Merge(master, local, false)
+	result := Merge(master, local, Options{})
 
 	if len(result.Conflicts) != 0 {
 		t.Errorf("Conflicts = %d; want 0 (identical values are not conflicts)", len(result.Conflicts))
@@ -146,7 +168,7 @@ func TestMerge_MatchingSlicesNotConflicts(t *testing.T) {
 	master := map[string]any{"key": val}
 	local := map[string]any{"key": val}
 
-	result := Merge(master, local, false)
+	result := Merge(master, local, Options{})
 
 	if len(result.Conflicts) != 0 {
 		t.Errorf("Conflicts = %d; want 0 (identical slices are not conflicts)", len(result.Conflicts))
@@ -160,7 +182,7 @@ func TestMerge_LocalOnlyKeys(t *testing.T) {
 	master := map[string]any{"masterKey": "value"}
 	local := map[string]any{"masterKey": "value", "localOnly": "mine"}
 
-	result := Merge(master, local, false)
+	result := Merge(master, local, Options{})
 
 	if len(result.LocalOnly) != 1 {
 		t.Fatalf("LocalOnly = %d; want 1", len(result.LocalOnly))
@@ -178,7 +200,7 @@ func TestMerge_NestedLocalOnlyKeys(t *testing.T) {
 		"nested": map[string]any{"localOnlyNested": "v"},
 	}
 
-	result := Merge(master, local, false)
+	result := Merge(master, local, Options{})
 
 	// "nested.localOnlyNested" exists only in local — must appear in LocalOnly.
 	found := false
@@ -210,7 +232,7 @@ func TestMerge_ScalarVsObjectConflict(t *testing.T) {
 	master := map[string]any{"key": map[string]any{"nested": "val"}}
 	local := map[string]any{"key": "scalar"}
 
-	result := Merge(master, local, false)
+	result := Merge(master, local, Options{})
 
 	if result.Merged["key"] != "scalar" {
 		t.Errorf("key = %v; want %q", result.Merged["key"], "scalar")
@@ -224,7 +246,7 @@ func TestMerge_ForceOverwritesConflicts(t *testing.T) {
 	master := map[string]any{"key": "master-value"}
 	local := map[string]any{"key": "local-value"}
 
-	result := Merge(master, local, true)
+	result := Merge(master, local, Options{Force: true})
 
 	if result.Merged["key"] != "master-value" {
 		t.Errorf("key = %v; want %q (master must win with force)", result.Merged["key"], "master-value")
@@ -244,7 +266,7 @@ func TestMerge_ForceDoesNotAffectAdded(t *testing.T) {
 	master := map[string]any{"newKey": "from-master"}
 	local := map[string]any{"existingKey": "local"}
 
-	result := Merge(master, local, true)
+	result := Merge(master, local, Options{Force: true})
 
 	if result.Merged["newKey"] != "from-master" {
 		t.Errorf("newKey = %v; want %q", result.Merged["newKey"], "from-master")
@@ -259,3 +281,591 @@ func TestMerge_ForceDoesNotAffectAdded(t *testing.T) {
 		t.Errorf("Forced = %d; want 0 (no conflicts to force)", len(result.Forced))
 	}
 }
+
+func TestMerge_ArrayUnionAddsMissingElements(t *testing.T) {
+	master := map[string]any{"permissions": map[string]any{"allow": []any{"a", "b", "c"}}}
+	local := map[string]any{"permissions": map[string]any{"allow": []any{"mine", "a", "mine"}}}
+
+	result := Merge(master, local, Options{
+		ArrayStrategies: map[string]ArrayStrategy{"permissions.allow": ArrayUnion},
+	})
+
+	allow := result.Merged["permissions"].(map[string]any)["allow"].([]any)
+	want := []any{"mine", "a", "b", "c"}
+	if !reflect.DeepEqual(allow, want) {
+		t.Errorf("permissions.allow = %v; want %v", allow, want)
+	}
+	if len(result.Conflicts) != 0 {
+		t.Errorf("Conflicts = %d; want 0", len(result.Conflicts))
+	}
+	if len(result.ArrayAdditions) != 1 {
+		t.Fatalf("ArrayAdditions = %d; want 1", len(result.ArrayAdditions))
+	}
+	add := result.ArrayAdditions[0]
+	if add.Key != "permissions.allow" {
+		t.Errorf("ArrayAdditions[0].Key = %q; want %q", add.Key, "permissions.allow")
+	}
+	if !reflect.DeepEqual(add.Values, []any{"b", "c"}) {
+		t.Errorf("ArrayAdditions[0].Values = %v; want [b c]", add.Values)
+	}
+	if !result.Changed() {
+		t.Error("Changed() = false; want true")
+	}
+}
+
+func TestMerge_ArrayAppendKeepsLocalVerbatim(t *testing.T) {
+	master := map[string]any{"list": []any{"a", "b"}}
+	local := map[string]any{"list": []any{"x", "x"}}
+
+	result := Merge(master, local, Options{
+		ArrayStrategies: map[string]ArrayStrategy{"list": ArrayAppend},
+	})
+
+	want := []any{"x", "x", "a", "b"}
+	if !reflect.DeepEqual(result.Merged["list"], want) {
+		t.Errorf("list = %v; want %v", result.Merged["list"], want)
+	}
+}
+
+func TestMerge_ArrayReplaceUsesMaster(t *testing.T) {
+	master := map[string]any{"list": []any{"a"}}
+	local := map[string]any{"list": []any{"x"}}
+
+	result := Merge(master, local, Options{
+		ArrayStrategies: map[string]ArrayStrategy{"list": ArrayReplace},
+	})
+
+	if !reflect.DeepEqual(result.Merged["list"], []any{"a"}) {
+		t.Errorf("list = %v; want [a]", result.Merged["list"])
+	}
+	if len(result.Forced) != 1 || result.Forced[0] != "list" {
+		t.Errorf("Forced = %v; want [list]", result.Forced)
+	}
+}
+
+func TestMerge_ArrayReplaceDropsLocalExtras(t *testing.T) {
+	master := map[string]any{"list": []any{"a"}}
+	local := map[string]any{"list": []any{"a", "b"}}
+
+	result := Merge(master, local, Options{
+		ArrayStrategies: map[string]ArrayStrategy{"list": ArrayReplace},
+	})
+
+	if !reflect.DeepEqual(result.Merged["list"], []any{"a"}) {
+		t.Errorf("list = %v; want [a]", result.Merged["list"])
+	}
+	if len(result.Forced) != 1 || len(result.Matching) != 0 {
+		t.Errorf("Forced = %v, Matching = %v; want list forced", result.Forced, result.Matching)
+	}
+	if len(result.ArrayAdditions) != 0 {
+		t.Errorf("ArrayAdditions = %v; want none, master adds nothing", result.ArrayAdditions)
+	}
+}
+
+func TestMerge_ArrayStrategyNothingNewIsMatching(t *testing.T) {
+	master := map[string]any{"list": []any{"a"}}
+	local := map[string]any{"list": []any{"a", "mine"}}
+
+	result := Merge(master, local, Options{
+		ArrayStrategies: map[string]ArrayStrategy{"list": ArrayUnion},
+	})
+
+	if len(result.Matching) != 1 {
+		t.Errorf("Matching = %v; want [list]", result.Matching)
+	}
+	if result.Changed() {
+		t.Error("Changed() = true; want false when local already has every master element")
+	}
+}
+
+func TestMerge_ArrayUnionDropsDuplicatesWhenNothingIsAdded(t *testing.T) {
+	master := map[string]any{"list": []any{"a"}}
+	local := map[string]any{"list": []any{"a", "mine", "a"}}
+
+	result := Merge(master, local, Options{
+		ArrayStrategies: map[string]ArrayStrategy{"list": ArrayUnion},
+	})
+
+	want := []any{"a", "mine"}
+	if !reflect.DeepEqual(result.Merged["list"], want) {
+		t.Errorf("list = %v; want %v", result.Merged["list"], want)
+	}
+	if !reflect.DeepEqual(result.Deduplicated, []string{"list"}) {
+		t.Errorf("Deduplicated = %v; want [list]", result.Deduplicated)
+	}
+	if len(result.ArrayAdditions) != 0 || len(result.Matching) != 0 {
+		t.Errorf("ArrayAdditions = %v, Matching = %v; want neither", result.ArrayAdditions, result.Matching)
+	}
+	if !result.Changed() {
+		t.Error("Changed() = false; want true")
+	}
+}
+
+func TestMerge_ArrayWithoutStrategyIsConflict(t *testing.T) {
+	master := map[string]any{"list": []any{"a"}}
+	local := map[string]any{"list": []any{"x"}}
+
+	result := Merge(master, local, Options{
+		ArrayStrategies: map[string]ArrayStrategy{"other": ArrayUnion},
+	})
+
+	if len(result.Conflicts) != 1 {
+		t.Errorf("Conflicts = %d; want 1", len(result.Conflicts))
+	}
+}
+
+func TestParseArrayStrategy(t *testing.T) {
+	for _, s := range []string{"union", "append", "replace"} {
+		if _, err := ParseArrayStrategy(s); err != nil {
+			t.Errorf("ParseArrayStrategy(%q) error: %v", s, err)
+		}
+	}
+	if _, err := ParseArrayStrategy("merge"); err == nil {
+		t.Error("ParseArrayStrategy(\"merge\") = nil error; want error")
+	}
+}
+
+func TestMerge_ThreeWayUpdatesMasterOnlyChanges(t *testing.T) {
+	base := map[string]any{"model": "old", "nested": map[string]any{"k": "old"}}
+	master := map[string]any{"model": "new", "nested": map[string]any{"k": "new"}}
+	local := map[string]any{"model": "old", "nested": map[string]any{"k": "old"}}
+
+	result := Merge(master, local, Options{Base: base})
+
+	if result.Merged["model"] != "new" {
+		t.Errorf("model = %v; want %q", result.Merged["model"], "new")
+	}
+	if result.Merged["nested"].(map[string]any)["k"] != "new" {
+		t.Errorf("nested.k = %v; want %q", result.Merged["nested"].(map[string]any)["k"], "new")
+	}
+	if !reflect.DeepEqual(result.Updated, []string{"model", "nested.k"}) {
+		t.Errorf("Updated = %v; want [model nested.k]", result.Updated)
+	}
+	if len(result.Conflicts) != 0 {
+		t.Errorf("Conflicts = %d; want 0", len(result.Conflicts))
+	}
+	if !result.Changed() {
+		t.Error("Changed() = false; want true")
+	}
+}
+
+func TestMerge_ThreeWayKeepsLocalOnlyChanges(t *testing.T) {
+	base := map[string]any{"theme": "dark"}
+	master := map[string]any{"theme": "dark"}
+	local := map[string]any{"theme": "light"}
+
+	result := Merge(master, local, Options{Base: base})
+
+	if result.Merged["theme"] != "light" {
+		t.Errorf("theme = %v; want %q (local edit kept)", result.Merged["theme"], "light")
+	}
+	if !reflect.DeepEqual(result.KeptLocal, []string{"theme"}) {
+		t.Errorf("KeptLocal = %v; want [theme]", result.KeptLocal)
+	}
+	if len(result.Forced) != 0 {
+		t.Errorf("Forced = %v; want empty", result.Forced)
+	}
+
+	// Force lets master win over the local edit.
+	result = Merge(master, local, Options{Base: base, Force: true})
+	if result.Merged["theme"] != "dark" {
+		t.Errorf("theme = %v; want %q with force", result.Merged["theme"], "dark")
+	}
+	if !reflect.DeepEqual(result.Forced, []string{"theme"}) {
+		t.Errorf("Forced = %v; want [theme]", result.Forced)
+	}
+	if len(result.KeptLocal) != 0 {
+		t.Errorf("KeptLocal = %v; want empty with force", result.KeptLocal)
+	}
+}
+
+func TestMerge_ThreeWayKeepsLocalDeletion(t *testing.T) {
+	base := map[string]any{"model": "opus", "x": 1.0, "y": 1.0}
+	master := map[string]any{"model": "opus", "x": 1.0, "y": 2.0, "z": 1.0}
+	local := map[string]any{"model": "opus"}
+
+	result := Merge(master, local, Options{Base: base})
+
+	// x was deleted locally and is unchanged in master; y changed in master
+	// and z is new, so both are added.
+	if _, ok := result.Merged["x"]; ok {
+		t.Errorf("x = %v; want the local deletion kept", result.Merged["x"])
+	}
+	if !reflect.DeepEqual(result.KeptLocal, []string{"x"}) {
+		t.Errorf("KeptLocal = %v; want [x]", result.KeptLocal)
+	}
+	if !reflect.DeepEqual(result.Added, []string{"y", "z"}) {
+		t.Errorf("Added = %v; want [y z]", result.Added)
+	}
+
+	// A master-wins rule restores the key.
+	result = Merge(master, local, Options{Base: base, Rules: map[string]Policy{"x": PolicyMasterWins}})
+	if result.Merged["x"] != 1.0 {
+		t.Errorf("x = %v; want it re-added by the master-wins rule", result.Merged["x"])
+	}
+
+	// So does force, unless a rule says otherwise.
+	result = Merge(master, local, Options{Base: base, Force: true})
+	if result.Merged["x"] != 1.0 {
+		t.Errorf("x = %v; want it re-added with force", result.Merged["x"])
+	}
+	result = Merge(master, local, Options{Base: base, Force: true, Rules: map[string]Policy{"x": PolicyLocalWins}})
+	if _, ok := result.Merged["x"]; ok {
+		t.Errorf("x = %v; want the local deletion kept by the local-wins rule", result.Merged["x"])
+	}
+}
+
+func TestMerge_ThreeWayBothChangedIsConflict(t *testing.T) {
+	base := map[string]any{"model": "base"}
+	master := map[string]any{"model": "master", "extra": "x"}
+	local := map[string]any{"model": "local", "extra": "y"}
+
+	result := Merge(master, local, Options{Base: base})
+
+	// model changed on both sides; extra is absent from base so both sides
+	// introduced it independently.
+	if len(result.Conflicts) != 2 {
+		t.Fatalf("Conflicts = %v; want 2", result.Conflicts)
+	}
+	if result.Merged["model"] != "local" {
+		t.Errorf("model = %v; want %q", result.Merged["model"], "local")
+	}
+}
+
+func TestMerge_PruneRemovesIntroducedKeysDroppedFromMaster(t *testing.T) {
+	base := map[string]any{"old": "v", "nested": map[string]any{"gone": 1.0, "kept": 2.0}}
+	master := map[string]any{"nested": map[string]any{"kept": 2.0}}
+	local := map[string]any{
+		"old":    "v",
+		"mine":   "user",
+		"nested": map[string]any{"gone": 1.0, "kept": 2.0},
+	}
+
+	result := Merge(master, local, Options{
+		Base:       base,
+		Prune:      true,
+		Introduced: map[string]bool{"old": true, "nested": true},
+	})
+
+	if _, ok := result.Merged["old"]; ok {
+		t.Error("old still present; want removed")
+	}
+	if _, ok := result.Merged["nested"].(map[string]any)["gone"]; ok {
+		t.Error("nested.gone still present; want removed (parent was introduced)")
+	}
+	if result.Merged["mine"] != "user" {
+		t.Errorf("mine = %v; want user (user-added keys are never removed)", result.Merged["mine"])
+	}
+	if !reflect.DeepEqual(result.Removed, []string{"nested.gone", "old"}) {
+		t.Errorf("Removed = %v; want [nested.gone old]", result.Removed)
+	}
+	if !reflect.DeepEqual(result.LocalOnly, []string{"mine"}) {
+		t.Errorf("LocalOnly = %v; want [mine]", result.LocalOnly)
+	}
+	if !result.Changed() {
+		t.Error("Changed() = false; want true")
+	}
+}
+
+func TestMerge_PruneKeepsLocallyEditedKeys(t *testing.T) {
+	base := map[string]any{"old": "v"}
+	master := map[string]any{}
+	local := map[string]any{"old": "edited"}
+
+	result := Merge(master, local, Options{
+		Base:       base,
+		Prune:      true,
+		Introduced: map[string]bool{"old": true},
+	})
+
+	if result.Merged["old"] != "edited" {
+		t.Errorf("old = %v; want edited (local edits are never removed)", result.Merged["old"])
+	}
+	if len(result.Removed) != 0 {
+		t.Errorf("Removed = %v; want empty", result.Removed)
+	}
+}
+
+func TestMerge_WithoutPruneKeepsIntroducedKeys(t *testing.T) {
+	base := map[string]any{"old": "v"}
+	master := map[string]any{}
+	local := map[string]any{"old": "v"}
+
+	result := Merge(master, local, Options{
+		Base:       base,
+		Introduced: map[string]bool{"old": true},
+	})
+
+	if result.Merged["old"] != "v" {
+		t.Errorf("old = %v; want v", result.Merged["old"])
+	}
+	if !reflect.DeepEqual(result.LocalOnly, []string{"old"}) {
+		t.Errorf("LocalOnly = %v; want [old]", result.LocalOnly)
+	}
+}
+
+func TestMerge_NumbersCompareByValue(t *testing.T) {
+	master := map[string]any{"timeout": json.Number("1.0"), "limit": json.Number("12345678901234567890")}
+	local := map[string]any{"timeout": json.Number("1"), "limit": json.Number("12345678901234567891")}
+
+	result := Merge(master, local, Options{})
+
+	if !reflect.DeepEqual(result.Matching, []string{"timeout"}) {
+		t.Errorf("Matching = %v; want [timeout]", result.Matching)
+	}
+	if len(result.Conflicts) != 1 || result.Conflicts[0].Key != "limit" {
+		t.Errorf("Conflicts = %v; want one conflict on limit", result.Conflicts)
+	}
+	if result.Merged["timeout"] != json.Number("1") {
+		t.Errorf("timeout = %v; want local text 1 kept", result.Merged["timeout"])
+	}
+}
+
+func TestMerge_ResolutionsSettleConflicts(t *testing.T) {
+	master := map[string]any{"a": "master-a", "b": "master-b", "c": "master-c"}
+	local := map[string]any{"a": "local-a", "b": "local-b", "c": "local-c"}
+
+	result := Merge(master, local, Options{
+		Resolutions: map[string]any{"a": "master-a", "b": "local-b", "c": "edited"},
+	})
+
+	if result.Merged["a"] != "master-a" || result.Merged["b"] != "local-b" || result.Merged["c"] != "edited" {
+		t.Errorf("Merged = %v; want a=master-a b=local-b c=edited", result.Merged)
+	}
+	if !reflect.DeepEqual(result.Resolved, []string{"a", "c"}) {
+		t.Errorf("Resolved = %v; want [a c]", result.Resolved)
+	}
+	if !reflect.DeepEqual(result.KeptLocal, []string{"b"}) {
+		t.Errorf("KeptLocal = %v; want [b]", result.KeptLocal)
+	}
+	if len(result.Conflicts) != 0 {
+		t.Errorf("Conflicts = %v; want none", result.Conflicts)
+	}
+}
+
+func TestMerge_RulesApplyPolicies(t *testing.T) {
+	base := map[string]any{"theme": "dark", "model": "opus"}
+	master := map[string]any{"model": "opus-next", "theme": "light", "newTheme": "x", "env": map[string]any{"A": "m", "B": "m"}}
+	local := map[string]any{"model": "sonnet", "theme": "dark", "env": map[string]any{"A": "l", "B": "l"}}
+
+	result := Merge(master, local, Options{
+		Base: base,
+		Rules: map[string]Policy{
+			"model":   PolicyMasterWins,
+			"*Theme":  PolicyIgnore,
+			"theme":   PolicyIgnore,
+			"env":     PolicyLocalWins,
+			"env.B":   PolicyMasterWins,
+			"unknown": PolicyIgnore,
+		},
+	})
+
+	if result.Merged["model"] != "opus-next" {
+		t.Errorf("model = %v; want opus-next", result.Merged["model"])
+	}
+	if result.Merged["theme"] != "dark" {
+		t.Errorf("theme = %v; want dark (ignored, not updated from master)", result.Merged["theme"])
+	}
+	if _, ok := result.Merged["newTheme"]; ok {
+		t.Errorf("newTheme was added; want ignored")
+	}
+	env := result.Merged["env"].(map[string]any)
+	if env["A"] != "l" || env["B"] != "m" {
+		t.Errorf("env = %v; want A=l (local-wins) B=m (master-wins)", env)
+	}
+	if len(result.Conflicts) != 0 {
+		t.Errorf("Conflicts = %v; want none", result.Conflicts)
+	}
+	if !reflect.DeepEqual(result.Ignored, []string{"newTheme", "theme"}) {
+		t.Errorf("Ignored = %v; want [newTheme theme]", result.Ignored)
+	}
+
+	want := []Decision{
+		{Key: "env.A", Pattern: "env", Policy: PolicyLocalWins},
+		{Key: "env.B", Pattern: "env.B", Policy: PolicyMasterWins},
+		{Key: "model", Pattern: "model", Policy: PolicyMasterWins},
+		{Key: "newTheme", Pattern: "*Theme", Policy: PolicyIgnore},
+		{Key: "theme", Pattern: "theme", Policy: PolicyIgnore},
+	}
+	if !reflect.DeepEqual(result.Decisions, want) {
+		t.Errorf("Decisions = %v; want %v", result.Decisions, want)
+	}
+}
+
+func TestMerge_RulesRecordDecisionForEveryOutcome(t *testing.T) {
+	master := map[string]any{"model": "opus", "theme": "dark", "env": map[string]any{"A": "1"}}
+	local := map[string]any{"model": "opus", "env": map[string]any{"A": "1"}}
+
+	result := Merge(master, local, Options{Rules: map[string]Policy{
+		"model": PolicyMasterWins,
+		"theme": PolicyLocalWins,
+		"env":   PolicyMasterWins,
+	}})
+
+	want := []Decision{
+		{Key: "env.A", Pattern: "env", Policy: PolicyMasterWins},
+		{Key: "model", Pattern: "model", Policy: PolicyMasterWins},
+		{Key: "theme", Pattern: "theme", Policy: PolicyLocalWins},
+	}
+	if !reflect.DeepEqual(result.Decisions, want) {
+		t.Errorf("Decisions = %v; want %v (matching and added keys included)", result.Decisions, want)
+	}
+}
+
+func TestMerge_RulesOverrideForce(t *testing.T) {
+	master := map[string]any{"theme": "light", "model": "opus"}
+	local := map[string]any{"theme": "dark", "model": "sonnet"}
+
+	result := Merge(master, local, Options{Force: true, Rules: map[string]Policy{"theme": PolicyLocalWins}})
+
+	if result.Merged["theme"] != "dark" {
+		t.Errorf("theme = %v; want dark", result.Merged["theme"])
+	}
+	if result.Merged["model"] != "opus" {
+		t.Errorf("model = %v; want opus (forced)", result.Merged["model"])
+	}
+}
+
+func TestMerge_GlobRuleSelectsArrayStrategy(t *testing.T) {
+	master := map[string]any{"permissions": map[string]any{"allow": []any{"a", "b"}, "deny": []any{"x"}}}
+	local := map[string]any{"permissions": map[string]any{"allow": []any{"a"}, "deny": []any{"y"}}}
+
+	result := Merge(master, local, Options{Rules: map[string]Policy{"permissions.*": "union"}})
+
+	perms := result.Merged["permissions"].(map[string]any)
+	if !reflect.DeepEqual(perms["allow"], []any{"a", "b"}) {
+		t.Errorf("allow = %v; want [a b]", perms["allow"])
+	}
+	if !reflect.DeepEqual(perms["deny"], []any{"y", "x"}) {
+		t.Errorf("deny = %v; want [y x]", perms["deny"])
+	}
+	if len(result.Decisions) != 2 || result.Decisions[0].Pattern != "permissions.*" {
+		t.Errorf("Decisions = %v; want two decisions by permissions.*", result.Decisions)
+	}
+}
+
+func TestMerge_IgnoreRuleBlocksPrune(t *testing.T) {
+	base := map[string]any{"old": "v"}
+	local := map[string]any{"old": "v"}
+
+	result := Merge(map[string]any{}, local, Options{
+		Base:       base,
+		Prune:      true,
+		Introduced: map[string]bool{"old": true},
+		Rules:      map[string]Policy{"old": PolicyIgnore},
+	})
+
+	if result.Merged["old"] != "v" {
+		t.Errorf("old was removed; want kept by ignore rule")
+	}
+	if len(result.Removed) != 0 {
+		t.Errorf("Removed = %v; want none", result.Removed)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, s := range []string{"master-wins", "local-wins", "ignore", "union", "append", "replace"} {
+		if _, err := ParsePolicy(s); err != nil {
+			t.Errorf("ParsePolicy(%q) error: %v", s, err)
+		}
+	}
+	if _, err := ParsePolicy("newest"); err == nil {
+		t.Error("ParsePolicy(newest) succeeded; want error")
+	}
+	if err := ValidatePattern("env.[a"); err == nil {
+		t.Error("ValidatePattern(env.[a) succeeded; want error")
+	}
+}
+
+func TestOverlay_LaterLayersWin(t *testing.T) {
+	company := map[string]any{
+		"model": "sonnet",
+		"env":   map[string]any{"A": "1", "B": "1"},
+		"permissions": map[string]any{
+			"allow": []any{"Bash(ls)"},
+			"deny":  []any{"Bash(rm)"},
+		},
+	}
+	team := map[string]any{
+		"env": map[string]any{"B": "2"},
+		"permissions": map[string]any{
+			"allow": []any{"Bash(git)"},
+			"deny":  []any{"Bash(curl)"},
+		},
+	}
+	personal := map[string]any{"model": "opus"}
+
+	merged, origins := Overlay([]map[string]any{company, team, personal},
+		map[string]ArrayStrategy{"permissions.allow": ArrayUnion})
+
+	want := map[string]any{
+		"model": "opus",
+		"env":   map[string]any{"A": "1", "B": "2"},
+		"permissions": map[string]any{
+			"allow": []any{"Bash(ls)", "Bash(git)"},
+			"deny":  []any{"Bash(curl)"},
+		},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("merged = %v; want %v", merged, want)
+	}
+	wantOrigins := map[string]int{
+		"model":             2,
+		"env.A":             0,
+		"env.B":             1,
+		"permissions.allow": 1,
+		"permissions.deny":  1,
+	}
+	if !reflect.DeepEqual(origins, wantOrigins) {
+		t.Errorf("origins = %v; want %v", origins, wantOrigins)
+	}
+	if company["model"] != "sonnet" || len(company["env"].(map[string]any)) != 2 {
+		t.Errorf("Overlay modified a layer: %v", company)
+	}
+}
+
+func TestOverlay_ValueReplacesObject(t *testing.T) {
+	merged, origins := Overlay([]map[string]any{
+		{"hooks": map[string]any{"pre": "a"}},
+		{"hooks": "none"},
+	}, nil)
+
+	if merged["hooks"] != "none" {
+		t.Errorf("hooks = %v; want none", merged["hooks"])
+	}
+	if want := map[string]int{"hooks": 1}; !reflect.DeepEqual(origins, want) {
+		t.Errorf("origins = %v; want %v", origins, want)
+	}
+}
+
+func TestShadowed_KeysSetInBoth(t *testing.T) {
+	lower := map[string]any{
+		"model":       "opus",
+		"theme":       "dark",
+		"env":         map[string]any{"A": "1", "B": "2"},
+		"permissions": map[string]any{"allow": []any{"Bash(ls)"}},
+		"hooks":       map[string]any{"x": "y"},
+	}
+	upper := map[string]any{
+		"model":       "sonnet",
+		"env":         map[string]any{"B": "2", "C": "3"},
+		"permissions": map[string]any{"allow": []any{"Bash(make)"}},
+		"hooks":       "none",
+	}
+
+	got := Shadowed(lower, upper)
+	var keys []string
+	for _, s := range got {
+		keys = append(keys, s.Key)
+	}
+	want := []string{"env.B", "hooks", "model", "permissions.allow"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("Shadowed keys = %v; want %v", keys, want)
+	}
+	if !got[0].Same() || got[2].Same() {
+		t.Errorf("Same() = %v for env.B and %v for model; want true and false", got[0].Same(), got[2].Same())
+	}
+	if got[2].LowerValue != "opus" || got[2].UpperValue != "sonnet" {
+		t.Errorf("model shadow = %+v; want opus below sonnet", got[2])
+	}
+}
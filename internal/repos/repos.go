@@ -0,0 +1,138 @@
+// Package repos finds the project repositories under workspace roots.
+package repos
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultMaxDepth is how many directory levels below a root Find searches
+// when Options.MaxDepth is zero.
+const DefaultMaxDepth = 3
+
+// Options controls which directories Find reports.
+type Options struct {
+	// MaxDepth is how many levels below each root to search; 0 means
+	// DefaultMaxDepth.
+	MaxDepth int
+	// Include, when non-empty, limits the result to repositories matching
+	// one of these globs.
+	Include []string
+	// Exclude lists globs for directories not to search or report.
+	Exclude []string
+}
+
+// ValidatePattern reports whether pattern is a well-formed glob.
+func ValidatePattern(pattern string) error {
+	if pattern == "" {
+		return errors.New("empty pattern")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// Find returns the repositories under roots, in walk order and without
+// duplicates: every directory that holds a .claude directory or a .git entry,
+// including a root itself. Find does not search inside a repository, hidden
+// directories, or directories matching opts.Exclude, nor follow symlinks.
+//
+// A glob containing "/" is matched against the directory's slash-separated
+// path relative to its root; any other glob against its base name.
+func Find(roots []string, opts Options) ([]string, error) {
+	f := &finder{opts: opts, maxDepth: opts.MaxDepth, seen: map[string]bool{}}
+	if f.maxDepth == 0 {
+		f.maxDepth = DefaultMaxDepth
+	}
+
+	for _, root := range roots {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, fmt.Errorf("workspace root: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("workspace root %s is not a directory", root)
+		}
+		f.root = root
+		if err := filepath.WalkDir(root, f.visit); err != nil {
+			return nil, fmt.Errorf("searching %s: %w", root, err)
+		}
+	}
+	return f.found, nil
+}
+
+// finder holds the state of one Find call.
+type finder struct {
+	opts     Options
+	maxDepth int
+	root     string // the root being walked
+	seen     map[string]bool
+	found    []string
+}
+
+// visit is the filepath.WalkDir callback that checks one entry under the
+// root.
+func (f *finder) visit(p string, d fs.DirEntry, err error) error {
+	if err != nil {
+		if p == f.root {
+			return err
+		}
+		// Leave out what cannot be read rather than give up on the rest of
+		// the workspace.
+		return nil
+	}
+	if !d.IsDir() {
+		return nil
+	}
+	rel, err := filepath.Rel(f.root, p)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+	if p != f.root && (strings.HasPrefix(d.Name(), ".") || matchAny(f.opts.Exclude, rel, d.Name())) {
+		return filepath.SkipDir
+	}
+
+	if isRepo(p) {
+		if !f.seen[p] && (len(f.opts.Include) == 0 || matchAny(f.opts.Include, rel, filepath.Base(p))) {
+			f.seen[p] = true
+			f.found = append(f.found, p)
+		}
+		return filepath.SkipDir
+	}
+	if p != f.root && strings.Count(rel, "/")+1 >= f.maxDepth {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// isRepo reports whether dir holds a .claude directory or a .git entry,
+// which is a file in worktrees and submodules.
+func isRepo(dir string) bool {
+	if info, err := os.Stat(filepath.Join(dir, ".claude")); err == nil && info.IsDir() {
+		return true
+	}
+	_, err := os.Lstat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// matchAny reports whether one of patterns matches the directory with the
+// slash-separated relative path rel and base name name.
+func matchAny(patterns []string, rel, name string) bool {
+	for _, pattern := range patterns {
+		target := name
+		if strings.Contains(pattern, "/") {
+			target = rel
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package repos
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// makeTree creates each of dirs, given slash-separated, under a temporary
+// root and returns the root.
+func makeTree(t *testing.T, dirs ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, d := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(d)), 0o750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// rels returns paths relative to root, slash-separated.
+func rels(t *testing.T, root string, paths []string) []string {
+	t.Helper()
+	out := []string{}
+	for _, p := range paths {
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, filepath.ToSlash(rel))
+	}
+	return out
+}
+
+func TestFind_ClaudeOrGitDirectories(t *testing.T) {
+	root := makeTree(t,
+		"api/.git",
+		"web/.claude",
+		"notes/docs",
+		"group/tool/.git",
+		"group/tool/vendor/lib/.git",
+		"group/worktree",
+	)
+	// A worktree's .git is a file.
+	if err := os.WriteFile(filepath.Join(root, "group", "worktree", ".git"), []byte("gitdir: x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := Find([]string{root}, Options{})
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	want := []string{"api", "group/tool", "group/worktree", "web"}
+	if got := rels(t, root, found); !reflect.DeepEqual(got, want) {
+		t.Errorf("Find = %v; want %v (not inside a repo or in notes)", got, want)
+	}
+}
+
+func TestFind_RootIsRepo(t *testing.T) {
+	root := makeTree(t, ".git", "sub/.git")
+
+	found, err := Find([]string{root}, Options{})
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if !reflect.DeepEqual(found, []string{root}) {
+		t.Errorf("Find = %v; want only the root", found)
+	}
+}
+
+func TestFind_MaxDepth(t *testing.T) {
+	root := makeTree(t, "a/.git", "b/c/.git", "d/e/f/g/.git")
+
+	found, err := Find([]string{root}, Options{MaxDepth: 2})
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if got, want := rels(t, root, found), []string{"a", "b/c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Find with MaxDepth 2 = %v; want %v", got, want)
+	}
+
+	found, err = Find([]string{root}, Options{})
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if got, want := rels(t, root, found), []string{"a", "b/c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Find with the default depth = %v; want %v", got, want)
+	}
+}
+
+func TestFind_IncludeAndExclude(t *testing.T) {
+	root := makeTree(t,
+		"svc-api/.git",
+		"svc-web/.git",
+		"tools/.git",
+		"node_modules/pkg/.git",
+		"archive/svc-old/.git",
+		".cache/svc-tmp/.git",
+	)
+
+	found, err := Find([]string{root}, Options{
+		Include: []string{"svc-*"},
+		Exclude: []string{"node_modules", "archive/*"},
+	})
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if got, want := rels(t, root, found), []string{"svc-api", "svc-web"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Find = %v; want %v", got, want)
+	}
+}
+
+func TestFind_SeveralRootsWithoutDuplicates(t *testing.T) {
+	root := makeTree(t, "work/api/.git", "oss/lib/.claude")
+
+	found, err := Find([]string{filepath.Join(root, "work"), root}, Options{})
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if got, want := rels(t, root, found), []string{"work/api", "oss/lib"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Find = %v; want %v", got, want)
+	}
+}
+
+func TestFind_MissingRoot(t *testing.T) {
+	if _, err := Find([]string{filepath.Join(t.TempDir(), "missing")}, Options{}); err == nil {
+		t.Error("want error for a missing root")
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	if err := ValidatePattern("svc-*"); err != nil {
+		t.Errorf("ValidatePattern(svc-*) = %v", err)
+	}
+	for _, p := range []string{"", "[a-"} {
+		if err := ValidatePattern(p); err == nil {
+			t.Errorf("ValidatePattern(%q): want error", p)
+		}
+	}
+}
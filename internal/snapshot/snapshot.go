@@ -0,0 +1,121 @@
+// Package snapshot records the master settings last applied to a local
+// settings file, so later merges can tell master changes from local edits.
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+
+	"github.com/jeff/claude-config-merge/internal/jsonc"
+)
+
+// Snapshot is the persisted state for one local settings file.
+type Snapshot struct {
+	// Master is the master settings as they were when last applied.
+	Master map[string]any `json:"master"`
+	// Introduced lists the dotted keys the tool added to local settings from
+	// master. Only these keys are candidates for removal when master drops them.
+	Introduced []string `json:"introduced,omitempty"`
+}
+
+// Path returns the snapshot location for the settings file at localPath. The
+// snapshot lives next to the settings file as a hidden file.
+func Path(localPath string) string {
+	return filepath.Join(filepath.Dir(localPath), ".claude-config-merge-base-"+filepath.Base(localPath))
+}
+
+// Load reads the snapshot at path. A missing file is not an error — it returns
+// a nil Snapshot so callers fall back to a two-way merge.
+func Load(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading snapshot %s: %w", path, err)
+	}
+
+	var s Snapshot
+	// Decode numbers as json.Number, like the settings files they are
+	// compared against.
+	if err := jsonc.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing snapshot %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Rebase returns master with each of the dotted keys in keep set to its value
+// in prev, or removed where prev lacks it. Neither map is modified.
+func Rebase(master, prev map[string]any, keep []string) map[string]any {
+	out := master
+	for _, key := range keep {
+		out = rebaseKey(out, prev, key)
+	}
+	return out
+}
+
+// rebaseKey returns a copy of m with key set as in prev. A key not found
+// whole at this level is looked up as a path through the nested objects.
+func rebaseKey(m, prev map[string]any, key string) map[string]any {
+	out := maps.Clone(m)
+	if out == nil {
+		out = map[string]any{}
+	}
+	_, inMaster := m[key]
+	_, inPrev := prev[key]
+	if !inMaster && !inPrev {
+		for i := range len(key) {
+			if key[i] != '.' {
+				continue
+			}
+			head := key[:i]
+			sub, isMap := m[head].(map[string]any)
+			prevSub, prevIsMap := prev[head].(map[string]any)
+			if isMap || prevIsMap {
+				out[head] = rebaseKey(sub, prevSub, key[i+1:])
+				return out
+			}
+		}
+	}
+	if v, ok := prev[key]; ok {
+		out[key] = v
+	} else {
+		delete(out, key)
+	}
+	return out
+}
+
+// Save atomically writes s to path.
+func Save(path string, s *Snapshot) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".snapshot-*")
+	if err != nil {
+		return fmt.Errorf("creating temp snapshot file: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer func() {
+		if tmpName != "" {
+			_ = os.Remove(tmpName)
+		}
+	}()
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temp snapshot: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("finalising snapshot %s: %w", path, err)
+	}
+	tmpName = "" // disarm defer
+	return nil
+}
@@ -0,0 +1,78 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPath_NextToSettings(t *testing.T) {
+	got := Path("/home/u/.claude/settings.json")
+	want := "/home/u/.claude/.claude-config-merge-base-settings.json"
+	if got != want {
+		t.Errorf("Path = %q; want %q", got, want)
+	}
+}
+
+func TestLoad_MissingFileReturnsNil(t *testing.T) {
+	s, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s != nil {
+		t.Errorf("Load = %+v; want nil", s)
+	}
+}
+
+func TestSaveLoad_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.json")
+
+	if err := Save(path, &Snapshot{Master: map[string]any{"model": "opus"}}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if s == nil || s.Master["model"] != "opus" {
+		t.Errorf("Load = %+v; want master.model = opus", s)
+	}
+}
+
+func TestLoad_InvalidJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.json")
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected error for invalid snapshot, got nil")
+	}
+}
+
+func TestRebase_KeepsPreviousBaseForKeys(t *testing.T) {
+	master := map[string]any{
+		"model": "opus",
+		"env":   map[string]any{"MODE": "a", "DEBUG": "1"},
+		"new":   "n",
+	}
+	prev := map[string]any{
+		"model": "sonnet",
+		"env":   map[string]any{"MODE": "b"},
+	}
+
+	got := Rebase(master, prev, []string{"env.MODE", "new"})
+
+	want := map[string]any{
+		"model": "opus",
+		"env":   map[string]any{"MODE": "b", "DEBUG": "1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Rebase = %v; want %v", got, want)
+	}
+	if env := master["env"].(map[string]any); env["MODE"] != "a" {
+		t.Errorf("master modified: %v", master)
+	}
+}
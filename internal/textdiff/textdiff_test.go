@@ -0,0 +1,68 @@
+package textdiff
+
+import "testing"
+
+func TestUnified_Equal(t *testing.T) {
+	if got := Unified("a", "b", "x\ny\n", "x\ny\n", 3); got != "" {
+		t.Errorf("Unified = %q; want empty for equal input", got)
+	}
+}
+
+func TestUnified_SingleHunk(t *testing.T) {
+	a := "{\n  \"a\": 1,\n  \"b\": 2\n}\n"
+	b := "{\n  \"a\": 1,\n  \"b\": 3,\n  \"c\": 4\n}\n"
+
+	got := Unified("local", "merged", a, b, 3)
+	want := `--- local
++++ merged
+@@ -1,4 +1,5 @@
+ {
+   "a": 1,
+-  "b": 2
++  "b": 3,
++  "c": 4
+ }
+`
+	if got != want {
+		t.Errorf("Unified =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnified_SeparateHunks(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	b := "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n"
+
+	got := Unified("a", "b", a, b, 1)
+	want := `--- a
++++ b
+@@ -1,2 +1,2 @@
+-1
++one
+ 2
+@@ -9,2 +9,2 @@
+ 9
+-10
++ten
+`
+	if got != want {
+		t.Errorf("Unified =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnified_FromEmpty(t *testing.T) {
+	got := Unified("a", "b", "", "x\n", 3)
+	want := "--- a\n+++ b\n@@ -0,0 +1 @@\n+x\n"
+	if got != want {
+		t.Errorf("Unified = %q; want %q", got, want)
+	}
+}
+
+func TestStat(t *testing.T) {
+	added, removed := Stat("a\nb\nc\n", "a\nB\nc\nd\n")
+	if added != 2 || removed != 1 {
+		t.Errorf("Stat = +%d -%d; want +2 -1", added, removed)
+	}
+	if added, removed := Stat("x\n", "x\n"); added != 0 || removed != 0 {
+		t.Errorf("Stat(equal) = +%d -%d; want +0 -0", added, removed)
+	}
+}